@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gpontesss/routesim/cmd/routesim/internal/config"
 	"github.com/gpontesss/routesim/pkg/routesim"
@@ -17,9 +20,12 @@ func RouteSimCmd() *cobra.Command {
 
 var (
 	cfgPath     string
+	seed        int64
 	routeSimCmd = &cobra.Command{
 		Use:   "routesim",
 		Short: "GPS route simulator",
+		// Failures happen while running, not from misusing flags
+		SilenceUsage: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			var (
 				cfg config.Config
@@ -32,14 +38,36 @@ var (
 			if err := json.NewDecoder(cfgFile).Decode(&cfg); err != nil {
 				return fmt.Errorf("Error loading config file: %v", err)
 			}
+			if cmd.Flags().Changed("seed") {
+				cfg.Seed = &seed
+			}
 			if sim, err = cfg.BuildRouteSim(); err != nil {
 				return err
 			}
-			return sim.Run()
+			ctx, cancel := interruptContext()
+			defer cancel()
+			return sim.Run(ctx)
 		},
 	}
 )
 
+// Returns a context that is canceled when the process is interrupted or
+// terminated, so the simulation can stop gracefully
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigc:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigc)
+	}()
+	return ctx, cancel
+}
+
 func init() {
 	routeSimCmd.Flags().StringVarP(
 		&cfgPath,
@@ -47,4 +75,9 @@ func init() {
 		"c",
 		"routesim.json",
 		"Path to configuration file")
+	routeSimCmd.PersistentFlags().Int64Var(
+		&seed,
+		"seed",
+		0,
+		"Seed for random choices; overrides the configuration's")
 }
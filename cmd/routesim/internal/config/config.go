@@ -4,107 +4,329 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/clock"
 	"github.com/gpontesss/routesim/pkg/data"
 	"github.com/gpontesss/routesim/pkg/gps"
 	"github.com/gpontesss/routesim/pkg/routesim"
-	"github.com/jonas-p/go-shp"
 )
 
 // Config describes a JSON configuration for RouteSim
 type Config struct {
 	GPSCfgArray     []GPSConfig     `json:"gps"`
 	PublisherConfig PublisherConfig `json:"publisher"`
+	// Publishers that positions are published to at once. It replaces
+	// PublisherConfig.
+	Publishers  []PublisherConfig `json:"publishers"`
+	ClockConfig ClockConfig       `json:"clock"`
+	// Seed for every random choice of the simulation, e.g. devices' IDs. Runs
+	// with the same seed and a virtual clock publish identical data. If unset,
+	// a random seed is used.
+	Seed *int64 `json:"seed"`
+	// If set, every GPS is driven by a single scheduler, instead of each one
+	// by its own FreqEmitter. It suits large fleets.
+	Scheduler *SchedulerConfig `json:"scheduler"`
+	// If set, an HTTP/JSON API controls the fleet while it runs. GPSs are then
+	// driven by a scheduler.
+	Control *ControlConfig `json:"control"`
+}
+
+// Env gathers the state shared by the components of a simulation while they
+// are built
+type Env struct {
+	Clock clock.Clock
+	// Source of every random choice. Components that make random choices
+	// while running should derive their own source from it, so their results
+	// don't depend on scheduling.
+	Rand *rand.Rand
+}
+
+// BuildEnv assembles the shared state of a simulation
+func (cfg Config) BuildEnv() (Env, error) {
+	clk, err := cfg.ClockConfig.BuildClock()
+	if err != nil {
+		return Env{}, fmt.Errorf("Error building clock: %w", err)
+	}
+
+	seed := time.Now().UnixNano()
+	if cfg.Seed != nil {
+		seed = *cfg.Seed
+	}
+
+	return Env{
+		Clock: clk,
+		Rand:  rand.New(rand.NewSource(seed)),
+	}, nil
 }
 
 // BuildRouteSim assembles a RouteSim
 func (cfg Config) BuildRouteSim() (*routesim.RouteSim, error) {
-	emts := make([]*routesim.FreqEmitter, 0, len(cfg.GPSCfgArray))
-	for _, gpsCfg := range cfg.GPSCfgArray {
-		emt, err := gpsCfg.BuildFreqEmitter()
-		if err != nil {
-			return nil, fmt.Errorf("Error building PosEmitter: %w", err)
-		}
-		emts = append(emts, emt)
+	env, err := cfg.BuildEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Control != nil {
+		return cfg.buildControlledRouteSim(env)
 	}
 
-	pub, err := cfg.PublisherConfig.BuildPublisher()
+	emts, err := cfg.BuildEmitters(env)
 	if err != nil {
+		return nil, err
+	}
+
+	pub, err := cfg.BuildPublisher()
+	if err != nil {
+		stopEmitters(emts)
 		return nil, fmt.Errorf("Error building Publisher: %w", err)
 	}
 	return routesim.NewRouteSim(emts, pub), nil
 }
 
-// GPSConfig describes a JSON configuration for a GPS and FreqEmitter
+// Stops emitters that won't run, so their tickers are released
+func stopEmitters(emts []routesim.Emitter) {
+	for _, emt := range emts {
+		emt.Stop()
+	}
+}
+
+// BuildEmitters assembles the emitters of every GPS: a FreqEmitter for each
+// one, or a single scheduler if configured. It fails if two GPSs have the same
+// ID, in which case the emitters already built are stopped.
+func (cfg Config) BuildEmitters(env Env) ([]routesim.Emitter, error) {
+	if cfg.Scheduler != nil {
+		sched, err := cfg.BuildScheduler(env)
+		if err != nil {
+			return nil, err
+		}
+		return []routesim.Emitter{sched}, nil
+	}
+
+	var emts []routesim.Emitter
+	err := cfg.eachGPS(env, func(gpsCfg GPSConfig, sgps gps.GPS) error {
+		emts = append(emts, gpsCfg.BuildFreqEmitter(sgps, env))
+		return nil
+	})
+	if err != nil {
+		stopEmitters(emts)
+		return nil, err
+	}
+	return emts, nil
+}
+
+// BuildScheduler assembles a scheduler that drives every GPS. Its resolution
+// defaults to the greatest common divisor of the GPSs' frequencies.
+func (cfg Config) BuildScheduler(env Env) (*routesim.Scheduler, error) {
+	var res time.Duration
+	if cfg.Scheduler != nil {
+		res = time.Duration(cfg.Scheduler.Resolution)
+	}
+	if res < 0 {
+		return nil, errors.New("Scheduler resolution must be positive")
+	}
+	if res == 0 {
+		for _, gpsCfg := range cfg.GPSCfgArray {
+			res = gcd(res, time.Duration(gpsCfg.Frequency))
+		}
+		if res <= 0 {
+			return nil, errors.New("Can't infer scheduler resolution from GPS frequencies; it must be set")
+		}
+	}
+
+	sched := routesim.NewScheduler(env.Clock, res)
+	err := cfg.eachGPS(env, func(gpsCfg GPSConfig, sgps gps.GPS) error {
+		return sched.Add(sgps, time.Duration(gpsCfg.Frequency))
+	})
+	if err != nil {
+		sched.Stop()
+		return nil, err
+	}
+	return sched, nil
+}
+
+// Builds every GPS and calls f with each of them, in order. It fails if two
+// GPSs have the same ID.
+func (cfg Config) eachGPS(env Env, f func(GPSConfig, gps.GPS) error) error {
+	ids := map[string]int{}
+	for i, gpsCfg := range cfg.GPSCfgArray {
+		gpss, err := gpsCfg.BuildGPSs(env)
+		if err != nil {
+			return fmt.Errorf("Error building GPS: %w", err)
+		}
+		for _, sgps := range gpss {
+			if j, ok := ids[sgps.ID()]; ok {
+				return fmt.Errorf("Duplicate GPS ID '%s' (gps %d and %d)", sgps.ID(), j, i)
+			}
+			ids[sgps.ID()] = i
+			if err := f(gpsCfg, sgps); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func gcd(a, b time.Duration) time.Duration {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// SchedulerConfig describes a JSON configuration for a scheduler that drives
+// every GPS
+type SchedulerConfig struct {
+	// How often the scheduler checks for due emissions. It should divide every
+	// GPS's frequency. Defaults to their greatest common divisor.
+	Resolution Duration `json:"resolution"`
+}
+
+// GPSConfig describes a JSON configuration for a GPS and FreqEmitter. Route
+// sources with many paths, e.g. every feature of a shapefile, spawn a GPS and
+// FreqEmitter for each path.
 type GPSConfig struct {
-	// Relative path for shapefile describing GPS's route
-	ShapefilePath string `json:"shapefile"`
+	RouteSource
+	// ID, or template for the IDs, of the simulated devices. See IDTemplate.
+	ID IDTemplate `json:"id"`
 	// Route mode that describes the behavior of the route when it reaches the
 	// geometry's end
 	Mode WalkingModeGPS `json:"mode"`
 	// Frequency in seconds that new positions should be sent
 	Frequency Frequency `json:"frequency"`
-	// GPS's distance rate of change (m/s)
+	// GPS's distance rate of change (m/s). It is the cruise speed if a
+	// velocity model is set.
 	Velocity float64 `json:"velocity"`
+	// Acceleration, speed bounds and profiles of the GPS. It keeps a constant
+	// velocity if unset.
+	VelocityModel *VelocityModelConfig `json:"velocityModel"`
 	// Metadata to attach to the simulated device
 	Metadata map[string]interface{} `json:"metadata"`
+	// Recorded log that is replayed instead of walking a route. Mode,
+	// velocity and velocity model are then ignored.
+	Replay *ReplaySource `json:"replay"`
 }
 
-// BuildFreqEmitter assembles a FreqEmitter
-func (cfg GPSConfig) BuildFreqEmitter() (*routesim.FreqEmitter, error) {
-	sgps, err := cfg.BuildGPS()
-	if err != nil {
-		return nil, fmt.Errorf("Error building GPS: %w", err)
-	}
-	return routesim.NewFreqEmitter(
+// BuildFreqEmitter assembles a FreqEmitter for a GPS
+func (cfg GPSConfig) BuildFreqEmitter(sgps gps.GPS, env Env) *routesim.FreqEmitter {
+	return routesim.FreqEmitterWithClock(
 		sgps,
 		time.Duration(cfg.Frequency),
-	), nil
+		env.Clock,
+	)
 }
 
-// BuildGPS assembles a SimGPS
-func (cfg GPSConfig) BuildGPS() (gps.GPS, error) {
-	shprdr, err := shp.Open(cfg.ShapefilePath)
-	if err != nil {
-		return nil, err
+// BuildGPSs assembles a SimGPS for each path of the route source, or a
+// ReplayGPS for each device of the replayed log
+func (cfg GPSConfig) BuildGPSs(env Env) ([]gps.GPS, error) {
+	if cfg.Replay != nil {
+		return cfg.buildReplayGPSs(env)
 	}
 
-	path, err := s2PolylineFromShpReader(shprdr)
+	paths, err := cfg.BuildPaths()
 	if err != nil {
-		return nil, fmt.Errorf("Error reading '%s': %w", cfg.ShapefilePath, err)
+		return nil, err
 	}
 
-	lw, err := BuildLineWalker(cfg.Mode, path)
-	if err != nil {
-		return nil, fmt.Errorf("Error building line walker: %w", err)
+	gpss := make([]gps.GPS, len(paths))
+	for i, path := range paths {
+		lw, err := BuildLineWalker(cfg.Mode, path)
+		if err != nil {
+			return nil, fmt.Errorf("Error building line walker: %w", err)
+		}
+		id, err := cfg.ID.execute(i, env.Rand)
+		if err != nil {
+			return nil, err
+		}
+		var model gps.VelocityModel
+		if cfg.VelocityModel != nil {
+			model = cfg.VelocityModel.build(env.Rand)
+		}
+		if gpss[i], err = gps.SimGPSWithModel(id, cfg.Velocity, lw, model, cfg.Metadata, env.Clock); err != nil {
+			return nil, fmt.Errorf("Invalid velocity model: %w", err)
+		}
 	}
+	return gpss, nil
+}
 
-	return gps.NewSimGPS(cfg.Velocity, lw, cfg.Metadata), nil
+// ClockConfig describes a JSON configuration for the simulation clock
+type ClockConfig struct {
+	// Clock mode
+	Mode ClockMode `json:"mode"`
+	// How many times faster than the wall clock a scaled clock runs
+	Speed float64 `json:"speed"`
+	// Simulated start time. Defaults to the current time.
+	Start *time.Time `json:"start"`
+	// How long a virtual clock runs. If unset, it runs forever.
+	Duration Duration `json:"duration"`
 }
 
-// Gets a s2.Polyline from a shpfile reader
-func s2PolylineFromShpReader(rdr *shp.Reader) (*s2.Polyline, error) {
-	defer rdr.Close()
+// BuildClock assembles a simulation clock
+func (cfg ClockConfig) BuildClock() (clock.Clock, error) {
+	start := time.Now()
+	if cfg.Start != nil {
+		start = *cfg.Start
+	}
 
-	if rdr.GeometryType != shp.POLYLINE {
-		return nil, errors.New("Geometry type must be POLYLINE")
+	switch cfg.Mode {
+	case RealClock:
+		if cfg.Start != nil {
+			return nil, errors.New("A real clock can't have a start time")
+		}
+		return clock.Real(), nil
+	case ScaledClock:
+		if cfg.Speed <= 0 {
+			return nil, errors.New("Clock speed must be positive")
+		}
+		return clock.Scaled(start, cfg.Speed), nil
+	case VirtualClock:
+		var end time.Time
+		if cfg.Duration > 0 {
+			end = start.Add(time.Duration(cfg.Duration))
+		}
+		return clock.Virtual(start, end), nil
+	default:
+		return nil, fmt.Errorf("Unknown clock mode '%s'", cfg.Mode)
 	}
+}
 
-	// The first geometry is the chosen
-	rdr.Next()
+// ClockMode identifies a simulation clock mode
+type ClockMode string
 
-	_, shape := rdr.Shape()
-	pl := shape.(*shp.PolyLine)
+const (
+	// RealClock identifies a clock that follows the wall clock
+	RealClock ClockMode = ""
+	// ScaledClock identifies a clock that runs faster than the wall clock
+	ScaledClock = "Scaled"
+	// VirtualClock identifies a clock that advances as fast as positions are
+	// published
+	VirtualClock = "Virtual"
+)
 
-	coords := make([]s2.LatLng, len(pl.Points))
-	for i, pt := range pl.Points {
-		coords[i] = s2.LatLngFromDegrees(pt.X, pt.Y)
+// UnmarshalJSON unmarshals a ClockMode
+func (m *ClockMode) UnmarshalJSON(v []byte) error {
+	var s string
+	if err := json.Unmarshal(v, &s); err != nil {
+		return err
 	}
 
-	return s2.PolylineFromLatLngs(coords), nil
+	switch strings.ToLower(s) {
+	case "", "real":
+		*m = RealClock
+	case "scaled":
+		*m = ScaledClock
+	case "virtual":
+		*m = VirtualClock
+	default:
+		return fmt.Errorf("Unknown clock mode '%s'", s)
+	}
+	return nil
 }
 
 // BuildLineWalker assembles a LineWalker
@@ -148,16 +370,132 @@ func (m *WalkingModeGPS) UnmarshalJSON(v []byte) error {
 	return nil
 }
 
+// BuildPublisher assembles the simulation's publisher. If many publishers are
+// configured, or a publisher has a filter or failure policy, they are
+// multiplexed.
+func (cfg Config) BuildPublisher() (data.PosPublisher, error) {
+	pubCfgs := cfg.Publishers
+	if len(pubCfgs) == 0 {
+		pubCfgs = []PublisherConfig{cfg.PublisherConfig}
+	} else if cfg.PublisherConfig.Type != "" {
+		return nil, errors.New("Either publisher or publishers may be set, not both")
+	}
+
+	if len(pubCfgs) == 1 && pubCfgs[0].Filter == nil && pubCfgs[0].OnFailure == "" {
+		return pubCfgs[0].BuildPublisher()
+	}
+
+	routes := make([]data.Route, 0, len(pubCfgs))
+	for i, pubCfg := range pubCfgs {
+		route, err := pubCfg.buildRoute()
+		if err != nil {
+			// Publishers already built may hold resources, e.g. servers
+			for _, built := range routes {
+				data.Close(built.Publisher)
+			}
+			return nil, fmt.Errorf("Error building publisher %d: %w", i, err)
+		}
+		routes = append(routes, route)
+	}
+	return data.Multiplexer(routes...), nil
+}
+
 // PublisherConfig describes a JSON configuration for a position publisher
 type PublisherConfig struct {
 	// Publisher type name
 	Type PublisherType `json:"type"`
 	// Options specific for publisher
 	Options json.RawMessage `json:"options"`
+	// Selects the positions published. If unset, every position is.
+	Filter *FilterConfig `json:"filter,omitempty"`
+	// What to do when the publisher fails, along with others: abort, skip or
+	// disable. Defaults to abort.
+	OnFailure string `json:"onFailure,omitempty"`
+	// How failed positions are retried. If unset, they aren't.
+	Retry *RetryConfig `json:"retry,omitempty"`
+}
+
+// RetryConfig describes a JSON configuration for retrying failed positions
+type RetryConfig struct {
+	// Number of times a failed position is retried
+	MaxRetries int `json:"maxRetries"`
+	// Wait time before the first retry, which doubles on each attempt
+	Backoff Duration `json:"backoff,omitempty"`
+	// Maximum wait time between retries
+	MaxBackoff Duration `json:"maxBackoff,omitempty"`
+	// Publisher that receives positions whose retries are exhausted, e.g. a
+	// log to stderr. If unset, they are handled as failures.
+	DeadLetter *PublisherConfig `json:"deadLetter,omitempty"`
 }
 
-// BuildPublisher assembles a PosPublisher
+// Assembles a multiplexer route for the publisher
+func (cfg PublisherConfig) buildRoute() (data.Route, error) {
+	policy, err := data.ParseFailurePolicy(cfg.OnFailure)
+	if err != nil {
+		return data.Route{}, err
+	}
+	var filter *data.PosFilter
+	if cfg.Filter != nil {
+		if filter, err = cfg.Filter.build(); err != nil {
+			return data.Route{}, err
+		}
+	}
+	pub, err := cfg.BuildPublisher()
+	if err != nil {
+		return data.Route{}, err
+	}
+	return data.Route{Publisher: pub, Filter: filter, OnFailure: policy}, nil
+}
+
+// FilterConfig describes a JSON configuration for a position filter. Every
+// criterion that is set must match.
+type FilterConfig struct {
+	// GPS IDs that match
+	IDs []string `json:"ids,omitempty"`
+	// Regular expression that GPS IDs must match
+	IDPattern string `json:"idPattern,omitempty"`
+	// Metadata values that GPSs must have
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// Assembles a position filter
+func (cfg FilterConfig) build() (*data.PosFilter, error) {
+	filter := &data.PosFilter{IDs: cfg.IDs, Metadata: cfg.Metadata}
+	if cfg.IDPattern != "" {
+		re, err := regexp.Compile(cfg.IDPattern)
+		if err != nil {
+			return nil, fmt.Errorf("Error compiling ID pattern: %w", err)
+		}
+		filter.IDPattern = re
+	}
+	return filter, nil
+}
+
+// BuildPublisher assembles a PosPublisher, which retries failed positions if
+// configured to
 func (cfg PublisherConfig) BuildPublisher() (data.PosPublisher, error) {
+	pub, err := cfg.buildType()
+	if err != nil || cfg.Retry == nil {
+		return pub, err
+	}
+
+	var deadLetter data.PosPublisher
+	if cfg.Retry.DeadLetter != nil {
+		if deadLetter, err = cfg.Retry.DeadLetter.BuildPublisher(); err != nil {
+			data.Close(pub)
+			return nil, fmt.Errorf("Error building dead letter publisher: %w", err)
+		}
+	}
+	return data.RetryPublisher(pub, data.RetryConfig{
+		MaxRetries: cfg.Retry.MaxRetries,
+		Backoff:    time.Duration(cfg.Retry.Backoff),
+		MaxBackoff: time.Duration(cfg.Retry.MaxBackoff),
+		DeadLetter: deadLetter,
+	}), nil
+}
+
+// Assembles the PosPublisher of the configured type
+func (cfg PublisherConfig) buildType() (data.PosPublisher, error) {
 	switch cfg.Type {
 	case KinesisPublisher:
 		var kcfg kinesisPubCfg
@@ -168,17 +506,30 @@ func (cfg PublisherConfig) BuildPublisher() (data.PosPublisher, error) {
 		if err != nil {
 			return nil, err
 		}
-		return data.PosFormatterPublisher(
-			data.KinesisPublisher(kcfg.StreamName),
-			fmtr,
-		), nil
+		return data.KinesisPublisher(data.KinesisConfig{
+			StreamName:      kcfg.StreamName,
+			Region:          kcfg.Region,
+			Endpoint:        kcfg.Endpoint,
+			AccessKeyID:     kcfg.AccessKeyID,
+			SecretAccessKey: kcfg.SecretAccessKey,
+			SessionToken:    kcfg.SessionToken,
+			BatchSize:       kcfg.BatchSize,
+			Linger:          time.Duration(kcfg.Linger),
+			MaxRetries:      kcfg.MaxRetries,
+			MaxBuffered:     kcfg.MaxBuffered,
+		}, fmtr)
 
 	case ShpfilePublisher:
 		var shpcfg shpPubCfg
 		if err := json.Unmarshal(cfg.Options, &shpcfg); err != nil {
 			return nil, err
 		}
-		return data.ShpfilePublisher(shpcfg.FilePath, shpcfg.Count)
+		return data.ShpfilePublisher(data.ShpfileConfig{
+			Path:           shpcfg.FilePath,
+			Count:          shpcfg.Count,
+			MetadataFields: shpcfg.Metadata,
+			Tracks:         shpcfg.Tracks,
+		})
 
 	case WebsocketPublisher:
 		var wscfg wsCfg
@@ -194,6 +545,64 @@ func (cfg PublisherConfig) BuildPublisher() (data.PosPublisher, error) {
 			fmtr,
 		), nil
 
+	case LogPublisher:
+		var lcfg logPubCfg
+		if err := json.Unmarshal(cfg.Options, &lcfg); err != nil {
+			return nil, err
+		}
+		return lcfg.build()
+
+	case GPSDPublisher:
+		var gcfg gpsdPubCfg
+		if err := json.Unmarshal(cfg.Options, &gcfg); err != nil {
+			return nil, err
+		}
+		if gcfg.Address == "" {
+			gcfg.Address = "localhost:2947"
+		}
+		return data.GPSDPublisher(data.GPSDConfig{
+			Address: gcfg.Address,
+			GPSID:   gcfg.GPSID,
+		})
+
+	case KafkaPublisher:
+		var kcfg kafkaPubCfg
+		if err := json.Unmarshal(cfg.Options, &kcfg); err != nil {
+			return nil, err
+		}
+		return kcfg.build()
+
+	case WebhookPublisher:
+		var wcfg webhookPubCfg
+		if err := json.Unmarshal(cfg.Options, &wcfg); err != nil {
+			return nil, err
+		}
+		return wcfg.build()
+
+	case FilePublisher:
+		var fcfg filePubCfg
+		if err := json.Unmarshal(cfg.Options, &fcfg); err != nil {
+			return nil, err
+		}
+		return fcfg.build()
+
+	case GPXPublisher, KMLPublisher:
+		var tcfg trackPubCfg
+		if err := json.Unmarshal(cfg.Options, &tcfg); err != nil {
+			return nil, err
+		}
+		if cfg.Type == GPXPublisher {
+			return data.GPXPublisher(tcfg.Path)
+		}
+		return data.KMLPublisher(tcfg.Path)
+
+	case MQTTPublisher:
+		var mcfg mqttPubCfg
+		if err := json.Unmarshal(cfg.Options, &mcfg); err != nil {
+			return nil, err
+		}
+		return mcfg.build()
+
 	default:
 		return nil, errors.New("Unkonwn publisher type")
 	}
@@ -209,6 +618,23 @@ const (
 	ShpfilePublisher = "Shpfile"
 	// WebsocketPublisher identifies a Websocket position publisher
 	WebsocketPublisher = "Websocket"
+	// LogPublisher identifies a log (stdout/stderr) position publisher
+	LogPublisher = "Log"
+	// GPSDPublisher identifies a gpsd-compatible server position publisher
+	GPSDPublisher = "GPSD"
+	// MQTTPublisher identifies an MQTT position publisher
+	MQTTPublisher = "MQTT"
+	// KafkaPublisher identifies a Kafka position publisher
+	KafkaPublisher = "Kafka"
+	// WebhookPublisher identifies an HTTP webhook position publisher
+	WebhookPublisher = "Webhook"
+	// FilePublisher identifies a position publisher of newline-delimited
+	// records into files
+	FilePublisher = "File"
+	// GPXPublisher identifies a GPX track position publisher
+	GPXPublisher = "GPX"
+	// KMLPublisher identifies a KML track position publisher
+	KMLPublisher = "KML"
 )
 
 // UnmarshalJSON ummarshals a PublisherType
@@ -225,6 +651,22 @@ func (t *PublisherType) UnmarshalJSON(v []byte) error {
 		*t = ShpfilePublisher
 	case "websocket":
 		*t = WebsocketPublisher
+	case "log":
+		*t = LogPublisher
+	case "gpsd":
+		*t = GPSDPublisher
+	case "mqtt":
+		*t = MQTTPublisher
+	case "kafka":
+		*t = KafkaPublisher
+	case "webhook":
+		*t = WebhookPublisher
+	case "file":
+		*t = FilePublisher
+	case "gpx":
+		*t = GPXPublisher
+	case "kml":
+		*t = KMLPublisher
 	default:
 		return fmt.Errorf("Unknown publisher type '%s'", s)
 	}
@@ -232,13 +674,26 @@ func (t *PublisherType) UnmarshalJSON(v []byte) error {
 }
 
 type kinesisPubCfg struct {
-	StreamName string        `json:"stream"`
-	Format     FormatterType `json:"format"`
+	StreamName      string        `json:"stream"`
+	Format          FormatterType `json:"format"`
+	Region          string        `json:"region,omitempty"`
+	Endpoint        string        `json:"endpoint,omitempty"`
+	AccessKeyID     string        `json:"accessKeyId,omitempty"`
+	SecretAccessKey string        `json:"secretAccessKey,omitempty"`
+	SessionToken    string        `json:"sessionToken,omitempty"`
+	BatchSize       int           `json:"batchSize,omitempty"`
+	Linger          Duration      `json:"linger,omitempty"`
+	MaxRetries      int           `json:"maxRetries,omitempty"`
+	MaxBuffered     int           `json:"maxBuffered,omitempty"`
 }
 
 type shpPubCfg struct {
 	Count    int32  `json:"count"`
 	FilePath string `json:"path"`
+	// GPS metadata keys written to the DBF
+	Metadata []string `json:"metadata,omitempty"`
+	// Writes a track per device instead of points
+	Tracks bool `json:"tracks,omitempty"`
 }
 
 type wsCfg struct {
@@ -247,6 +702,266 @@ type wsCfg struct {
 	Path    string        `json:"path"`
 }
 
+type gpsdPubCfg struct {
+	// Defaults to gpsd's usual address, localhost:2947
+	Address string `json:"address,omitempty"`
+	// ID of the GPS whose positions are reported
+	GPSID string `json:"gpsId"`
+}
+
+type mqttPubCfg struct {
+	// Broker URL, e.g. tcp://localhost:1883 or ssl://broker:8883
+	Broker   string `json:"broker"`
+	ClientID string `json:"clientId,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Topic template, e.g. fleet/{{.GPS.ID}}/position
+	Topic          string        `json:"topic"`
+	QoS            byte          `json:"qos,omitempty"`
+	Retain         bool          `json:"retain,omitempty"`
+	TLS            *tlsCfg       `json:"tls,omitempty"`
+	Format         FormatterType `json:"format,omitempty"`
+	ConnectTimeout Duration      `json:"connectTimeout,omitempty"`
+	PublishTimeout Duration      `json:"publishTimeout,omitempty"`
+}
+
+// Assembles an MQTT PosPublisher
+func (cfg mqttPubCfg) build() (data.PosPublisher, error) {
+	fmtr, err := cfg.Format.GetFormatter()
+	if err != nil {
+		return nil, err
+	}
+	return data.MQTTPublisher(data.MQTTConfig{
+		BrokerURL:      cfg.Broker,
+		ClientID:       cfg.ClientID,
+		Username:       cfg.Username,
+		Password:       cfg.Password,
+		Topic:          cfg.Topic,
+		QoS:            cfg.QoS,
+		Retain:         cfg.Retain,
+		TLS:            cfg.TLS.build(),
+		ConnectTimeout: time.Duration(cfg.ConnectTimeout),
+		PublishTimeout: time.Duration(cfg.PublishTimeout),
+	}, fmtr)
+}
+
+type kafkaPubCfg struct {
+	Brokers     []string      `json:"brokers"`
+	Topic       string        `json:"topic"`
+	Format      FormatterType `json:"format,omitempty"`
+	BatchSize   int           `json:"batchSize,omitempty"`
+	Linger      Duration      `json:"linger,omitempty"`
+	Compression string        `json:"compression,omitempty"`
+	// Required acknowledgements: all (default), leader or none
+	Acks         string   `json:"acks,omitempty"`
+	WriteTimeout Duration `json:"writeTimeout,omitempty"`
+	MaxBuffered  int      `json:"maxBuffered,omitempty"`
+	SASL         *struct {
+		// plain, scram-sha-256 or scram-sha-512
+		Mechanism string `json:"mechanism"`
+		Username  string `json:"username"`
+		Password  string `json:"password"`
+	} `json:"sasl,omitempty"`
+	TLS *tlsCfg `json:"tls,omitempty"`
+}
+
+// Assembles a Kafka PosPublisher
+func (cfg kafkaPubCfg) build() (data.PosPublisher, error) {
+	fmtr, err := cfg.Format.GetFormatter()
+	if err != nil {
+		return nil, err
+	}
+	var acks int
+	switch strings.ToLower(cfg.Acks) {
+	case "", "all":
+		acks = -1
+	case "leader":
+		acks = 1
+	case "none":
+		acks = 0
+	default:
+		return nil, fmt.Errorf("Unknown Kafka acks '%s'", cfg.Acks)
+	}
+	kcfg := data.KafkaConfig{
+		Brokers:      cfg.Brokers,
+		Topic:        cfg.Topic,
+		BatchSize:    cfg.BatchSize,
+		Linger:       time.Duration(cfg.Linger),
+		Compression:  cfg.Compression,
+		RequiredAcks: acks,
+		WriteTimeout: time.Duration(cfg.WriteTimeout),
+		MaxBuffered:  cfg.MaxBuffered,
+		TLS:          cfg.TLS.build(),
+	}
+	if cfg.SASL != nil {
+		kcfg.SASLMechanism = cfg.SASL.Mechanism
+		kcfg.SASLUsername = cfg.SASL.Username
+		kcfg.SASLPassword = cfg.SASL.Password
+	}
+	return data.KafkaPublisher(kcfg, fmtr)
+}
+
+type webhookPubCfg struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	BearerToken string            `json:"bearerToken,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	Password    string            `json:"password,omitempty"`
+	Format      FormatterType     `json:"format,omitempty"`
+	// single (default), array or ndjson
+	Batching    string   `json:"batching,omitempty"`
+	BatchSize   int      `json:"batchSize,omitempty"`
+	Linger      Duration `json:"linger,omitempty"`
+	Timeout     Duration `json:"timeout,omitempty"`
+	MaxRetries  int      `json:"maxRetries,omitempty"`
+	Backoff     Duration `json:"backoff,omitempty"`
+	MaxBackoff  Duration `json:"maxBackoff,omitempty"`
+	MaxInFlight int      `json:"maxInFlight,omitempty"`
+	TLS         *tlsCfg  `json:"tls,omitempty"`
+}
+
+// Assembles a webhook PosPublisher
+func (cfg webhookPubCfg) build() (data.PosPublisher, error) {
+	fmtr, err := cfg.Format.GetFormatter()
+	if err != nil {
+		return nil, err
+	}
+	batching, err := data.ParseWebhookBatching(cfg.Batching)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := data.WebhookPublisher(data.WebhookConfig{
+		URL:         cfg.URL,
+		Method:      cfg.Method,
+		Headers:     cfg.Headers,
+		BearerToken: cfg.BearerToken,
+		Username:    cfg.Username,
+		Password:    cfg.Password,
+		ContentType: data.ContentType(fmtr),
+		Batching:    batching,
+		BatchSize:   cfg.BatchSize,
+		Linger:      time.Duration(cfg.Linger),
+		Timeout:     time.Duration(cfg.Timeout),
+		MaxRetries:  cfg.MaxRetries,
+		Backoff:     time.Duration(cfg.Backoff),
+		MaxBackoff:  time.Duration(cfg.MaxBackoff),
+		MaxInFlight: cfg.MaxInFlight,
+		TLS:         cfg.TLS.build(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data.PosFormatterPublisher(pub, fmtr), nil
+}
+
+type filePubCfg struct {
+	Path   string        `json:"path"`
+	Format FormatterType `json:"format,omitempty"`
+	// CSV columns. See data.CSVFormatter.
+	Columns []string `json:"columns,omitempty"`
+	// Rotation size, in bytes
+	MaxSize   int64    `json:"maxSize,omitempty"`
+	Interval  Duration `json:"interval,omitempty"`
+	PerDevice bool     `json:"perDevice,omitempty"`
+	Gzip      bool     `json:"gzip,omitempty"`
+	// Maximum number of files kept open at once
+	MaxOpen int `json:"maxOpen,omitempty"`
+}
+
+// Assembles a file PosPublisher
+func (cfg filePubCfg) build() (data.PosPublisher, error) {
+	var (
+		fmtr data.PosFormatter
+		err  error
+	)
+	if cfg.Format == CSVFormatter {
+		fmtr, err = data.CSVFormatter(cfg.Columns)
+	} else if len(cfg.Columns) > 0 {
+		err = errors.New("Columns are only supported by the CSV format")
+	} else {
+		fmtr, err = cfg.Format.GetFormatter()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data.FilePublisher(data.FileConfig{
+		Path:      cfg.Path,
+		MaxSize:   cfg.MaxSize,
+		Interval:  time.Duration(cfg.Interval),
+		PerDevice: cfg.PerDevice,
+		Gzip:      cfg.Gzip,
+		MaxOpen:   cfg.MaxOpen,
+	}, fmtr)
+}
+
+type trackPubCfg struct {
+	// Path of the GPX or KML document
+	Path string `json:"path"`
+}
+
+type tlsCfg struct {
+	CAFile             string `json:"caFile,omitempty"`
+	CertFile           string `json:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+}
+
+// Converts to the publishers' TLS configuration. It is nil if unset.
+func (cfg *tlsCfg) build() *data.TLSConfig {
+	if cfg == nil {
+		return nil
+	}
+	return &data.TLSConfig{
+		CAFile:             cfg.CAFile,
+		CertFile:           cfg.CertFile,
+		KeyFile:            cfg.KeyFile,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+}
+
+type logPubCfg struct {
+	// Minimum log level: debug, info, warn or error
+	Level string `json:"level"`
+	// Writes JSON lines instead of human-readable text
+	Structured bool `json:"structured,omitempty"`
+	// Output stream: stdout or stderr
+	Output string `json:"output,omitempty"`
+	// Optional formatter for the logged payload
+	Format FormatterType `json:"format,omitempty"`
+}
+
+// Assembles a log PosPublisher
+func (cfg logPubCfg) build() (data.PosPublisher, error) {
+	lvl, err := data.ParseLogLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	var wtr io.Writer
+	switch strings.ToLower(cfg.Output) {
+	case "", "stdout":
+		wtr = os.Stdout
+	case "stderr":
+		wtr = os.Stderr
+	default:
+		return nil, fmt.Errorf("Unknown log output '%s'", cfg.Output)
+	}
+
+	var fmtr data.PosFormatter
+	if cfg.Format != NotSpecifiedFormatter {
+		if fmtr, err = cfg.Format.GetFormatter(); err != nil {
+			return nil, err
+		}
+	}
+
+	return data.LogPublisher(wtr, data.LogConfig{
+		Level:      lvl,
+		Structured: cfg.Structured,
+		Formatter:  fmtr,
+	}), nil
+}
+
 // Frequency is the frequency that a GPS position should be emitted
 type Frequency time.Duration
 
@@ -266,6 +981,25 @@ func (f *Frequency) UnmarshalJSON(v []byte) error {
 	return nil
 }
 
+// Duration is a time duration described by a string, e.g. "500ms"
+type Duration time.Duration
+
+// UnmarshalJSON unmarshals a Duration
+func (d *Duration) UnmarshalJSON(v []byte) error {
+	var s string
+	if err := json.Unmarshal(v, &s); err != nil {
+		return err
+	}
+
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(dur)
+	return nil
+}
+
 // FormatterType identifies a PosFormatter type
 type FormatterType string
 
@@ -274,6 +1008,14 @@ const (
 	NotSpecifiedFormatter FormatterType = ""
 	// GeoJSONFormatter identifies a GeoJSON formatter
 	GeoJSONFormatter = "GeoJSON"
+	// NMEAFormatter identifies an NMEA 0183 formatter of GGA and RMC sentences
+	NMEAFormatter = "NMEA"
+	// NMEAVTGFormatter identifies an NMEA 0183 formatter of GGA, RMC and VTG
+	// sentences
+	NMEAVTGFormatter = "NMEA+VTG"
+	// CSVFormatter identifies a CSV formatter, of the default columns unless
+	// a publisher allows choosing them
+	CSVFormatter = "CSV"
 )
 
 // GetFormatter returns a PosFormatter instance according to its type
@@ -281,6 +1023,12 @@ func (t FormatterType) GetFormatter() (data.PosFormatter, error) {
 	switch t {
 	case NotSpecifiedFormatter, GeoJSONFormatter:
 		return data.GeoJSONFormatter, nil
+	case NMEAFormatter:
+		return data.NMEAFormatter, nil
+	case NMEAVTGFormatter:
+		return data.NMEAVTGFormatter, nil
+	case CSVFormatter:
+		return data.CSVFormatter(nil)
 	default:
 		return nil, fmt.Errorf("Unknown formatter '%s'", t)
 	}
@@ -295,6 +1043,12 @@ func (t *FormatterType) UnmarshalJSON(v []byte) error {
 	switch strings.ToLower(s) {
 	case "geojson":
 		*t = GeoJSONFormatter
+	case "nmea":
+		*t = NMEAFormatter
+	case "nmea+vtg":
+		*t = NMEAVTGFormatter
+	case "csv":
+		*t = CSVFormatter
 	default:
 		return fmt.Errorf("Unknown formatter type '%s'", s)
 	}
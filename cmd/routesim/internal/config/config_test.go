@@ -1 +1,284 @@
 package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gpontesss/routesim/pkg/clock"
+	"github.com/gpontesss/routesim/pkg/data"
+	"github.com/gpontesss/routesim/pkg/routesim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const seededConfig = `{
+	"seed": 42,
+	"clock": {"mode": "virtual", "start": "2020-10-01T00:00:00Z", "duration": "10m"},
+	"gps": [
+		{"shapefile": "../../../../samples/paths/pinheiros.shp", "mode": "restart", "frequency": "10s", "velocity": 10},
+		{"shapefile": "../../../../samples/paths/pinheiros.shp", "mode": "backandforth", "frequency": "10s", "velocity": 20}
+	]
+}`
+
+// Runs a simulation of a configuration and returns its published data
+func runConfig(t *testing.T, raw string) []byte {
+	var cfg Config
+	require.NoError(t, json.Unmarshal([]byte(raw), &cfg))
+
+	env, err := cfg.BuildEnv()
+	require.NoError(t, err)
+
+	emts, err := cfg.BuildEmitters(env)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	pub := data.LogPublisher(&buf, data.LogConfig{Structured: true})
+	require.NoError(t, routesim.NewRouteSim(emts, pub).Run(context.Background()))
+	return buf.Bytes()
+}
+
+func TestSeededRunsAreReproducible(t *testing.T) {
+	first := runConfig(t, seededConfig)
+	second := runConfig(t, seededConfig)
+
+	assert.Equal(t, 120, bytes.Count(first, []byte("\n")))
+	assert.Equal(t, string(first), string(second))
+}
+
+func TestSchedulerRunMatchesEmitters(t *testing.T) {
+	var raw map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(seededConfig), &raw))
+	raw["scheduler"] = map[string]interface{}{}
+	scheduled, err := json.Marshal(raw)
+	require.NoError(t, err)
+
+	assert.Equal(t, string(runConfig(t, seededConfig)), string(runConfig(t, string(scheduled))))
+}
+
+func TestIDTemplate(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	cases := map[IDTemplate]*regexp.Regexp{
+		"TEST1234":                    regexp.MustCompile(`^TEST1234$`),
+		"bus-{{index}}":               regexp.MustCompile(`^bus-3$`),
+		`{{printf "bus-%03d" index}}`: regexp.MustCompile(`^bus-003$`),
+		"{{imei}}":                    regexp.MustCompile(`^\d{15}$`),
+		"":                            regexp.MustCompile(`^[0-9a-f-]{36}$`),
+		`{{uuid5 "bus-3"}}`:           regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-5`),
+	}
+	for tmpl, re := range cases {
+		id, err := tmpl.execute(3, rng)
+		require.NoError(t, err)
+		assert.Regexp(t, re, id, string(tmpl))
+	}
+
+	var tmpl IDTemplate
+	assert.Error(t, json.Unmarshal([]byte(`"{{unknown}}"`), &tmpl))
+}
+
+func TestDuplicateIDs(t *testing.T) {
+	var cfg Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"gps": [
+			{"id": "bus-1", "shapefile": "../../../../samples/paths/pinheiros.shp", "mode": "restart", "frequency": "1s"},
+			{"id": "bus-{{index}}", "shapefile": "../../../../samples/paths/pinheiros.shp", "mode": "restart", "frequency": "1s"},
+			{"id": "bus-1", "shapefile": "../../../../samples/paths/pinheiros.shp", "mode": "restart", "frequency": "1s"}
+		]
+	}`), &cfg))
+
+	env, err := cfg.BuildEnv()
+	require.NoError(t, err)
+	clk := &tickerCountClock{Clock: env.Clock}
+	env.Clock = clk
+	_, err = cfg.BuildEmitters(env)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Duplicate GPS ID 'bus-1' (gps 0 and 2)")
+	// The emitters built before the error are stopped
+	assert.Equal(t, 0, clk.running())
+
+	cfg.Scheduler = &SchedulerConfig{}
+	_, err = cfg.BuildEmitters(env)
+	require.Error(t, err)
+	assert.Equal(t, 0, clk.running())
+}
+
+// Clock that counts its tickers that weren't stopped
+type tickerCountClock struct {
+	clock.Clock
+	mu    sync.Mutex
+	count int
+}
+
+func (c *tickerCountClock) NewTicker(period time.Duration) clock.Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count++
+	return &countedTicker{Ticker: c.Clock.NewTicker(period), clk: c}
+}
+
+func (c *tickerCountClock) running() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.count
+}
+
+type countedTicker struct {
+	clock.Ticker
+	clk  *tickerCountClock
+	once sync.Once
+}
+
+func (t *countedTicker) Stop() {
+	t.once.Do(func() {
+		t.clk.mu.Lock()
+		t.clk.count--
+		t.clk.mu.Unlock()
+	})
+	t.Ticker.Stop()
+}
+
+func TestBuildPublishers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routesim-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cases := map[string]struct {
+		raw string
+		err string
+	}{
+		"Single":   {`{"publisher": {"type": "log", "options": {}}}`, ""},
+		"NMEA":     {`{"publisher": {"type": "log", "options": {"format": "nmea"}}}`, ""},
+		"Filtered": {`{"publisher": {"type": "log", "options": {}, "filter": {"idPattern": "^bus-"}}}`, ""},
+		"Many": {`{"publishers": [
+			{"type": "log", "options": {}, "filter": {"ids": ["bus-1"], "metadata": {"line": 8000}}},
+			{"type": "log", "options": {"output": "stderr"}, "onFailure": "disable"}
+		]}`, ""},
+		"Retry": {`{"publisher": {"type": "log", "options": {}, "retry": {
+			"maxRetries": 3, "backoff": "1s",
+			"deadLetter": {"type": "log", "options": {"output": "stderr"}}
+		}}}`, ""},
+		"BadDeadLetter": {
+			`{"publisher": {"type": "log", "options": {}, "retry": {"deadLetter": {"type": "log", "options": {"level": "loud"}}}}}`,
+			"dead letter",
+		},
+		"Both": {
+			`{"publisher": {"type": "log", "options": {}}, "publishers": [{"type": "log", "options": {}}]}`,
+			"Either publisher or publishers",
+		},
+		"BadPattern":   {`{"publishers": [{"type": "log", "options": {}, "filter": {"idPattern": "("}}]}`, "ID pattern"},
+		"BadPolicy":    {`{"publishers": [{"type": "log", "options": {}, "onFailure": "retry"}]}`, "Unknown failure policy"},
+		"GPSD":         {`{"publisher": {"type": "gpsd", "options": {"address": "127.0.0.1:0", "gpsId": "bus-1"}}}`, ""},
+		"MQTTBadTopic": {`{"publisher": {"type": "mqtt", "options": {"broker": "tcp://127.0.0.1:1", "topic": "{{.GPS"}}}`, "topic template"},
+		"Kafka": {`{"publisher": {"type": "kafka", "options": {
+			"brokers": ["localhost:9092"], "topic": "fleet", "compression": "snappy", "acks": "leader",
+			"sasl": {"mechanism": "plain", "username": "fleet", "password": "secret"}
+		}}}`, ""},
+		"KafkaBadAcks": {`{"publisher": {"type": "kafka", "options": {"brokers": ["localhost:9092"], "topic": "fleet", "acks": "some"}}}`, "acks"},
+		"Webhook": {`{"publisher": {"type": "webhook", "options": {
+			"url": "http://localhost:8080/positions", "batching": "ndjson", "batchSize": 50, "maxInFlight": 4
+		}}}`, ""},
+		"WebhookBadBatching": {`{"publisher": {"type": "webhook", "options": {"url": "http://localhost", "batching": "xml"}}}`, "batching"},
+		"File": {`{"publisher": {"type": "file", "options": {
+			"path": "` + dir + `/positions.csv", "format": "csv", "columns": ["id", "time", "metadata.line"],
+			"interval": "1h", "perDevice": true, "gzip": true
+		}}}`, ""},
+		"Shpfile": {`{"publisher": {"type": "shpfile", "options": {
+			"path": "` + dir + `/tracks.shp", "metadata": ["line"], "tracks": true
+		}}}`, ""},
+		"GPX":         {`{"publisher": {"type": "gpx", "options": {"path": "` + dir + `/tracks.gpx"}}}`, ""},
+		"KML":         {`{"publisher": {"type": "kml", "options": {"path": "` + dir + `/tracks.kml"}}}`, ""},
+		"FileColumns": {`{"publisher": {"type": "file", "options": {"path": "positions.ndjson", "columns": ["id"]}}}`, "only supported by the CSV"},
+		"NoGPSID":     {`{"publisher": {"type": "gpsd", "options": {"address": "127.0.0.1:0"}}}`, "GPS ID"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var cfg Config
+			require.NoError(t, json.Unmarshal([]byte(c.raw), &cfg))
+			pub, err := cfg.BuildPublisher()
+			if c.err == "" {
+				require.NoError(t, err)
+				data.Close(pub)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), c.err)
+			}
+		})
+	}
+}
+
+func TestReplayRecordedRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routesim-replay")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	recorded := runConfig(t, seededConfig)
+	path := dir + "/recorded.ndjson"
+	require.NoError(t, ioutil.WriteFile(path, recorded, 0644))
+
+	type key struct {
+		ID   string
+		Time time.Time
+	}
+	positions := func(raw []byte) map[key][2]float64 {
+		pos := map[key][2]float64{}
+		for _, line := range bytes.Split(bytes.TrimSpace(raw), []byte("\n")) {
+			var obj struct {
+				key
+				Lat, Lng float64
+			}
+			require.NoError(t, json.Unmarshal(line, &obj))
+			pos[obj.key] = [2]float64{obj.Lat, obj.Lng}
+		}
+		return pos
+	}
+
+	replayed := runConfig(t, `{
+		"clock": {"mode": "virtual", "start": "2021-01-01T00:00:00Z", "duration": "10m"},
+		"gps": [{"replay": {"path": "`+path+`"}, "frequency": "10s"}]
+	}`)
+	want, got := positions(recorded), positions(replayed)
+	// The replay starts at the first recorded positions, and its last ones
+	// repeat the end of the log
+	assert.Equal(t, 120, bytes.Count(replayed, []byte("\n")))
+	assert.Equal(t, 118, len(got))
+	for k, ll := range got {
+		require.Contains(t, want, k)
+		assert.InDelta(t, want[k][0], ll[0], 1e-9)
+		assert.InDelta(t, want[k][1], ll[1], 1e-9)
+	}
+}
+
+func TestVelocityModel(t *testing.T) {
+	raw := `{
+		"seed": 42,
+		"clock": {"mode": "virtual", "start": "2020-10-01T00:00:00Z", "duration": "10m"},
+		"gps": [{
+			"shapefile": "../../../../samples/paths/pinheiros.shp", "mode": "restart", "frequency": "10s", "velocity": 12,
+			"velocityModel": {
+				"minVelocity": 2, "maxVelocity": 14, "acceleration": 1.5, "deceleration": 3, "variation": 0.2,
+				"profiles": [{"to": 500, "velocity": 5}]
+			}
+		}]
+	}`
+	first := runConfig(t, raw)
+	assert.Equal(t, 60, bytes.Count(first, []byte("\n")))
+	assert.Equal(t, string(first), string(runConfig(t, raw)))
+
+	var cfg Config
+	require.NoError(t, json.Unmarshal([]byte(`{"gps": [{
+		"shapefile": "../../../../samples/paths/pinheiros.shp", "mode": "restart", "frequency": "10s",
+		"velocityModel": {"minVelocity": 10, "maxVelocity": 5}
+	}]}`), &cfg))
+	env, err := cfg.BuildEnv()
+	require.NoError(t, err)
+	_, err = cfg.BuildEmitters(env)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid velocity model")
+}
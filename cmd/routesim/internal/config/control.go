@@ -0,0 +1,78 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gpontesss/routesim/pkg/control"
+	"github.com/gpontesss/routesim/pkg/data"
+	"github.com/gpontesss/routesim/pkg/routesim"
+)
+
+// ControlConfig describes a JSON configuration for the control API
+type ControlConfig struct {
+	// Address the API listens on, e.g. "localhost:8080"
+	Address string `json:"address"`
+}
+
+// Assembles a RouteSim whose GPSs are driven by a scheduler, which is
+// controlled by the API until the simulation stops
+func (cfg Config) buildControlledRouteSim(env Env) (*routesim.RouteSim, error) {
+	if cfg.Control.Address == "" {
+		return nil, errors.New("Control API address must be set")
+	}
+
+	sched, err := cfg.BuildScheduler(env)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := cfg.BuildPublisher()
+	if err != nil {
+		sched.Stop()
+		return nil, fmt.Errorf("Error building Publisher: %w", err)
+	}
+
+	srv := control.NewServer(sched, gpsSpawner(env))
+	lst, err := srv.Listen(cfg.Control.Address)
+	if err != nil {
+		sched.Stop()
+		data.Close(pub)
+		return nil, err
+	}
+
+	sim := routesim.NewRouteSim([]routesim.Emitter{sched}, pub)
+	sim.CloseOnStop(lst)
+	return sim, nil
+}
+
+// Returns a spawner that builds GPSs from a GPSConfig
+func gpsSpawner(env Env) control.Spawner {
+	// Requests are handled concurrently, but the random source isn't safe for
+	// concurrent use
+	var mu sync.Mutex
+	return func(desc json.RawMessage) ([]control.Spawned, error) {
+		var gpsCfg GPSConfig
+		if err := json.Unmarshal(desc, &gpsCfg); err != nil {
+			return nil, err
+		}
+		if gpsCfg.Frequency <= 0 {
+			return nil, errors.New("GPS frequency must be positive")
+		}
+
+		mu.Lock()
+		gpss, err := gpsCfg.BuildGPSs(env)
+		mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		spawned := make([]control.Spawned, len(gpss))
+		for i, sgps := range gpss {
+			spawned[i] = control.Spawned{GPS: sgps, Frequency: time.Duration(gpsCfg.Frequency)}
+		}
+		return spawned, nil
+	}
+}
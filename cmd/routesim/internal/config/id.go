@@ -0,0 +1,78 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"text/template"
+
+	"github.com/google/uuid"
+	"github.com/gpontesss/routesim/pkg/gps"
+)
+
+// IDTemplate is a template for the IDs of the devices spawned by a GPSConfig.
+// It is a text/template that may call the following functions:
+//
+//	index      position of the device among the ones of the GPSConfig
+//	imei       random IMEI, with a valid check digit
+//	uuid       random UUID (version 4)
+//	uuid5 NAME UUID (version 5) derived from NAME, in the URL namespace
+//
+// For example, "bus-{{index}}" or `{{uuid5 (printf "bus-%d" index)}}`. An empty
+// template generates random UUIDs.
+type IDTemplate string
+
+// UnmarshalJSON unmarshals an IDTemplate
+func (tmpl *IDTemplate) UnmarshalJSON(v []byte) error {
+	var s string
+	if err := json.Unmarshal(v, &s); err != nil {
+		return err
+	}
+	if _, err := IDTemplate(s).parse(); err != nil {
+		return fmt.Errorf("Invalid ID template: %w", err)
+	}
+	*tmpl = IDTemplate(s)
+	return nil
+}
+
+// Parses the template
+func (tmpl IDTemplate) parse() (*template.Template, error) {
+	return template.New("id").Funcs(idFuncs(nil, 0)).Parse(string(tmpl))
+}
+
+// Generates the ID of the i-th device of a GPSConfig. Random choices are taken
+// from rng.
+func (tmpl IDTemplate) execute(i int, rng *rand.Rand) (string, error) {
+	if tmpl == "" {
+		tmpl = "{{uuid}}"
+	}
+	t, err := tmpl.parse()
+	if err != nil {
+		return "", fmt.Errorf("Invalid ID template: %w", err)
+	}
+
+	var sb strings.Builder
+	if err := t.Funcs(idFuncs(rng, i)).Execute(&sb, nil); err != nil {
+		return "", fmt.Errorf("Error generating ID: %w", err)
+	}
+	if sb.Len() == 0 {
+		return "", fmt.Errorf("ID template '%s' generated an empty ID", tmpl)
+	}
+	return sb.String(), nil
+}
+
+// Functions available to ID templates
+func idFuncs(rng *rand.Rand, i int) template.FuncMap {
+	return template.FuncMap{
+		"index": func() int { return i },
+		"imei":  func() string { return gps.RandomIMEI(rng) },
+		"uuid": func() (string, error) {
+			id, err := uuid.NewRandomFromReader(rng)
+			return id.String(), err
+		},
+		"uuid5": func(name string) string {
+			return uuid.NewSHA1(uuid.NameSpaceURL, []byte(name)).String()
+		},
+	}
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gpontesss/routesim/pkg/gps"
+	"github.com/gpontesss/routesim/pkg/route"
+)
+
+// ReplaySource describes a JSON configuration for a recorded position log that
+// is replayed, instead of walking a route. A GPS is spawned for each recorded
+// device.
+type ReplaySource struct {
+	// Relative path for the log
+	Path string `json:"path"`
+	// Format of the log. It is inferred from the path's extension if unset.
+	Format ReplayFormat `json:"format"`
+	// Selects a track of a GPX log
+	Track *GPXSelector `json:"track"`
+	// IDs of the recorded devices that are replayed. Every device is replayed
+	// if unset.
+	IDs []string `json:"ids"`
+	// How many times faster than recorded the log is replayed. Defaults to 1.
+	Speed float64 `json:"speed"`
+	// Restarts the replay when the log ends
+	Loop bool `json:"loop"`
+	// Reports positions at the simulation's time, instead of at the recorded
+	// time
+	ShiftToNow bool `json:"shiftToNow"`
+}
+
+// BuildRecordings reads the recordings of the selected devices from the log
+func (src ReplaySource) BuildRecordings() ([]route.Recording, error) {
+	format := src.Format
+	if format == NotSpecifiedReplay {
+		if err := format.fromExt(src.Path); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.Open(src.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var recs []route.Recording
+	switch format {
+	case NDJSONReplay:
+		recs, err = route.FromNDJSON(f)
+	case CSVReplay:
+		recs, err = route.FromCSV(f)
+	case GPXReplay:
+		var sel route.GPXSelector
+		if src.Track != nil {
+			sel = src.Track.selector()
+		}
+		var trk route.Track
+		trk, err = route.FromGPX(f, sel)
+		recs = []route.Recording{{Track: trk}}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading '%s': %w", src.Path, err)
+	}
+	return src.selectIDs(recs)
+}
+
+// Keeps the recordings of the chosen devices
+func (src ReplaySource) selectIDs(recs []route.Recording) ([]route.Recording, error) {
+	if len(src.IDs) == 0 {
+		return recs, nil
+	}
+	byID := map[string]route.Recording{}
+	for _, rec := range recs {
+		byID[rec.ID] = rec
+	}
+	selected := make([]route.Recording, len(src.IDs))
+	for i, id := range src.IDs {
+		rec, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("'%s' has no device '%s'", src.Path, id)
+		}
+		selected[i] = rec
+	}
+	return selected, nil
+}
+
+func (src ReplaySource) options() gps.ReplayOptions {
+	return gps.ReplayOptions{
+		Speed:      src.Speed,
+		Loop:       src.Loop,
+		ShiftToNow: src.ShiftToNow,
+	}
+}
+
+// Assembles a ReplayGPS for each recorded device. Devices keep their recorded
+// IDs, unless an ID template is set or the log has none.
+func (cfg GPSConfig) buildReplayGPSs(env Env) ([]gps.GPS, error) {
+	if cfg.sourceCount() > 0 {
+		return nil, errors.New("Either a route source or replay must be set")
+	}
+	recs, err := cfg.Replay.BuildRecordings()
+	if err != nil {
+		return nil, err
+	}
+
+	gpss := make([]gps.GPS, len(recs))
+	for i, rec := range recs {
+		id := rec.ID
+		if id == "" || cfg.ID != "" {
+			if id, err = cfg.ID.execute(i, env.Rand); err != nil {
+				return nil, err
+			}
+		}
+		gpss[i], err = gps.NewReplayGPS(id, rec.Track, cfg.Replay.options(), cfg.Metadata, env.Clock)
+		if err != nil {
+			return nil, fmt.Errorf("Error replaying '%s': %w", id, err)
+		}
+	}
+	return gpss, nil
+}
+
+// ReplayFormat identifies the format of a replayed log
+type ReplayFormat string
+
+const (
+	// NotSpecifiedReplay identifies that a log format wasn't specified
+	NotSpecifiedReplay ReplayFormat = ""
+	// NDJSONReplay identifies a log with a JSON object per line. See
+	// route.FromNDJSON.
+	NDJSONReplay = "NDJSON"
+	// CSVReplay identifies a CSV log. See route.FromCSV.
+	CSVReplay = "CSV"
+	// GPXReplay identifies a GPX track with times
+	GPXReplay = "GPX"
+)
+
+// UnmarshalJSON unmarshals a ReplayFormat
+func (f *ReplayFormat) UnmarshalJSON(v []byte) error {
+	var s string
+	if err := json.Unmarshal(v, &s); err != nil {
+		return err
+	}
+	switch strings.ToLower(s) {
+	case "ndjson", "jsonl":
+		*f = NDJSONReplay
+	case "csv":
+		*f = CSVReplay
+	case "gpx":
+		*f = GPXReplay
+	default:
+		return fmt.Errorf("Unknown replay format '%s'", s)
+	}
+	return nil
+}
+
+// Infers the format from a path's extension
+func (f *ReplayFormat) fromExt(path string) error {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	if err := f.UnmarshalJSON([]byte(`"` + ext + `"`)); err != nil {
+		return fmt.Errorf("Can't infer the format of '%s'; it must be set", path)
+	}
+	return nil
+}
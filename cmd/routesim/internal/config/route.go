@@ -0,0 +1,217 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/route"
+	"github.com/jonas-p/go-shp"
+)
+
+// RouteSource describes a JSON configuration for the file that a GPS's route is
+// read from. Only one file must be set.
+type RouteSource struct {
+	// Relative path for shapefile describing GPS's route
+	ShapefilePath string `json:"shapefile"`
+	// Selects features of the shapefile
+	Shape *ShapeSelector `json:"shape"`
+	// Relative path for GeoJSON file describing GPS's route
+	GeoJSONPath string `json:"geojson"`
+	// Selects a feature when the GeoJSON file is a FeatureCollection
+	Feature *FeatureSelector `json:"feature"`
+	// Order of the coordinate pairs: "xy" (longitude, latitude; default) or
+	// "yx" (latitude, longitude). Applies to shapefiles and GeoJSON.
+	AxisOrder AxisOrder `json:"axisOrder"`
+	// Relative path for GPX file describing GPS's route
+	GPXPath string `json:"gpx"`
+	// Selects a track, segment or route of the GPX file
+	Track *GPXSelector `json:"track"`
+}
+
+// BuildPaths reads the routes from the configured source
+func (src RouteSource) BuildPaths() ([]*s2.Polyline, error) {
+	switch {
+	case src.sourceCount() > 1:
+		return nil, errors.New("Only one route source must be set")
+
+	case src.ShapefilePath != "":
+		shprdr, err := shp.Open(src.ShapefilePath)
+		if err != nil {
+			return nil, err
+		}
+		defer shprdr.Close()
+		var sel route.ShapeSelector
+		if src.Shape != nil {
+			sel = src.Shape.selector()
+		}
+		crs, err := shapefileCRS(src.ShapefilePath)
+		if err != nil {
+			return nil, err
+		}
+		coords := route.Coordinates{CRS: crs, Axis: route.AxisOrder(src.AxisOrder)}
+		paths, err := route.FromShapefile(shprdr, sel, coords)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading '%s': %w", src.ShapefilePath, err)
+		}
+		return paths, nil
+
+	case src.GeoJSONPath != "":
+		f, err := os.Open(src.GeoJSONPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		var sel route.FeatureSelector
+		if src.Feature != nil {
+			sel = src.Feature.selector()
+		}
+		coords := route.Coordinates{Axis: route.AxisOrder(src.AxisOrder)}
+		path, err := route.FromGeoJSON(f, sel, coords)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading '%s': %w", src.GeoJSONPath, err)
+		}
+		return []*s2.Polyline{path}, nil
+
+	case src.GPXPath != "":
+		f, err := os.Open(src.GPXPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		var sel route.GPXSelector
+		if src.Track != nil {
+			sel = src.Track.selector()
+		}
+		trk, err := route.FromGPX(f, sel)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading '%s': %w", src.GPXPath, err)
+		}
+		return []*s2.Polyline{trk.Polyline()}, nil
+
+	default:
+		return nil, errors.New("A route source must be set")
+	}
+}
+
+// Counts how many route sources are set
+func (src RouteSource) sourceCount() int {
+	n := 0
+	for _, p := range []string{src.ShapefilePath, src.GeoJSONPath, src.GPXPath} {
+		if p != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Reads the CRS from the shapefile's .prj file. Shapefiles without one are
+// assumed to be in WGS84.
+func shapefileCRS(shpPath string) (route.CRS, error) {
+	prjPath := strings.TrimSuffix(shpPath, filepath.Ext(shpPath)) + ".prj"
+	wkt, err := ioutil.ReadFile(prjPath)
+	if os.IsNotExist(err) {
+		return route.WGS84, nil
+	} else if err != nil {
+		return nil, err
+	}
+	crs, err := route.ParseWKT(string(wkt))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading '%s': %w", prjPath, err)
+	}
+	return crs, nil
+}
+
+// AxisOrder identifies the order of coordinate pairs
+type AxisOrder route.AxisOrder
+
+// UnmarshalJSON unmarshals an AxisOrder
+func (o *AxisOrder) UnmarshalJSON(v []byte) error {
+	var s string
+	if err := json.Unmarshal(v, &s); err != nil {
+		return err
+	}
+
+	switch strings.ToLower(s) {
+	case "", "xy", "lnglat":
+		*o = AxisOrder(route.XYOrder)
+	case "yx", "latlng":
+		*o = AxisOrder(route.YXOrder)
+	default:
+		return fmt.Errorf("Unknown axis order '%s'", s)
+	}
+	return nil
+}
+
+// ShapeSelector describes a JSON configuration for selecting features of a
+// shapefile
+type ShapeSelector struct {
+	// Selects every matching feature; a GPS is spawned for each one
+	All bool `json:"all,omitempty"`
+	// Position of the feature in the file
+	Index *int `json:"index,omitempty"`
+	// Name of a DBF attribute that must have Value
+	Attribute string `json:"attribute,omitempty"`
+	Value     string `json:"value,omitempty"`
+	// Position of the part of a multi-part polyline. Parts are chained if
+	// unset.
+	Part *int `json:"part,omitempty"`
+}
+
+func (sel ShapeSelector) selector() route.ShapeSelector {
+	return route.ShapeSelector{
+		All:       sel.All,
+		Index:     sel.Index,
+		Attribute: sel.Attribute,
+		Value:     sel.Value,
+		Part:      sel.Part,
+	}
+}
+
+// FeatureSelector describes a JSON configuration for selecting a feature of a
+// collection. Only one criterion should be set.
+type FeatureSelector struct {
+	// Position of the feature in the collection
+	Index *int `json:"index,omitempty"`
+	// Feature's ID
+	ID interface{} `json:"id,omitempty"`
+	// Name of a property that must have Value
+	Property string      `json:"property,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+}
+
+func (sel FeatureSelector) selector() route.FeatureSelector {
+	return route.FeatureSelector{
+		Index:    sel.Index,
+		ID:       sel.ID,
+		Property: sel.Property,
+		Value:    sel.Value,
+	}
+}
+
+// GPXSelector describes a JSON configuration for selecting a path of a GPX
+// file
+type GPXSelector struct {
+	// Name of a track or route
+	Name string `json:"name,omitempty"`
+	// Position of the track in the file
+	Track *int `json:"track,omitempty"`
+	// Position of the segment in the track. All segments are joined if unset.
+	Segment *int `json:"segment,omitempty"`
+	// Position of the route in the file
+	Route *int `json:"route,omitempty"`
+}
+
+func (sel GPXSelector) selector() route.GPXSelector {
+	return route.GPXSelector{
+		Name:    sel.Name,
+		Track:   sel.Track,
+		Segment: sel.Segment,
+		Route:   sel.Route,
+	}
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/gpontesss/routesim/pkg/gps"
+)
+
+// VelocityModelConfig describes a JSON configuration for how a GPS's speed
+// changes as it walks its route. See gps.VelocityModel.
+type VelocityModelConfig struct {
+	// Bounds of the speed (m/s). A maxVelocity of 0 means no bound.
+	MinVelocity float64 `json:"minVelocity"`
+	MaxVelocity float64 `json:"maxVelocity"`
+	// Limits of the speed's rate of change (m/s²). A limit of 0 means the
+	// speed changes at once.
+	Acceleration float64 `json:"acceleration"`
+	Deceleration float64 `json:"deceleration"`
+	// Random variation of the target speed, as a fraction of it. It is drawn
+	// from a source derived from the simulation's seed.
+	Variation float64 `json:"variation"`
+	// How often a new variation is drawn. Defaults to 10s.
+	VariationPeriod Duration `json:"variationPeriod"`
+	// Target speeds of ranges of the route, e.g. slow in its first 500 m
+	Profiles []SpeedProfileConfig `json:"profiles"`
+}
+
+// SpeedProfileConfig describes a JSON configuration for the target speed of a
+// range of a route
+type SpeedProfileConfig struct {
+	// Start and end of the range, in meters from the route's start. An end of
+	// 0 means the route's end.
+	From float64 `json:"from"`
+	To   float64 `json:"to"`
+	// Target speed in the range (m/s)
+	Velocity float64 `json:"velocity"`
+}
+
+// Assembles the velocity model of a GPS. A random source is only derived
+// from rng if the speed varies, so seeded runs without variation are kept.
+func (cfg VelocityModelConfig) build(rng *rand.Rand) gps.VelocityModel {
+	model := gps.VelocityModel{
+		MinSpeed:        cfg.MinVelocity,
+		MaxSpeed:        cfg.MaxVelocity,
+		Acceleration:    cfg.Acceleration,
+		Deceleration:    cfg.Deceleration,
+		Variation:       cfg.Variation,
+		VariationPeriod: time.Duration(cfg.VariationPeriod),
+	}
+	if cfg.Variation > 0 {
+		model.Rand = rand.New(rand.NewSource(rng.Int63()))
+	}
+	for _, p := range cfg.Profiles {
+		model.Profiles = append(model.Profiles, gps.SpeedProfile{From: p.From, To: p.To, Speed: p.Velocity})
+	}
+	return model
+}
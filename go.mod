@@ -1,13 +1,33 @@
 module github.com/gpontesss/routesim
 
-go 1.13
+go 1.17
 
 require (
+	github.com/aws/aws-sdk-go v1.35.5
+	github.com/eclipse/paho.mqtt.golang v1.3.5
 	github.com/golang/geo v0.0.0-20200730024412-e86565bf3f35
 	github.com/google/uuid v1.1.2
-	github.com/jonas-p/go-shp v0.1.1
+	github.com/jonas-p/go-shp v0.1.2-0.20190401125246-9fd306ae10a6
 	github.com/paulmach/go.geojson v1.4.0
+	github.com/segmentio/kafka-go v0.4.47
 	github.com/spf13/cobra v1.0.0
-	github.com/stretchr/testify v1.6.1
-	golang.org/x/net v0.0.0-20201002202402-0a1ea396d57c
+	github.com/stretchr/testify v1.8.0
+	golang.org/x/net v0.17.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/gorilla/websocket v1.4.2 // indirect
+	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/spf13/pflag v1.0.3 // indirect
+	github.com/stretchr/objx v0.4.0 // indirect
+	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
+	github.com/xdg-go/scram v1.1.2 // indirect
+	github.com/xdg-go/stringprep v1.0.4 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
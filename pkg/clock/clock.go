@@ -0,0 +1,104 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time of a simulation and delivers periodic ticks
+type Clock interface {
+	// Now returns the current simulated time
+	Now() time.Time
+	// NewTicker creates a ticker that ticks every period of simulated time
+	NewTicker(period time.Duration) Ticker
+}
+
+// Ticker delivers periodic ticks of a Clock
+type Ticker interface {
+	// Next blocks until the next tick and returns its simulated time. It
+	// returns false if the ticker is stopped. Calling Next tells the clock that
+	// the previous tick was fully handled.
+	Next() (time.Time, bool)
+	// Stop stops the ticker. Next calls return false afterwards. It may be
+	// called concurrently with Next, e.g. to unblock it.
+	Stop()
+}
+
+// Real returns a clock that follows the wall clock
+func Real() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(period time.Duration) Ticker {
+	return newWallTicker(period, time.Now)
+}
+
+// Scaled returns a clock that runs factor times faster than the wall clock,
+// starting from start. A factor of 60, for instance, simulates an hour in a
+// minute.
+func Scaled(start time.Time, factor float64) Clock {
+	return &scaledClock{
+		start:     start,
+		realStart: time.Now(),
+		factor:    factor,
+	}
+}
+
+type scaledClock struct {
+	start, realStart time.Time
+	factor           float64
+}
+
+func (c *scaledClock) Now() time.Time {
+	elapsed := float64(time.Since(c.realStart)) * c.factor
+	return c.start.Add(time.Duration(elapsed))
+}
+
+// NewTicker creates a ticker of the scaled period. Periods that scale below a
+// nanosecond tick every nanosecond of wall time.
+func (c *scaledClock) NewTicker(period time.Duration) Ticker {
+	wall := time.Duration(float64(period) / c.factor)
+	if wall < 1 {
+		wall = 1
+	}
+	return newWallTicker(wall, c.Now)
+}
+
+// Ticker driven by a wall clock ticker. Ticks are reported with the time given
+// by nowFunc.
+type wallTicker struct {
+	ticker   *time.Ticker
+	nowFunc  func() time.Time
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newWallTicker(period time.Duration, nowFunc func() time.Time) *wallTicker {
+	return &wallTicker{
+		ticker:  time.NewTicker(period),
+		nowFunc: nowFunc,
+		done:    make(chan struct{}),
+	}
+}
+
+func (t *wallTicker) Next() (time.Time, bool) {
+	select {
+	case <-t.done:
+		return time.Time{}, false
+	case <-t.ticker.C:
+		return t.nowFunc(), true
+	}
+}
+
+func (t *wallTicker) Stop() {
+	t.stopOnce.Do(func() {
+		t.ticker.Stop()
+		close(t.done)
+	})
+}
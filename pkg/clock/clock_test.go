@@ -0,0 +1,110 @@
+package clock
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+
+func TestVirtualClock(t *testing.T) {
+	clk := Virtual(start, start.Add(time.Minute))
+	fast, slow := clk.NewTicker(10*time.Second), clk.NewTicker(30*time.Second)
+
+	var (
+		mu    sync.Mutex
+		ticks = map[time.Duration][]time.Time{}
+		wg    sync.WaitGroup
+	)
+	handle := func(period time.Duration, tkr Ticker) {
+		defer wg.Done()
+		for {
+			at, ok := tkr.Next()
+			if !ok {
+				return
+			}
+			// Time must not advance while a tick is being handled
+			time.Sleep(time.Millisecond)
+			assert.Equal(t, at, clk.Now())
+			mu.Lock()
+			ticks[period] = append(ticks[period], at)
+			mu.Unlock()
+		}
+	}
+	wg.Add(2)
+	go handle(10*time.Second, fast)
+	go handle(30*time.Second, slow)
+	wg.Wait()
+
+	require.Len(t, ticks[10*time.Second], 6)
+	require.Len(t, ticks[30*time.Second], 2)
+	assert.Equal(t, start.Add(10*time.Second), ticks[10*time.Second][0])
+	assert.Equal(t, start.Add(time.Minute), ticks[10*time.Second][5])
+	assert.Equal(t, start.Add(30*time.Second), ticks[30*time.Second][0])
+}
+
+func TestVirtualClockStop(t *testing.T) {
+	clk := Virtual(start, time.Time{})
+	stopped, running := clk.NewTicker(time.Second), clk.NewTicker(time.Second)
+	stopped.Stop()
+
+	_, ok := stopped.Next()
+	assert.False(t, ok)
+
+	for i := 1; i <= 3; i++ {
+		at, ok := running.Next()
+		require.True(t, ok)
+		assert.Equal(t, start.Add(time.Duration(i)*time.Second), at)
+	}
+}
+
+func TestScaledClock(t *testing.T) {
+	clk := Scaled(start, 1000)
+	tkr := clk.NewTicker(time.Second)
+	defer tkr.Stop()
+
+	at, ok := tkr.Next()
+	require.True(t, ok)
+	assert.WithinDuration(t, start.Add(time.Second), at, 500*time.Millisecond)
+}
+
+func TestScaledClockTinyPeriod(t *testing.T) {
+	// The period scales to less than a nanosecond of wall time
+	tkr := Scaled(start, 1e6).NewTicker(time.Microsecond / 2)
+	defer tkr.Stop()
+
+	_, ok := tkr.Next()
+	assert.True(t, ok)
+}
+
+func TestVirtualClockOrder(t *testing.T) {
+	clk := Virtual(start, start.Add(2*time.Second))
+	tkrs := []Ticker{clk.NewTicker(time.Second), clk.NewTicker(time.Second), clk.NewTicker(time.Second)}
+
+	order := make(chan int, 6)
+	var wg sync.WaitGroup
+	for i, tkr := range tkrs {
+		wg.Add(1)
+		go func(i int, tkr Ticker) {
+			defer wg.Done()
+			for {
+				if _, ok := tkr.Next(); !ok {
+					return
+				}
+				order <- i
+			}
+		}(i, tkr)
+	}
+	wg.Wait()
+	close(order)
+
+	var got []int
+	for i := range order {
+		got = append(got, i)
+	}
+	assert.Equal(t, []int{0, 1, 2, 0, 1, 2}, got)
+}
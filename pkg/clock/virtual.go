@@ -0,0 +1,137 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Virtual returns a clock whose time only advances when every one of its
+// tickers has handled its last tick. Time then jumps straight to the next
+// tick, so a simulation runs as fast as its tick handlers allow, e.g. as fast
+// as a publisher accepts positions. Ticks are delivered one at a time, in
+// order of time and then of ticker creation, so runs are reproducible. Once
+// time would go past end, every ticker is stopped; a zero end lets the clock
+// run forever.
+func Virtual(start, end time.Time) Clock {
+	clk := &virtualClock{
+		now:     start,
+		end:     end,
+		tickers: map[*virtualTicker]struct{}{},
+	}
+	clk.cond = sync.NewCond(&clk.mu)
+	return clk
+}
+
+type virtualClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	end     time.Time
+	tickers map[*virtualTicker]struct{}
+	// Number of tickers ever created
+	created int
+	// Tells if the clock reached its end
+	done bool
+}
+
+type virtualTicker struct {
+	clk    *virtualClock
+	period time.Duration
+	// Creation order, which breaks ties between simultaneous ticks
+	seq int
+	// Time of the next tick
+	next time.Time
+	// Tells if the ticker's handler is waiting for a tick
+	waiting bool
+	// Tells if a tick was delivered but not yet received
+	fired   bool
+	stopped bool
+}
+
+func (c *virtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *virtualClock) NewTicker(period time.Duration) Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &virtualTicker{
+		clk:     c,
+		period:  period,
+		next:    c.now.Add(period),
+		seq:     c.created,
+		stopped: c.done,
+	}
+	c.created++
+	if !t.stopped {
+		c.tickers[t] = struct{}{}
+	}
+	return t
+}
+
+// Delivers the next tick, advancing time to it, if every ticker is waiting
+// for one. It must be called with the lock held.
+func (c *virtualClock) advance() {
+	if len(c.tickers) == 0 {
+		return
+	}
+
+	var next *virtualTicker
+	for t := range c.tickers {
+		if !t.waiting || t.fired {
+			return
+		}
+		if next == nil || t.next.Before(next.next) ||
+			(t.next.Equal(next.next) && t.seq < next.seq) {
+			next = t
+		}
+	}
+
+	if !c.end.IsZero() && next.next.After(c.end) {
+		c.now, c.done = c.end, true
+		for t := range c.tickers {
+			t.stopped = true
+			delete(c.tickers, t)
+		}
+		c.cond.Broadcast()
+		return
+	}
+
+	c.now = next.next
+	next.fired = true
+	next.next = next.next.Add(next.period)
+	c.cond.Broadcast()
+}
+
+func (t *virtualTicker) Next() (time.Time, bool) {
+	c := t.clk
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t.waiting = true
+	c.advance()
+	for !t.fired && !t.stopped {
+		c.cond.Wait()
+	}
+	t.waiting = false
+
+	if t.stopped {
+		return time.Time{}, false
+	}
+	t.fired = false
+	return c.now, true
+}
+
+func (t *virtualTicker) Stop() {
+	c := t.clk
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t.stopped = true
+	delete(c.tickers, t)
+	c.cond.Broadcast()
+	// The clock may have been waiting only for this ticker
+	c.advance()
+}
@@ -0,0 +1,277 @@
+// Package control serves an HTTP/JSON API that changes a simulation's fleet
+// while it runs.
+//
+// Routes:
+//
+//	GET    /devices             lists devices
+//	POST   /devices             spawns devices from a description
+//	GET    /devices/{id}        describes a device
+//	PATCH  /devices/{id}        changes a device's velocity or frequency
+//	DELETE /devices/{id}        removes a device
+//	POST   /devices/{id}/pause  pauses a device
+//	POST   /devices/{id}/resume resumes a device
+//	POST   /devices/{id}/reset  moves a device back to the start of its route
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gpontesss/routesim/pkg/gps"
+	"github.com/gpontesss/routesim/pkg/routesim"
+)
+
+// Spawned is a GPS built by a Spawner, along with its emission frequency
+type Spawned struct {
+	GPS       gps.GPS
+	Frequency time.Duration
+}
+
+// Spawner builds GPSs from a JSON description sent to the API
+type Spawner func(desc json.RawMessage) ([]Spawned, error)
+
+// Server handles API requests by changing the GPSs of a scheduler
+type Server struct {
+	sched *routesim.Scheduler
+	spawn Spawner
+}
+
+// NewServer creates a server that controls a scheduler. New GPSs are built by
+// spawn.
+func NewServer(sched *routesim.Scheduler, spawn Spawner) *Server {
+	return &Server{sched: sched, spawn: spawn}
+}
+
+// Listen serves the API on an address, until the returned closer is closed
+func (s *Server) Listen(address string) (io.Closer, error) {
+	lst, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("Error listening on %s: %w", address, err)
+	}
+	srv := &http.Server{Handler: s}
+	go srv.Serve(lst)
+	return closerFunc(func() error { return srv.Shutdown(context.Background()) }), nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// Device is the JSON description of a device
+type Device struct {
+	ID string `json:"id"`
+	// Emission frequency, e.g. "1s"
+	Frequency string `json:"frequency"`
+	// Velocity (m/s), if the device's GPS is controllable
+	Velocity *float64               `json:"velocity,omitempty"`
+	Paused   bool                   `json:"paused"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// Changes to a device. Unset fields are kept.
+type deviceChanges struct {
+	Velocity  *float64 `json:"velocity"`
+	Frequency *string  `json:"frequency"`
+}
+
+// Error that carries its HTTP status
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (err apiError) Error() string { return err.msg }
+
+func errorf(status int, format string, args ...interface{}) error {
+	return apiError{status, fmt.Sprintf(format, args...)}
+}
+
+// ServeHTTP routes an API request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if path[0] != "devices" || len(path) > 3 {
+		writeError(w, errorf(http.StatusNotFound, "Unknown path '%s'", r.URL.Path))
+		return
+	}
+
+	var (
+		body interface{}
+		err  error
+	)
+	status := http.StatusOK
+	switch {
+	case len(path) == 1 && r.Method == http.MethodGet:
+		body = s.list()
+	case len(path) == 1 && r.Method == http.MethodPost:
+		body, err = s.create(r.Body)
+		status = http.StatusCreated
+	case len(path) == 2 && r.Method == http.MethodGet:
+		body, err = s.get(path[1])
+	case len(path) == 2 && r.Method == http.MethodPatch:
+		body, err = s.change(path[1], r.Body)
+	case len(path) == 2 && r.Method == http.MethodDelete:
+		if !s.sched.Remove(path[1]) {
+			err = unknownDevice(path[1])
+		}
+		status = http.StatusNoContent
+	case len(path) == 3 && r.Method == http.MethodPost:
+		body, err = s.act(path[1], path[2])
+	default:
+		err = errorf(http.StatusMethodNotAllowed, "Method %s not allowed on '%s'", r.Method, r.URL.Path)
+	}
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, status, body)
+}
+
+func (s *Server) list() []Device {
+	scheds := s.sched.List()
+	devs := make([]Device, len(scheds))
+	for i, sgps := range scheds {
+		devs[i] = describe(sgps)
+	}
+	return devs
+}
+
+func (s *Server) get(id string) (Device, error) {
+	sgps, ok := s.sched.Get(id)
+	if !ok {
+		return Device{}, unknownDevice(id)
+	}
+	return describe(sgps), nil
+}
+
+// Spawns GPSs from a description and schedules them. If any of them can't be
+// scheduled, none is.
+func (s *Server) create(body io.Reader) ([]Device, error) {
+	var desc json.RawMessage
+	if err := json.NewDecoder(body).Decode(&desc); err != nil {
+		return nil, errorf(http.StatusBadRequest, "Invalid device description: %v", err)
+	}
+	spawned, err := s.spawn(desc)
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "Error spawning devices: %v", err)
+	}
+
+	devs := make([]Device, 0, len(spawned))
+	for _, sp := range spawned {
+		if err := s.sched.Add(sp.GPS, sp.Frequency); err != nil {
+			for _, dev := range devs {
+				s.sched.Remove(dev.ID)
+			}
+			return nil, errorf(http.StatusConflict, "%v", err)
+		}
+		devs = append(devs, describe(routesim.ScheduledGPS{GPS: sp.GPS, Frequency: sp.Frequency}))
+	}
+	return devs, nil
+}
+
+func (s *Server) change(id string, body io.Reader) (Device, error) {
+	var changes deviceChanges
+	if err := json.NewDecoder(body).Decode(&changes); err != nil {
+		return Device{}, errorf(http.StatusBadRequest, "Invalid changes: %v", err)
+	}
+
+	sgps, ok := s.sched.Get(id)
+	if !ok {
+		return Device{}, unknownDevice(id)
+	}
+	if changes.Frequency != nil {
+		freq, err := time.ParseDuration(*changes.Frequency)
+		if err != nil {
+			return Device{}, errorf(http.StatusBadRequest, "Invalid frequency: %v", err)
+		}
+		if _, err := s.sched.SetFrequency(id, freq); err != nil {
+			return Device{}, errorf(http.StatusBadRequest, "%v", err)
+		}
+	}
+	if changes.Velocity != nil {
+		ctl, ok := sgps.GPS.(gps.Controllable)
+		if !ok {
+			return Device{}, uncontrollable(id)
+		}
+		ctl.SetVelocity(*changes.Velocity)
+	}
+	return s.get(id)
+}
+
+// Performs an action on a device
+func (s *Server) act(id, action string) (Device, error) {
+	sgps, ok := s.sched.Get(id)
+	if !ok {
+		return Device{}, unknownDevice(id)
+	}
+	ctl, controllable := sgps.GPS.(gps.Controllable)
+
+	switch action {
+	case "pause":
+		s.sched.Pause(id)
+		if controllable {
+			ctl.Pause()
+		}
+	case "resume":
+		s.sched.Resume(id)
+		if controllable {
+			ctl.Resume()
+		}
+	case "reset":
+		if !controllable {
+			return Device{}, uncontrollable(id)
+		}
+		ctl.Reset()
+	default:
+		return Device{}, errorf(http.StatusNotFound, "Unknown action '%s'", action)
+	}
+	return s.get(id)
+}
+
+func describe(sgps routesim.ScheduledGPS) Device {
+	dev := Device{
+		ID:        sgps.GPS.ID(),
+		Frequency: sgps.Frequency.String(),
+		Paused:    sgps.Paused,
+		Metadata:  sgps.GPS.Metadata(),
+	}
+	if ctl, ok := sgps.GPS.(gps.Controllable); ok {
+		vel := ctl.Velocity()
+		dev.Velocity = &vel
+	}
+	return dev
+}
+
+func unknownDevice(id string) error {
+	return errorf(http.StatusNotFound, "Unknown device '%s'", id)
+}
+
+func uncontrollable(id string) error {
+	return errorf(http.StatusConflict, "Device '%s' can't be controlled", id)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var apiErr apiError
+	if errors.As(err, &apiErr) {
+		status = apiErr.status
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
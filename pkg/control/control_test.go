@@ -0,0 +1,118 @@
+package control
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/clock"
+	"github.com/gpontesss/routesim/pkg/gps"
+	"github.com/gpontesss/routesim/pkg/gps/gpstest"
+	"github.com/gpontesss/routesim/pkg/routesim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Spawns a SimGPS for each ID in the description
+func testSpawner(clk clock.Clock) Spawner {
+	path := s2.PolylineFromLatLngs([]s2.LatLng{s2.LatLngFromDegrees(0, 0), s2.LatLngFromDegrees(0, 1)})
+	return func(desc json.RawMessage) ([]Spawned, error) {
+		var d struct {
+			IDs       []string `json:"ids"`
+			Frequency string   `json:"frequency"`
+		}
+		if err := json.Unmarshal(desc, &d); err != nil {
+			return nil, err
+		}
+		freq, err := time.ParseDuration(d.Frequency)
+		if err != nil {
+			return nil, err
+		}
+		var spawned []Spawned
+		for _, id := range d.IDs {
+			sgps := gps.SimGPSWithClock(id, 10, gps.RestartWalker(path), nil, clk)
+			spawned = append(spawned, Spawned{GPS: sgps, Frequency: freq})
+		}
+		return spawned, nil
+	}
+}
+
+func testServer(t *testing.T) (*httptest.Server, *routesim.Scheduler) {
+	clk := clock.Virtual(time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC), time.Time{})
+	sched := routesim.NewScheduler(clk, time.Second)
+	srv := httptest.NewServer(NewServer(sched, testSpawner(clk)))
+	t.Cleanup(srv.Close)
+	return srv, sched
+}
+
+// Sends a request and decodes its JSON response into out, if given
+func request(t *testing.T, srv *httptest.Server, method, path, body string, out interface{}) int {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	if out != nil {
+		require.NoError(t, json.NewDecoder(res.Body).Decode(out))
+	}
+	return res.StatusCode
+}
+
+func TestServer(t *testing.T) {
+	srv, sched := testServer(t)
+
+	var devs []Device
+	assert.Equal(t, http.StatusCreated, request(t, srv, "POST", "/devices", `{"ids": ["bus-1", "bus-2"], "frequency": "2s"}`, &devs))
+	require.Len(t, devs, 2)
+	assert.Equal(t, "bus-1", devs[0].ID)
+	assert.Equal(t, "2s", devs[0].Frequency)
+	assert.Equal(t, 2, sched.Len())
+
+	// None is added if any can't be
+	assert.Equal(t, http.StatusConflict, request(t, srv, "POST", "/devices", `{"ids": ["bus-3", "bus-2"], "frequency": "1s"}`, nil))
+	assert.Equal(t, http.StatusBadRequest, request(t, srv, "POST", "/devices", `{"ids": ["bus-3"]}`, nil))
+	assert.Equal(t, 2, sched.Len())
+
+	assert.Equal(t, http.StatusOK, request(t, srv, "GET", "/devices", "", &devs))
+	assert.Len(t, devs, 2)
+
+	var dev Device
+	assert.Equal(t, http.StatusOK, request(t, srv, "PATCH", "/devices/bus-1", `{"velocity": 20, "frequency": "5s"}`, &dev))
+	require.NotNil(t, dev.Velocity)
+	assert.Equal(t, 20.0, *dev.Velocity)
+	assert.Equal(t, "5s", dev.Frequency)
+
+	assert.Equal(t, http.StatusOK, request(t, srv, "POST", "/devices/bus-1/pause", "", &dev))
+	assert.True(t, dev.Paused)
+	assert.Equal(t, http.StatusOK, request(t, srv, "GET", "/devices/bus-1", "", &dev))
+	assert.True(t, dev.Paused)
+	assert.Equal(t, http.StatusOK, request(t, srv, "POST", "/devices/bus-1/resume", "", &dev))
+	assert.False(t, dev.Paused)
+	assert.Equal(t, http.StatusOK, request(t, srv, "POST", "/devices/bus-1/reset", "", &dev))
+
+	assert.Equal(t, http.StatusNoContent, request(t, srv, "DELETE", "/devices/bus-1", "", nil))
+	assert.Equal(t, http.StatusNotFound, request(t, srv, "GET", "/devices/bus-1", "", nil))
+	assert.Equal(t, http.StatusNotFound, request(t, srv, "DELETE", "/devices/bus-1", "", nil))
+	assert.Equal(t, 1, sched.Len())
+}
+
+func TestServerErrors(t *testing.T) {
+	srv, sched := testServer(t)
+	require.NoError(t, sched.Add(gpstest.TestGPS("TEST1234"), time.Second))
+
+	var res map[string]string
+	assert.Equal(t, http.StatusNotFound, request(t, srv, "GET", "/things", "", &res))
+	assert.Contains(t, res["error"], "Unknown path")
+	assert.Equal(t, http.StatusMethodNotAllowed, request(t, srv, "PUT", "/devices", "", nil))
+	assert.Equal(t, http.StatusNotFound, request(t, srv, "POST", "/devices/TEST1234/jump", "", nil))
+	assert.Equal(t, http.StatusBadRequest, request(t, srv, "PATCH", "/devices/TEST1234", `{"frequency": "often"}`, nil))
+	// Test GPSs' movement can't be changed
+	assert.Equal(t, http.StatusConflict, request(t, srv, "PATCH", "/devices/TEST1234", `{"velocity": 5}`, nil))
+	assert.Equal(t, http.StatusConflict, request(t, srv, "POST", "/devices/TEST1234/reset", "", nil))
+	// But their emissions can be paused
+	assert.Equal(t, http.StatusOK, request(t, srv, "POST", "/devices/TEST1234/pause", "", nil))
+}
@@ -0,0 +1,128 @@
+package data
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gpontesss/routesim/pkg/gps"
+)
+
+// Prefix of CSV columns that hold a GPS metadata entry
+const csvMetadataPrefix = "metadata."
+
+// DefaultCSVColumns are the columns of CSV records if none are chosen
+var DefaultCSVColumns = []string{"id", "time", "lat", "lng", "speed", "course"}
+
+// Headerer is implemented by formatters whose records are preceded by a
+// header, e.g. a CSV's column names. File publishers write it at the start of
+// every file.
+type Headerer interface {
+	Header() []byte
+}
+
+// Extracts a column's value from a position
+type csvColumn func(gps.Position) string
+
+type csvFormatter struct {
+	names   []string
+	columns []csvColumn
+}
+
+// CSVFormatter creates a formatter of positions into CSV records, without a
+// line break, with the chosen columns:
+//
+//	id               GPS ID
+//	time             timestamp, in RFC 3339 format (UTC)
+//	unix             timestamp, in Unix milliseconds
+//	lat, lng         coordinates, in degrees
+//	speed            speed over ground (m/s)
+//	course           course over ground, in degrees
+//	metadata.<key>   a GPS metadata entry, empty if missing
+func CSVFormatter(columns []string) (PosFormatter, error) {
+	if len(columns) == 0 {
+		columns = DefaultCSVColumns
+	}
+	fmtr := &csvFormatter{names: columns}
+	for _, name := range columns {
+		col, err := parseCSVColumn(name)
+		if err != nil {
+			return nil, err
+		}
+		fmtr.columns = append(fmtr.columns, col)
+	}
+	return fmtr, nil
+}
+
+func parseCSVColumn(name string) (csvColumn, error) {
+	if strings.HasPrefix(name, csvMetadataPrefix) {
+		key := strings.TrimPrefix(name, csvMetadataPrefix)
+		return func(pos gps.Position) string {
+			value, ok := pos.GPS.Metadata()[key]
+			if !ok {
+				return ""
+			}
+			return fmt.Sprint(value)
+		}, nil
+	}
+
+	switch name {
+	case "id":
+		return func(pos gps.Position) string { return pos.GPS.ID() }, nil
+	case "time":
+		return func(pos gps.Position) string { return pos.At.UTC().Format(time.RFC3339Nano) }, nil
+	case "unix":
+		return func(pos gps.Position) string {
+			return strconv.FormatInt(pos.At.UnixNano()/int64(time.Millisecond), 10)
+		}, nil
+	case "lat":
+		return func(pos gps.Position) string { return formatFloat(pos.Lat.Degrees()) }, nil
+	case "lng":
+		return func(pos gps.Position) string { return formatFloat(pos.Lng.Degrees()) }, nil
+	case "speed":
+		return func(pos gps.Position) string { return formatFloat(pos.Speed) }, nil
+	case "course":
+		return func(pos gps.Position) string { return formatFloat(pos.Course) }, nil
+	default:
+		return nil, fmt.Errorf("Unknown CSV column '%s'", name)
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Format formats a position into a CSV record
+func (f *csvFormatter) Format(pos gps.Position) ([]byte, error) {
+	record := make([]string, len(f.columns))
+	for i, col := range f.columns {
+		record[i] = col(pos)
+	}
+	return csvLine(record)
+}
+
+// Header returns the column names
+func (f *csvFormatter) Header() []byte {
+	line, _ := csvLine(f.names)
+	return line
+}
+
+// ContentType returns CSV's media type
+func (f *csvFormatter) ContentType() string { return "text/csv" }
+
+// Encodes a CSV record, quoting fields as needed, without its line break
+func csvLine(record []string) ([]byte, error) {
+	var buf bytes.Buffer
+	wtr := csv.NewWriter(&buf)
+	if err := wtr.Write(record); err != nil {
+		return nil, err
+	}
+	wtr.Flush()
+	if err := wtr.Error(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), nil
+}
@@ -0,0 +1,36 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVFormatter(t *testing.T) {
+	fmtr, err := CSVFormatter([]string{"id", "time", "unix", "lat", "lng", "speed", "course", "metadata.line", "metadata.depot"})
+	require.NoError(t, err)
+	assert.Equal(t, "text/csv", ContentType(fmtr))
+	assert.Equal(t, "id,time,unix,lat,lng,speed,course,metadata.line,metadata.depot", string(fmtr.(Headerer).Header()))
+
+	pos := testPos("bus,1", map[string]interface{}{"line": 8000})
+	pos.LatLng = s2.LatLngFromDegrees(-23.5, -46.625)
+	pos.At = time.Date(2020, 10, 1, 12, 30, 0, 500000000, time.UTC)
+	pos.Speed = 12.5
+	pos.Course = 90
+
+	bs, err := fmtr.Format(pos)
+	require.NoError(t, err)
+	assert.Equal(t, `"bus,1",2020-10-01T12:30:00.5Z,1601555400500,-23.5,-46.625,12.5,90,8000,`, string(bs))
+}
+
+func TestCSVFormatterColumns(t *testing.T) {
+	fmtr, err := CSVFormatter(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "id,time,lat,lng,speed,course", string(fmtr.(Headerer).Header()))
+
+	_, err = CSVFormatter([]string{"id", "altitude"})
+	assert.EqualError(t, err, "Unknown CSV column 'altitude'")
+}
@@ -0,0 +1,319 @@
+package data
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gpontesss/routesim/pkg/gps"
+)
+
+const (
+	// Format of the period start in rotated file names
+	filePeriodFormat = "20060102T150405Z"
+	// Default number of files kept open at once
+	fileDefaultMaxOpen = 64
+)
+
+// FileConfig describes where a file publisher writes and how it rotates files
+type FileConfig struct {
+	// Path of the file, e.g. "out/positions.ndjson". If files are rotated,
+	// their names are made of its name followed by the device ID, the period
+	// start and a sequence number, as applicable, before the extension, e.g.
+	// "out/positions-bus-1-20201001T000000Z-000.ndjson".
+	Path string
+	// Size, in bytes, after which a new file is started. Sizes are counted
+	// before compression.
+	MaxSize int64
+	// Length of the time period of each file, by position timestamps, e.g. an
+	// hour or a day. Periods are aligned to the Unix epoch, in UTC.
+	Interval time.Duration
+	// Writes a file for each device
+	PerDevice bool
+	// Compresses files with gzip, adding a .gz extension
+	Gzip bool
+	// Maximum number of files kept open at once, e.g. one for each device.
+	// The least recently written file is closed beyond it, and reopened if
+	// needed. Defaults to 64.
+	MaxOpen int
+}
+
+// An open file and its rotation state
+type posFile struct {
+	file   *os.File
+	gz     *gzip.Writer
+	wtr    *bufio.Writer
+	size   int64
+	period time.Time
+	seq    int
+	// When it was last written, in publisher writes
+	used uint64
+}
+
+// Latest file of a device and period, so it is resumed if either comes back
+type fileSeq struct {
+	seq  int
+	size int64
+}
+
+func (f *posFile) Write(bs []byte) (int, error) {
+	n, err := f.wtr.Write(bs)
+	f.size += int64(n)
+	return n, err
+}
+
+// Flushes buffered data into the file. Gzip streams are flushed too, so the
+// file is readable up to this point.
+func (f *posFile) Flush() error {
+	if err := f.wtr.Flush(); err != nil {
+		return err
+	}
+	if f.gz != nil {
+		return f.gz.Flush()
+	}
+	return nil
+}
+
+func (f *posFile) Close() error {
+	err := f.wtr.Flush()
+	if f.gz != nil {
+		if gzerr := f.gz.Close(); err == nil {
+			err = gzerr
+		}
+	}
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type filePosPub struct {
+	sync.Mutex
+	cfg  FileConfig
+	fmtr PosFormatter
+	// Open files, by device ID. It is the only key if files aren't per device.
+	files map[string]*posFile
+	// Latest files written, by device ID and period
+	latest map[string]fileSeq
+	writes uint64
+	closed bool
+}
+
+// FilePublisher creates a publisher that writes formatted positions to files,
+// one record per line. Records that don't end with a line break get one. If
+// the formatter is a Headerer, e.g. a CSV one, its header starts every file.
+// Files are rotated by size, time period or device, as configured, and are
+// buffered until flushed or closed.
+func FilePublisher(cfg FileConfig, fmtr PosFormatter) (PosPublisher, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("File path must be set")
+	}
+	if dir := filepath.Dir(cfg.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("Error creating directory %s: %w", dir, err)
+		}
+	}
+	if cfg.MaxOpen <= 0 {
+		cfg.MaxOpen = fileDefaultMaxOpen
+	}
+	return &filePosPub{
+		cfg:    cfg,
+		fmtr:   fmtr,
+		files:  map[string]*posFile{},
+		latest: map[string]fileSeq{},
+	}, nil
+}
+
+// PublishPos formats a position and writes it to its file, which is rotated
+// beforehand if needed
+func (pub *filePosPub) PublishPos(pos gps.Position) error {
+	bs, err := pub.fmtr.Format(pos)
+	if err != nil {
+		return err
+	}
+
+	pub.Lock()
+	defer pub.Unlock()
+	if pub.closed {
+		return ErrPublisherDone
+	}
+	f, err := pub.file(pos)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(bs); err != nil {
+		return fmt.Errorf("Error writing to %s: %w", f.file.Name(), err)
+	}
+	if len(bs) == 0 || bs[len(bs)-1] != '\n' {
+		if _, err := f.Write([]byte{'\n'}); err != nil {
+			return fmt.Errorf("Error writing to %s: %w", f.file.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Returns the file a position is written to, rotating or opening it as needed.
+// Positions may come out of time order, so a file of a past period may be
+// resumed; files are never truncated once written.
+func (pub *filePosPub) file(pos gps.Position) (*posFile, error) {
+	var key string
+	if pub.cfg.PerDevice {
+		key = pos.GPS.ID()
+	}
+	var period time.Time
+	if pub.cfg.Interval > 0 {
+		period = pos.At.UTC().Truncate(pub.cfg.Interval)
+	}
+
+	pub.writes++
+	f, ok := pub.files[key]
+	if ok && f.period.Equal(period) && !pub.full(f.size) {
+		f.used = pub.writes
+		return f, nil
+	}
+	if ok {
+		if err := pub.close(key, f); err != nil {
+			return nil, err
+		}
+	}
+
+	// Resumes the latest file of the period, unless it is full
+	latest, resumed := pub.latest[pub.latestKey(key, period)]
+	if resumed && pub.full(latest.size) {
+		latest = fileSeq{seq: latest.seq + 1}
+		resumed = false
+	}
+	if len(pub.files) >= pub.cfg.MaxOpen {
+		if err := pub.closeLeastUsed(); err != nil {
+			return nil, err
+		}
+	}
+	f, err := pub.open(key, period, latest, resumed)
+	if err != nil {
+		return nil, err
+	}
+	f.used = pub.writes
+	pub.files[key] = f
+	return f, nil
+}
+
+// Tells if a file of a size must be rotated
+func (pub *filePosPub) full(size int64) bool {
+	return pub.cfg.MaxSize > 0 && size >= pub.cfg.MaxSize
+}
+
+func (pub *filePosPub) latestKey(key string, period time.Time) string {
+	return key + "\x00" + period.Format(filePeriodFormat)
+}
+
+// Closes an open file, remembering it as the latest of its period
+func (pub *filePosPub) close(key string, f *posFile) error {
+	delete(pub.files, key)
+	pub.latest[pub.latestKey(key, f.period)] = fileSeq{seq: f.seq, size: f.size}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Error closing %s: %w", f.file.Name(), err)
+	}
+	return nil
+}
+
+func (pub *filePosPub) closeLeastUsed() error {
+	var lruKey string
+	var lru *posFile
+	for key, f := range pub.files {
+		if lru == nil || f.used < lru.used {
+			lruKey, lru = key, f
+		}
+	}
+	return pub.close(lruKey, lru)
+}
+
+// Opens a file and writes the formatter's header to it, if any. A file that
+// was already written is appended to; otherwise, it is created or truncated.
+// Appended gzip files get a new gzip member, which readers concatenate.
+func (pub *filePosPub) open(key string, period time.Time, latest fileSeq, resume bool) (*posFile, error) {
+	path := pub.path(key, period, latest.seq)
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if resume {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating %s: %w", path, err)
+	}
+	f := &posFile{file: file, period: period, seq: latest.seq}
+	var wtr io.Writer = file
+	if pub.cfg.Gzip {
+		f.gz = gzip.NewWriter(file)
+		wtr = f.gz
+	}
+	f.wtr = bufio.NewWriter(wtr)
+	if resume {
+		f.size = latest.size
+		return f, nil
+	}
+
+	if h, ok := pub.fmtr.(Headerer); ok {
+		header := append(h.Header(), '\n')
+		if _, err := f.Write(header); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("Error writing to %s: %w", path, err)
+		}
+	}
+	return f, nil
+}
+
+// Path of a file, after rotation parts are added to the configured one
+func (pub *filePosPub) path(key string, period time.Time, seq int) string {
+	ext := filepath.Ext(pub.cfg.Path)
+	parts := []string{strings.TrimSuffix(pub.cfg.Path, ext)}
+	if pub.cfg.PerDevice {
+		// IDs may contain path separators
+		parts = append(parts, strings.NewReplacer("/", "_", `\`, "_").Replace(key))
+	}
+	if pub.cfg.Interval > 0 {
+		parts = append(parts, period.Format(filePeriodFormat))
+	}
+	if pub.cfg.MaxSize > 0 {
+		parts = append(parts, fmt.Sprintf("%03d", seq))
+	}
+	path := strings.Join(parts, "-") + ext
+	if pub.cfg.Gzip {
+		path += ".gz"
+	}
+	return path
+}
+
+// Flush writes buffered records to every open file
+func (pub *filePosPub) Flush() error {
+	pub.Lock()
+	defer pub.Unlock()
+	var err error
+	for _, f := range pub.files {
+		if ferr := f.Flush(); ferr != nil && err == nil {
+			err = fmt.Errorf("Error flushing %s: %w", f.file.Name(), ferr)
+		}
+	}
+	return err
+}
+
+// Close flushes and closes every open file
+func (pub *filePosPub) Close() error {
+	pub.Lock()
+	defer pub.Unlock()
+	pub.closed = true
+	var err error
+	for key, f := range pub.files {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("Error closing %s: %w", f.file.Name(), cerr)
+		}
+		delete(pub.files, key)
+	}
+	return err
+}
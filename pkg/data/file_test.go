@@ -0,0 +1,168 @@
+package data
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gpontesss/routesim/pkg/gps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Publishes positions of IDs at times, then closes the publisher. Returns the
+// written files' contents, by base name.
+func publishToFiles(t *testing.T, cfg FileConfig, fmtr PosFormatter, ids []string, ats []time.Time) map[string]string {
+	dir, err := ioutil.TempDir("", "routesim-file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg.Path = filepath.Join(dir, "out", "positions.csv")
+	pub, err := FilePublisher(cfg, fmtr)
+	require.NoError(t, err)
+	for i, id := range ids {
+		pos := testPos(id, nil)
+		pos.At = ats[i]
+		require.NoError(t, pub.PublishPos(pos))
+	}
+	require.NoError(t, Close(pub))
+	assert.ErrorIs(t, pub.PublishPos(testPos("bus-1", nil)), ErrPublisherDone)
+
+	files := map[string]string{}
+	paths, err := filepath.Glob(filepath.Join(dir, "out", "*"))
+	require.NoError(t, err)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+		var rdr io.Reader = f
+		if cfg.Gzip {
+			gz, err := gzip.NewReader(f)
+			require.NoError(t, err)
+			rdr = gz
+		}
+		bs, err := ioutil.ReadAll(rdr)
+		require.NoError(t, err)
+		files[filepath.Base(path)] = string(bs)
+	}
+	return files
+}
+
+func idCSVFormatter(t *testing.T) PosFormatter {
+	fmtr, err := CSVFormatter([]string{"id"})
+	require.NoError(t, err)
+	return fmtr
+}
+
+func TestFilePublisher(t *testing.T) {
+	at := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	files := publishToFiles(t, FileConfig{}, idFormatter,
+		[]string{"bus-1", "bus-2"}, []time.Time{at, at})
+	assert.Equal(t, map[string]string{"positions.csv": "bus-1\nbus-2\n"}, files)
+}
+
+func TestFilePublisherRotation(t *testing.T) {
+	at := time.Date(2020, 10, 1, 0, 59, 0, 0, time.UTC)
+	ids := []string{"bus-1", "bus-2", "bus-1", "bus-1", "bus-1"}
+	ats := []time.Time{at, at, at, at, at.Add(2 * time.Minute)}
+
+	cases := map[string]struct {
+		cfg   FileConfig
+		files map[string]string
+	}{
+		"Size": {FileConfig{MaxSize: 15}, map[string]string{
+			"positions-000.csv": "id\nbus-1\nbus-2\n",
+			"positions-001.csv": "id\nbus-1\nbus-1\n",
+			"positions-002.csv": "id\nbus-1\n",
+		}},
+		"Time": {FileConfig{Interval: time.Hour}, map[string]string{
+			"positions-20201001T000000Z.csv": "id\nbus-1\nbus-2\nbus-1\nbus-1\n",
+			"positions-20201001T010000Z.csv": "id\nbus-1\n",
+		}},
+		"Device": {FileConfig{PerDevice: true}, map[string]string{
+			"positions-bus-1.csv": "id\nbus-1\nbus-1\nbus-1\nbus-1\n",
+			"positions-bus-2.csv": "id\nbus-2\n",
+		}},
+		"All": {FileConfig{PerDevice: true, Interval: time.Hour, MaxSize: 9, Gzip: true}, map[string]string{
+			"positions-bus-1-20201001T000000Z-000.csv.gz": "id\nbus-1\n",
+			"positions-bus-1-20201001T000000Z-001.csv.gz": "id\nbus-1\n",
+			"positions-bus-1-20201001T000000Z-002.csv.gz": "id\nbus-1\n",
+			"positions-bus-1-20201001T010000Z-000.csv.gz": "id\nbus-1\n",
+			"positions-bus-2-20201001T000000Z-000.csv.gz": "id\nbus-2\n",
+		}},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			files := publishToFiles(t, c.cfg, idCSVFormatter(t), ids, ats)
+			assert.Equal(t, c.files, files, sortedKeys(files))
+		})
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestFilePublisherFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routesim-file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "positions.ndjson")
+	pub, err := FilePublisher(FileConfig{Path: path, Gzip: true}, GeoJSONFormatter)
+	require.NoError(t, err)
+	defer Close(pub)
+	require.NoError(t, pub.PublishPos(gps.Position{GPS: testPos("bus-1", nil).GPS}))
+	require.NoError(t, pub.(Flusher).Flush())
+
+	// Flushed data is readable before the file is closed
+	f, err := os.Open(path + ".gz")
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	line := make([]byte, 512)
+	n, _ := gz.Read(line)
+	assert.Contains(t, string(line[:n]), `"id":"bus-1"`)
+}
+
+func TestFilePublisherResumesFiles(t *testing.T) {
+	at := time.Date(2020, 10, 1, 0, 59, 0, 0, time.UTC)
+	// The third position is late, from the first period
+	ids := []string{"bus-1", "bus-2", "bus-1", "bus-2"}
+	ats := []time.Time{at, at.Add(time.Minute), at.Add(30 * time.Second), at.Add(2 * time.Minute)}
+
+	cases := map[string]struct {
+		cfg   FileConfig
+		files map[string]string
+	}{
+		"Time": {FileConfig{Interval: time.Hour}, map[string]string{
+			"positions-20201001T000000Z.csv": "id\nbus-1\nbus-1\n",
+			"positions-20201001T010000Z.csv": "id\nbus-2\nbus-2\n",
+		}},
+		"TimeAndSize": {FileConfig{Interval: time.Hour, MaxSize: 15, Gzip: true}, map[string]string{
+			"positions-20201001T000000Z-000.csv.gz": "id\nbus-1\nbus-1\n",
+			"positions-20201001T010000Z-000.csv.gz": "id\nbus-2\nbus-2\n",
+		}},
+		"MaxOpen": {FileConfig{PerDevice: true, MaxOpen: 1}, map[string]string{
+			"positions-bus-1.csv": "id\nbus-1\nbus-1\n",
+			"positions-bus-2.csv": "id\nbus-2\nbus-2\n",
+		}},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			files := publishToFiles(t, c.cfg, idCSVFormatter(t), ids, ats)
+			assert.Equal(t, c.files, files, sortedKeys(files))
+		})
+	}
+}
@@ -22,9 +22,36 @@ func (f PosFormatterFunc) Format(pos gps.Position) ([]byte, error) {
 	return f(pos)
 }
 
+// ContentTyper is implemented by formatters that know the media type of
+// their output
+type ContentTyper interface {
+	ContentType() string
+}
+
+// ContentType returns the media type of a formatter's output. It defaults to
+// application/octet-stream.
+func ContentType(fmtr PosFormatter) string {
+	if ct, ok := fmtr.(ContentTyper); ok {
+		return ct.ContentType()
+	}
+	return "application/octet-stream"
+}
+
+type typedFormatter struct {
+	PosFormatter
+	contentType string
+}
+
+func (f typedFormatter) ContentType() string { return f.contentType }
+
+// WithContentType attaches a media type to a formatter's output
+func WithContentType(fmtr PosFormatter, contentType string) PosFormatter {
+	return typedFormatter{fmtr, contentType}
+}
+
 var (
 	// GeoJSONFormatter formats a position into a GeoJSON Feature point
-	GeoJSONFormatter = geoJSONFormatter()
+	GeoJSONFormatter = WithContentType(geoJSONFormatter(), "application/geo+json")
 )
 
 func geoJSONFormatter() PosFormatter {
@@ -35,9 +62,10 @@ func geoJSONFormatter() PosFormatter {
 			Properties: pos.GPS.Metadata(),
 			Geometry: &geojson.Geometry{
 				Type: geojson.GeometryPoint,
+				// GeoJSON positions are ordered as longitude, latitude
 				Point: []float64{
-					pos.Lat.Degrees(),
 					pos.Lng.Degrees(),
+					pos.Lat.Degrees(),
 				},
 			},
 		})
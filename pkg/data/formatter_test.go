@@ -0,0 +1,25 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/gps/gpstest"
+	geojson "github.com/paulmach/go.geojson"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoJSONFormatter(t *testing.T) {
+	gpz := gpstest.TestGPS("TEST1234", s2.LatLngFromDegrees(-23.5, -46.6))
+	bs, err := GeoJSONFormatter.Format(gpz.CurrentPos())
+	require.NoError(t, err)
+
+	feat, err := geojson.UnmarshalFeature(bs)
+	require.NoError(t, err)
+	assert.Equal(t, "TEST1234", feat.ID)
+	require.True(t, feat.Geometry.IsPoint())
+	// Longitude comes first
+	assert.InDeltaSlice(t, []float64{-46.6, -23.5}, feat.Geometry.Point, 1e-9)
+	assert.Equal(t, "application/geo+json", ContentType(GeoJSONFormatter))
+}
@@ -0,0 +1,329 @@
+package data
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gpontesss/routesim/pkg/gps"
+)
+
+const (
+	// Maximum time a client may take to receive a report before it is
+	// disconnected
+	gpsdWriteTimeout = time.Second
+	// Time format of gpsd reports
+	gpsdTimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+// GPSDConfig describes a server that speaks the gpsd JSON protocol
+type GPSDConfig struct {
+	// Address the server listens on. gpsd's usual one is "localhost:2947".
+	Address string
+	// ID of the GPS whose positions are reported. It is also the device's
+	// path.
+	GPSID string
+}
+
+type gpsdPosPub struct {
+	sync.Mutex
+	lst     net.Listener
+	device  string
+	clients map[*gpsdClient]struct{}
+	// Last reports, for polls
+	tpv, sky []byte
+}
+
+type gpsdClient struct {
+	sync.Mutex
+	conn     net.Conn
+	watching bool
+}
+
+// GPSDPublisher creates a TCP server that impersonates gpsd, so gpsd clients
+// such as cgps can follow a simulated GPS. It speaks enough of the JSON
+// protocol for them: ?WATCH, ?POLL, ?VERSION and ?DEVICES commands, and
+// VERSION, DEVICES, WATCH, TPV, SKY and POLL reports. Every position of the
+// selected GPS is reported to watching clients as a TPV and a SKY report;
+// positions of other GPSs are ignored.
+func GPSDPublisher(cfg GPSDConfig) (PosPublisher, error) {
+	if cfg.GPSID == "" {
+		return nil, errors.New("GPS ID of the gpsd publisher must be set")
+	}
+	lst, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("Error listening on %s: %w", cfg.Address, err)
+	}
+	pub := &gpsdPosPub{
+		lst:     lst,
+		device:  cfg.GPSID,
+		clients: map[*gpsdClient]struct{}{},
+	}
+	go pub.accept()
+	return pub, nil
+}
+
+// PublishPos reports a position of the selected GPS to watching clients
+func (pub *gpsdPosPub) PublishPos(pos gps.Position) error {
+	if pos.GPS.ID() != pub.device {
+		return nil
+	}
+	tpv, err := json.Marshal(pub.tpvReport(pos))
+	if err != nil {
+		return err
+	}
+	sky, err := json.Marshal(pub.skyReport(pos))
+	if err != nil {
+		return err
+	}
+
+	pub.Lock()
+	pub.tpv, pub.sky = tpv, sky
+	clients := make([]*gpsdClient, 0, len(pub.clients))
+	for client := range pub.clients {
+		clients = append(clients, client)
+	}
+	pub.Unlock()
+
+	for _, client := range clients {
+		client.Lock()
+		watching := client.watching
+		client.Unlock()
+		if watching && (client.send(tpv) != nil || client.send(sky) != nil) {
+			pub.disconnect(client)
+		}
+	}
+	return nil
+}
+
+// Close stops the server and disconnects every client
+func (pub *gpsdPosPub) Close() error {
+	err := pub.lst.Close()
+	pub.Lock()
+	for client := range pub.clients {
+		client.conn.Close()
+	}
+	pub.Unlock()
+	return err
+}
+
+// Accepts connections until the listener is closed
+func (pub *gpsdPosPub) accept() {
+	for {
+		conn, err := pub.lst.Accept()
+		if err != nil {
+			return
+		}
+		client := &gpsdClient{conn: conn}
+		pub.Lock()
+		pub.clients[client] = struct{}{}
+		pub.Unlock()
+		go pub.serve(client)
+	}
+}
+
+// Greets a client and handles its commands until it disconnects
+func (pub *gpsdPosPub) serve(client *gpsdClient) {
+	defer pub.disconnect(client)
+	if client.sendJSON(gpsdVersion) != nil {
+		return
+	}
+
+	scanner := bufio.NewScanner(client.conn)
+	for scanner.Scan() {
+		// Commands end with a semicolon, a line break, or both
+		for _, cmd := range strings.Split(scanner.Text(), ";") {
+			if cmd = strings.TrimSpace(cmd); cmd == "" {
+				continue
+			}
+			if err := pub.handle(client, cmd); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Handles a client command. Only errors sending responses are returned.
+func (pub *gpsdPosPub) handle(client *gpsdClient, cmd string) error {
+	name, args := cmd, ""
+	if i := strings.Index(cmd, "="); i >= 0 {
+		name, args = cmd[:i], cmd[i+1:]
+	}
+
+	switch name {
+	case "?VERSION":
+		return client.sendJSON(gpsdVersion)
+	case "?DEVICES":
+		return client.sendJSON(pub.devicesReport())
+	case "?WATCH":
+		watch := gpsdWatch{Class: "WATCH", Enable: true, JSON: true}
+		if args != "" {
+			if err := json.Unmarshal([]byte(args), &watch); err != nil {
+				return client.sendError(fmt.Sprintf("Invalid WATCH: %v", err))
+			}
+		}
+		client.Lock()
+		client.watching = watch.Enable
+		client.Unlock()
+		if err := client.sendJSON(pub.devicesReport()); err != nil {
+			return err
+		}
+		return client.sendJSON(watch)
+	case "?POLL":
+		return client.sendJSON(pub.pollReport())
+	default:
+		return client.sendError(fmt.Sprintf("Unrecognized request '%s'", name))
+	}
+}
+
+func (pub *gpsdPosPub) disconnect(client *gpsdClient) {
+	client.conn.Close()
+	pub.Lock()
+	delete(pub.clients, client)
+	pub.Unlock()
+}
+
+// Sends a report, ended by a line break
+func (client *gpsdClient) send(report []byte) error {
+	client.Lock()
+	defer client.Unlock()
+	client.conn.SetWriteDeadline(time.Now().Add(gpsdWriteTimeout))
+	// Reports are shared by clients, so they aren't appended to
+	line := make([]byte, len(report)+1)
+	copy(line, report)
+	line[len(report)] = '\n'
+	_, err := client.conn.Write(line)
+	return err
+}
+
+func (client *gpsdClient) sendJSON(report interface{}) error {
+	bs, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return client.send(bs)
+}
+
+func (client *gpsdClient) sendError(msg string) error {
+	return client.sendJSON(map[string]string{"class": "ERROR", "message": msg})
+}
+
+var gpsdVersion = map[string]interface{}{
+	"class":       "VERSION",
+	"release":     "3.20",
+	"rev":         "routesim",
+	"proto_major": 3,
+	"proto_minor": 14,
+}
+
+type gpsdWatch struct {
+	Class  string `json:"class"`
+	Enable bool   `json:"enable"`
+	JSON   bool   `json:"json"`
+}
+
+type gpsdDevice struct {
+	Class  string `json:"class"`
+	Path   string `json:"path"`
+	Driver string `json:"driver"`
+}
+
+type gpsdTPV struct {
+	Class  string  `json:"class"`
+	Device string  `json:"device"`
+	Mode   int     `json:"mode"`
+	Time   string  `json:"time"`
+	Ept    float64 `json:"ept"`
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+	Alt    float64 `json:"alt"`
+	Track  float64 `json:"track"`
+	Speed  float64 `json:"speed"`
+	Climb  float64 `json:"climb"`
+}
+
+type gpsdSatellite struct {
+	PRN  int  `json:"PRN"`
+	El   int  `json:"el"`
+	Az   int  `json:"az"`
+	SS   int  `json:"ss"`
+	Used bool `json:"used"`
+}
+
+type gpsdSKY struct {
+	Class      string          `json:"class"`
+	Device     string          `json:"device"`
+	Time       string          `json:"time"`
+	HDOP       float64         `json:"hdop"`
+	Satellites []gpsdSatellite `json:"satellites"`
+}
+
+// Fixed constellation reported for every fix, good enough for a 3D fix
+var gpsdSatellites = []gpsdSatellite{
+	{PRN: 2, El: 62, Az: 47, SS: 42, Used: true},
+	{PRN: 5, El: 35, Az: 112, SS: 39, Used: true},
+	{PRN: 12, El: 71, Az: 230, SS: 44, Used: true},
+	{PRN: 15, El: 22, Az: 301, SS: 35, Used: true},
+	{PRN: 18, El: 48, Az: 168, SS: 41, Used: true},
+	{PRN: 24, El: 15, Az: 20, SS: 31, Used: true},
+	{PRN: 25, El: 53, Az: 276, SS: 43, Used: true},
+	{PRN: 29, El: 9, Az: 190, SS: 28, Used: false},
+}
+
+func (pub *gpsdPosPub) devicesReport() interface{} {
+	return map[string]interface{}{
+		"class": "DEVICES",
+		"devices": []gpsdDevice{
+			{Class: "DEVICE", Path: pub.device, Driver: "routesim"},
+		},
+	}
+}
+
+// 3D fix of a position
+func (pub *gpsdPosPub) tpvReport(pos gps.Position) gpsdTPV {
+	return gpsdTPV{
+		Class:  "TPV",
+		Device: pub.device,
+		Mode:   3,
+		Time:   pos.At.UTC().Format(gpsdTimeFormat),
+		Ept:    0.005,
+		Lat:    pos.Lat.Degrees(),
+		Lon:    pos.Lng.Degrees(),
+		Track:  pos.Course,
+		Speed:  pos.Speed,
+	}
+}
+
+func (pub *gpsdPosPub) skyReport(pos gps.Position) gpsdSKY {
+	return gpsdSKY{
+		Class:      "SKY",
+		Device:     pub.device,
+		Time:       pos.At.UTC().Format(gpsdTimeFormat),
+		HDOP:       1.0,
+		Satellites: gpsdSatellites,
+	}
+}
+
+// Last fix and sky view, if any
+func (pub *gpsdPosPub) pollReport() interface{} {
+	pub.Lock()
+	defer pub.Unlock()
+	tpv, sky := []json.RawMessage{}, []json.RawMessage{}
+	active := 0
+	if pub.tpv != nil {
+		tpv, sky = []json.RawMessage{pub.tpv}, []json.RawMessage{pub.sky}
+		active = 1
+	}
+	return map[string]interface{}{
+		"class":  "POLL",
+		"time":   time.Now().UTC().Format(gpsdTimeFormat),
+		"active": active,
+		"tpv":    tpv,
+		"sky":    sky,
+	}
+}
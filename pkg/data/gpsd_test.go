@@ -0,0 +1,90 @@
+package data
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/gps"
+	"github.com/gpontesss/routesim/pkg/gps/gpstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Client of a gpsd server that reads its reports
+type gpsdTestClient struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+func dialGPSD(t *testing.T, pub PosPublisher) *gpsdTestClient {
+	conn, err := net.Dial("tcp", pub.(*gpsdPosPub).lst.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &gpsdTestClient{conn, bufio.NewScanner(conn)}
+}
+
+func (c *gpsdTestClient) send(t *testing.T, cmd string) {
+	_, err := fmt.Fprintln(c.conn, cmd)
+	require.NoError(t, err)
+}
+
+// Reads a report and checks its class
+func (c *gpsdTestClient) read(t *testing.T, class string) map[string]interface{} {
+	require.True(t, c.scanner.Scan(), "Expected %s report", class)
+	var report map[string]interface{}
+	require.NoError(t, json.Unmarshal(c.scanner.Bytes(), &report))
+	require.Equal(t, class, report["class"], c.scanner.Text())
+	return report
+}
+
+func TestGPSDPublisher(t *testing.T) {
+	pub, err := GPSDPublisher(GPSDConfig{Address: "127.0.0.1:0", GPSID: "TEST1234"})
+	require.NoError(t, err)
+	defer Close(pub)
+
+	client := dialGPSD(t, pub)
+	client.read(t, "VERSION")
+	client.send(t, `?POLL;`)
+	assert.Equal(t, 0.0, client.read(t, "POLL")["active"])
+
+	client.send(t, `?WATCH={"enable":true,"json":true};`)
+	devices := client.read(t, "DEVICES")["devices"].([]interface{})
+	assert.Equal(t, "TEST1234", devices[0].(map[string]interface{})["path"])
+	assert.Equal(t, true, client.read(t, "WATCH")["enable"])
+
+	at := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	other := gpstest.TestGPS("OTHER")
+	require.NoError(t, pub.PublishPos(gps.Position{GPS: other, At: at}))
+	require.NoError(t, pub.PublishPos(gps.Position{
+		LatLng: s2.LatLngFromDegrees(-23.5, -46.6),
+		GPS:    gpstest.TestGPS("TEST1234"),
+		At:     at,
+		Speed:  12.5,
+		Course: 270,
+	}))
+
+	tpv := client.read(t, "TPV")
+	assert.Equal(t, "TEST1234", tpv["device"])
+	assert.Equal(t, 3.0, tpv["mode"])
+	assert.Equal(t, "2020-10-01T12:00:00.000Z", tpv["time"])
+	assert.InDelta(t, -23.5, tpv["lat"], 1e-9)
+	assert.InDelta(t, -46.6, tpv["lon"], 1e-9)
+	assert.Equal(t, 12.5, tpv["speed"])
+	assert.Equal(t, 270.0, tpv["track"])
+	sky := client.read(t, "SKY")
+	assert.NotEmpty(t, sky["satellites"])
+
+	client.send(t, `?POLL;`)
+	poll := client.read(t, "POLL")
+	assert.Equal(t, 1.0, poll["active"])
+	assert.Len(t, poll["tpv"], 1)
+
+	client.send(t, `?JUMP;`)
+	client.read(t, "ERROR")
+}
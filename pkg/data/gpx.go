@@ -0,0 +1,84 @@
+package data
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gpontesss/routesim/pkg/gps"
+)
+
+const (
+	gpxHeader = xml.Header +
+		`<gpx version="1.1" creator="routesim" xmlns="http://www.topografix.com/GPX/1/1"` +
+		` xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v2">` + "\n"
+	gpxFooter = "</gpx>\n"
+)
+
+type gpxPosPub struct {
+	sync.Mutex
+	spool *trackSpool
+}
+
+// GPXPublisher creates a publisher that writes positions to a GPX document,
+// as a track per device, named after its GPS ID. Every track point has its
+// time and, as a Garmin extension, its speed and course. Points are spooled
+// to disk while the simulation runs; the document is only written when the
+// publisher is closed.
+func GPXPublisher(path string) (PosPublisher, error) {
+	spool, err := newTrackSpool(path, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &gpxPosPub{spool: spool}, nil
+}
+
+// PublishPos spools a position as a point of its device's track
+func (pub *gpxPosPub) PublishPos(pos gps.Position) error {
+	pt := fmt.Sprintf(`<trkpt lat="%s" lon="%s"><time>%s</time>`+
+		`<extensions><gpxtpx:TrackPointExtension><gpxtpx:speed>%s</gpxtpx:speed>`+
+		`<gpxtpx:course>%s</gpxtpx:course></gpxtpx:TrackPointExtension></extensions></trkpt>`+"\n",
+		formatFloat(pos.Lat.Degrees()), formatFloat(pos.Lng.Degrees()),
+		pos.At.UTC().Format(time.RFC3339Nano),
+		formatFloat(pos.Speed), formatFloat(pos.Course))
+
+	pub.Lock()
+	defer pub.Unlock()
+	if pub.spool.done {
+		return ErrPublisherDone
+	}
+	return pub.spool.append(pos.GPS.ID(), nil, 0, []byte(pt))
+}
+
+// Flush writes spooled points to disk
+func (pub *gpxPosPub) Flush() error {
+	pub.Lock()
+	defer pub.Unlock()
+	if pub.spool.done {
+		return nil
+	}
+	return pub.spool.flush()
+}
+
+// Close writes the GPX document
+func (pub *gpxPosPub) Close() error {
+	pub.Lock()
+	defer pub.Unlock()
+	return pub.spool.finish(gpxHeader, gpxFooter, func(w *trackWriter) error {
+		fmt.Fprintf(w, "<trk><name>%s</name><trkseg>\n", xmlEscape(w.track.id))
+		if err := w.copyStream(0); err != nil {
+			return err
+		}
+		w.WriteString("</trkseg></trk>\n")
+		return nil
+	})
+}
+
+// Escapes text for XML character data and attribute values
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,73 @@
+package data
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/gps"
+	"github.com/gpontesss/routesim/pkg/route"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Publishes positions of two devices, interleaved. Each of them moves
+// eastward a hundredth of a degree a minute.
+func publishTracks(t *testing.T, pub PosPublisher) []gps.Position {
+	at := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	var published []gps.Position
+	for i := 0; i < 6; i++ {
+		id := []string{"bus-1", "bus&2"}[i%2]
+		pos := testPos(id, map[string]interface{}{"line": 8000 + i%2})
+		pos.LatLng = s2.LatLngFromDegrees(-23.5, -46.6+float64(i/2)*0.01)
+		pos.At = at.Add(time.Duration(i/2) * time.Minute)
+		pos.Speed = 12
+		pos.Course = 90
+		require.NoError(t, pub.PublishPos(pos))
+		published = append(published, pos)
+	}
+	return published
+}
+
+func TestGPXPublisher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routesim")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "out", "tracks.gpx")
+	pub, err := GPXPublisher(path)
+	require.NoError(t, err)
+	publishTracks(t, pub)
+
+	// Positions reach the disk before the document is written
+	require.NoError(t, pub.(Flusher).Flush())
+	spooled, err := ioutil.ReadFile(path + ".spool")
+	require.NoError(t, err)
+	assert.Contains(t, string(spooled), `<trkpt lat="-23.5" lon="-46.6">`)
+	assert.NoFileExists(t, path)
+
+	require.NoError(t, Close(pub))
+	assert.NoFileExists(t, path+".spool")
+	assert.ErrorIs(t, pub.PublishPos(testPos("bus-1", nil)), ErrPublisherDone)
+
+	for _, id := range []string{"bus-1", "bus&2"} {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		trk, err := route.FromGPX(f, route.GPXSelector{Name: id})
+		f.Close()
+		require.NoError(t, err, id)
+
+		require.Len(t, trk, 3)
+		for i, smp := range trk {
+			assert.True(t, s2.LatLngFromDegrees(-23.5, -46.6+float64(i)*0.01).ApproxEqual(smp.LatLng))
+			assert.Equal(t, time.Date(2020, 10, 1, 12, i, 0, 0, time.UTC), smp.Time)
+		}
+	}
+
+	doc, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(doc), "<gpxtpx:speed>12</gpxtpx:speed><gpxtpx:course>90</gpxtpx:course>")
+}
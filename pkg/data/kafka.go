@@ -0,0 +1,353 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gpontesss/routesim/pkg/gps"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+const (
+	// Default number of messages sent in a single batch
+	kafkaDefaultBatchSize = 100
+	// Default time a message may wait in the buffer before being sent
+	kafkaDefaultLinger = time.Second
+	// Default time a batch write may take
+	kafkaDefaultWriteTimeout = 10 * time.Second
+	// Default number of batches that may be kept in the buffer while the
+	// brokers fail
+	kafkaDefaultMaxBufferedBatches = 10
+	// Prefix of the headers that carry GPS metadata
+	kafkaMetadataHeaderPrefix = "metadata."
+)
+
+// KafkaConfig describes how to connect and write to a Kafka topic
+type KafkaConfig struct {
+	// Bootstrap broker addresses, e.g. "localhost:9092"
+	Brokers []string
+	// Topic messages are written to
+	Topic string
+	// Maximum number of messages sent in a single batch
+	BatchSize int
+	// Maximum time a message waits in the buffer before its batch is sent
+	Linger time.Duration
+	// Compression codec: none, gzip, snappy, lz4 or zstd
+	Compression string
+	// Acknowledgements required for a write to succeed: -1 (all in-sync
+	// replicas), 0 (none) or 1 (the partition's leader)
+	RequiredAcks int
+	// Maximum time a batch write may take. Defaults to 10s.
+	WriteTimeout time.Duration
+	// Maximum number of messages kept in the buffer, including the ones that
+	// failed to be written and wait for the next flush. The oldest messages
+	// are dropped beyond it. Defaults to 10 batches.
+	MaxBuffered int
+	// SASL mechanism: plain, scram-sha-256 or scram-sha-512. Authentication
+	// is disabled if empty.
+	SASLMechanism string
+	SASLUsername  string
+	SASLPassword  string
+	// TLS options. If nil, connections aren't encrypted.
+	TLS *TLSConfig
+}
+
+// Builds the kafka-go writer
+func (cfg KafkaConfig) writer() (*kafka.Writer, error) {
+	codec, err := kafkaCompression(cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RequiredAcks < -1 || cfg.RequiredAcks > 1 {
+		return nil, fmt.Errorf("Invalid Kafka required acks %d", cfg.RequiredAcks)
+	}
+	mech, err := kafkaSASLMechanism(cfg.SASLMechanism, cfg.SASLUsername, cfg.SASLPassword)
+	if err != nil {
+		return nil, err
+	}
+	transport := &kafka.Transport{SASL: mech}
+	if cfg.TLS != nil {
+		if transport.TLS, err = cfg.TLS.build(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &kafka.Writer{
+		Addr:  kafka.TCP(cfg.Brokers...),
+		Topic: cfg.Topic,
+		// Partitions keys like the Java client, so other producers of the
+		// same devices keep their order
+		Balancer:     &kafka.Murmur2Balancer{},
+		BatchSize:    cfg.BatchSize,
+		BatchTimeout: time.Millisecond,
+		WriteTimeout: cfg.WriteTimeout,
+		RequiredAcks: kafka.RequiredAcks(cfg.RequiredAcks),
+		Compression:  codec,
+		Transport:    transport,
+		// Single-node brokers used for testing often rely on it
+		AllowAutoTopicCreation: true,
+	}, nil
+}
+
+func kafkaCompression(name string) (kafka.Compression, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	default:
+		return 0, fmt.Errorf("Unknown Kafka compression '%s'", name)
+	}
+}
+
+func kafkaSASLMechanism(name, username, password string) (sasl.Mechanism, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	default:
+		return nil, fmt.Errorf("Unknown SASL mechanism '%s'", name)
+	}
+}
+
+// Writes messages to Kafka. It is implemented by *kafka.Writer.
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+type kafkaPosPub struct {
+	sync.Mutex
+	// Held while flushing, so batches are sent in order
+	flushMu      sync.Mutex
+	wtr          kafkaWriter
+	fmtr         PosFormatter
+	contentType  string
+	batchSize    int
+	writeTimeout time.Duration
+	maxBuffered  int
+	buffer       []kafka.Message
+	errChan      chan error
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+// KafkaPublisher creates a publisher that writes formatted positions to a
+// Kafka topic. The GPS ID is used as message key, so positions of a device go
+// to the same partition and are kept in order. Messages carry the formatter's
+// content type in a content-type header and each GPS metadata entry in a
+// metadata.<key> header. They are buffered and sent in batches, either when
+// the batch is full or when the linger time expires.
+func KafkaPublisher(cfg KafkaConfig, fmtr PosFormatter) (PosPublisher, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("Kafka brokers must be set")
+	}
+	if cfg.Topic == "" {
+		return nil, errors.New("Kafka topic must be set")
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = kafkaDefaultBatchSize
+	}
+	wtr, err := cfg.writer()
+	if err != nil {
+		return nil, err
+	}
+	return kafkaPublisherWithWriter(wtr, cfg, fmtr), nil
+}
+
+// Creates a Kafka publisher that uses the given writer
+func kafkaPublisherWithWriter(wtr kafkaWriter, cfg KafkaConfig, fmtr PosFormatter) *kafkaPosPub {
+	pub := &kafkaPosPub{
+		wtr:          wtr,
+		fmtr:         fmtr,
+		contentType:  ContentType(fmtr),
+		batchSize:    cfg.BatchSize,
+		writeTimeout: cfg.WriteTimeout,
+		maxBuffered:  cfg.MaxBuffered,
+		errChan:      make(chan error, 1),
+		done:         make(chan struct{}),
+	}
+	if pub.batchSize <= 0 {
+		pub.batchSize = kafkaDefaultBatchSize
+	}
+	if pub.writeTimeout <= 0 {
+		pub.writeTimeout = kafkaDefaultWriteTimeout
+	}
+	if pub.maxBuffered < pub.batchSize {
+		pub.maxBuffered = kafkaDefaultMaxBufferedBatches * pub.batchSize
+	}
+	linger := cfg.Linger
+	if linger <= 0 {
+		linger = kafkaDefaultLinger
+	}
+	pub.init(linger)
+	return pub
+}
+
+// Initializes a goroutine that flushes the buffer every time the linger time
+// expires. Errors are reported on the next PublishPos call.
+func (pub *kafkaPosPub) init(linger time.Duration) {
+	go func() {
+		ticker := time.NewTicker(linger)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-pub.done:
+				return
+			case <-ticker.C:
+			}
+			if err := pub.Flush(); err != nil {
+				select {
+				case pub.errChan <- err:
+				default:
+				}
+			}
+		}
+	}()
+}
+
+// PublishPos formats a position and appends it to the buffer. If the buffer
+// reaches the batch size, it is sent.
+func (pub *kafkaPosPub) PublishPos(pos gps.Position) error {
+	select {
+	case err := <-pub.errChan:
+		return err
+	default:
+	}
+
+	bs, err := pub.fmtr.Format(pos)
+	if err != nil {
+		return err
+	}
+
+	pub.Lock()
+	pub.buffer = append(pub.buffer, kafka.Message{
+		Key:     []byte(pos.GPS.ID()),
+		Value:   bs,
+		Headers: pub.headers(pos),
+		Time:    pos.At,
+	})
+	full := len(pub.buffer) >= pub.batchSize
+	pub.Unlock()
+
+	if full {
+		return pub.Flush()
+	}
+	return nil
+}
+
+// Content type and metadata headers of a position's message. Metadata keys
+// are sorted, so headers are stable.
+func (pub *kafkaPosPub) headers(pos gps.Position) []kafka.Header {
+	metadata := pos.GPS.Metadata()
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	headers := make([]kafka.Header, 0, len(keys)+1)
+	headers = append(headers, kafka.Header{Key: "content-type", Value: []byte(pub.contentType)})
+	for _, key := range keys {
+		headers = append(headers, kafka.Header{
+			Key:   kafkaMetadataHeaderPrefix + key,
+			Value: []byte(fmt.Sprint(metadata[key])),
+		})
+	}
+	return headers
+}
+
+// Flush sends all buffered messages. Messages that fail to be written are
+// kept in the buffer for the next flush.
+func (pub *kafkaPosPub) Flush() error {
+	pub.flushMu.Lock()
+	defer pub.flushMu.Unlock()
+	for {
+		pub.Lock()
+		n := len(pub.buffer)
+		if n > pub.batchSize {
+			n = pub.batchSize
+		}
+		batch := pub.buffer[:n]
+		pub.buffer = pub.buffer[n:]
+		pub.Unlock()
+		if n == 0 {
+			return nil
+		}
+
+		if failed, err := pub.write(batch); err != nil {
+			if dropped := pub.requeue(failed); dropped > 0 {
+				err = fmt.Errorf("%w; dropped %d messages of the full buffer", err, dropped)
+			}
+			return err
+		}
+	}
+}
+
+// Writes a batch of messages, within the write timeout. The messages that
+// weren't written are returned along with the error.
+func (pub *kafkaPosPub) write(msgs []kafka.Message) ([]kafka.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pub.writeTimeout)
+	defer cancel()
+	err := pub.wtr.WriteMessages(ctx, msgs...)
+	if err == nil {
+		return nil, nil
+	}
+
+	failed := msgs
+	var werrs kafka.WriteErrors
+	if errors.As(err, &werrs) && len(werrs) == len(msgs) {
+		failed = make([]kafka.Message, 0, werrs.Count())
+		for i, werr := range werrs {
+			if werr != nil {
+				failed = append(failed, msgs[i])
+			}
+		}
+	}
+	return failed, fmt.Errorf("Error writing %d messages to Kafka: %w", len(failed), err)
+}
+
+// Puts failed messages back at the start of the buffer, dropping the oldest
+// ones beyond its limit. It returns how many were dropped.
+func (pub *kafkaPosPub) requeue(failed []kafka.Message) int {
+	pub.Lock()
+	defer pub.Unlock()
+	buffer := make([]kafka.Message, 0, len(failed)+len(pub.buffer))
+	pub.buffer = append(append(buffer, failed...), pub.buffer...)
+	dropped := len(pub.buffer) - pub.maxBuffered
+	if dropped <= 0 {
+		return 0
+	}
+	pub.buffer = pub.buffer[dropped:]
+	return dropped
+}
+
+// Close stops the linger timer and closes the writer. Buffered messages must
+// be flushed beforehand.
+func (pub *kafkaPosPub) Close() error {
+	var err error
+	pub.closeOnce.Do(func() {
+		close(pub.done)
+		err = pub.wtr.Close()
+	})
+	return err
+}
@@ -0,0 +1,226 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Fake Kafka writer that records written batches. It fails while err is set,
+// and rejects the messages whose keys are in rejected. Writes hang until
+// their context is done while hang is set.
+type fakeKafka struct {
+	sync.Mutex
+	err      error
+	rejected map[string]bool
+	hang     bool
+	batches  [][]kafka.Message
+	closed   bool
+}
+
+func (k *fakeKafka) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	k.Lock()
+	hang := k.hang
+	k.Unlock()
+	if hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	k.Lock()
+	defer k.Unlock()
+	if k.err != nil {
+		return k.err
+	}
+	werrs := make(kafka.WriteErrors, len(msgs))
+	var written []kafka.Message
+	for i, msg := range msgs {
+		if k.rejected[string(msg.Key)] {
+			werrs[i] = kafka.LeaderNotAvailable
+		} else {
+			written = append(written, msg)
+		}
+	}
+	k.batches = append(k.batches, written)
+	if werrs.Count() > 0 {
+		return werrs
+	}
+	return nil
+}
+
+func (k *fakeKafka) Close() error {
+	k.closed = true
+	return nil
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	wtr := new(fakeKafka)
+	pub := kafkaPublisherWithWriter(wtr, KafkaConfig{BatchSize: 2, Linger: time.Hour}, GeoJSONFormatter)
+
+	require.NoError(t, pub.PublishPos(testPos("bus-1", map[string]interface{}{"line": 8000, "kind": "bus"})))
+	require.NoError(t, pub.PublishPos(testPos("bus-2", nil)))
+	require.NoError(t, pub.PublishPos(testPos("bus-3", nil)))
+	require.Len(t, wtr.batches, 1)
+
+	msg := wtr.batches[0][0]
+	assert.Equal(t, "bus-1", string(msg.Key))
+	assert.Contains(t, string(msg.Value), `"Feature"`)
+	assert.Equal(t, []kafka.Header{
+		{Key: "content-type", Value: []byte("application/geo+json")},
+		{Key: "metadata.kind", Value: []byte("bus")},
+		{Key: "metadata.line", Value: []byte("8000")},
+	}, msg.Headers)
+	assert.Equal(t, "bus-2", string(wtr.batches[0][1].Key))
+
+	require.NoError(t, Close(pub))
+	require.Len(t, wtr.batches, 2)
+	assert.Equal(t, "bus-3", string(wtr.batches[1][0].Key))
+	assert.True(t, wtr.closed)
+}
+
+func TestKafkaPublisherLingerErrors(t *testing.T) {
+	wtr := &fakeKafka{err: errors.New("Leader not available")}
+	pub := kafkaPublisherWithWriter(wtr, KafkaConfig{Linger: 10 * time.Millisecond}, idFormatter)
+	defer pub.Close()
+
+	require.NoError(t, pub.PublishPos(testPos("bus-1", nil)))
+	assert.Eventually(t, func() bool {
+		err := pub.PublishPos(testPos("bus-1", nil))
+		return err != nil && strings.Contains(err.Error(), "Leader not available")
+	}, time.Second, 10*time.Millisecond)
+
+	wtr.Lock()
+	wtr.err = nil
+	wtr.Unlock()
+	require.NoError(t, pub.Flush())
+	assert.NotEmpty(t, wtr.batches)
+}
+
+func TestKafkaPublisherKeepsFailedMessages(t *testing.T) {
+	wtr := &fakeKafka{rejected: map[string]bool{"bus-1": true}}
+	pub := kafkaPublisherWithWriter(wtr, KafkaConfig{BatchSize: 2, Linger: time.Hour, MaxBuffered: 3}, idFormatter)
+	defer pub.Close()
+
+	require.NoError(t, pub.PublishPos(testPos("bus-1", nil)))
+	// The first message is rejected, but the second one is written
+	require.Error(t, pub.PublishPos(testPos("bus-2", nil)))
+	require.Len(t, pub.buffer, 1)
+	assert.Equal(t, "bus-1", string(pub.buffer[0].Key))
+
+	wtr.rejected = nil
+	require.NoError(t, pub.Flush())
+	assert.Len(t, pub.buffer, 0)
+	var written []string
+	for _, batch := range wtr.batches {
+		for _, msg := range batch {
+			written = append(written, string(msg.Key))
+		}
+	}
+	assert.Equal(t, []string{"bus-2", "bus-1"}, written)
+
+	// Failed messages beyond the limit are dropped
+	wtr.err = errors.New("Leader not available")
+	for _, id := range []string{"bus-3", "bus-4", "bus-5", "bus-6"} {
+		pub.Lock()
+		pub.buffer = append(pub.buffer, kafka.Message{Key: []byte(id)})
+		pub.Unlock()
+	}
+	err := pub.Flush()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "dropped 1 messages")
+	require.Len(t, pub.buffer, 3)
+	assert.Equal(t, "bus-4", string(pub.buffer[0].Key))
+}
+
+func TestKafkaPublisherWriteTimeout(t *testing.T) {
+	wtr := &fakeKafka{hang: true}
+	pub := kafkaPublisherWithWriter(wtr, KafkaConfig{BatchSize: 2, Linger: time.Hour, WriteTimeout: 50 * time.Millisecond}, idFormatter)
+	defer pub.Close()
+
+	require.NoError(t, pub.PublishPos(testPos("bus-1", nil)))
+	flushed := make(chan error)
+	go func() { flushed <- pub.Flush() }()
+	assert.Eventually(t, func() bool {
+		pub.Lock()
+		defer pub.Unlock()
+		return len(pub.buffer) == 0
+	}, time.Second, time.Millisecond)
+
+	// Publishing doesn't wait for the hanging write
+	require.NoError(t, pub.PublishPos(testPos("bus-2", nil)))
+	err := <-flushed
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	var keys []string
+	for _, msg := range pub.buffer {
+		keys = append(keys, string(msg.Key))
+	}
+	assert.Equal(t, []string{"bus-1", "bus-2"}, keys)
+}
+
+func TestKafkaPublisherConfig(t *testing.T) {
+	cases := map[string]struct {
+		cfg KafkaConfig
+		err string
+	}{
+		"Valid": {KafkaConfig{
+			Brokers: []string{"localhost:9092"}, Topic: "fleet",
+			Compression: "zstd", RequiredAcks: -1,
+			SASLMechanism: "scram-sha-512", SASLUsername: "fleet", SASLPassword: "secret",
+			TLS: &TLSConfig{},
+		}, ""},
+		"NoBrokers":      {KafkaConfig{Topic: "fleet"}, "brokers"},
+		"NoTopic":        {KafkaConfig{Brokers: []string{"localhost:9092"}}, "topic"},
+		"BadCompression": {KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "fleet", Compression: "rar"}, "compression"},
+		"BadAcks":        {KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "fleet", RequiredAcks: 2}, "acks"},
+		"BadSASL":        {KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "fleet", SASLMechanism: "kerberos"}, "SASL"},
+		"BadTLS":         {KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "fleet", TLS: &TLSConfig{CertFile: "cert.pem"}}, "TLS"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			pub, err := KafkaPublisher(c.cfg, idFormatter)
+			if c.err == "" {
+				require.NoError(t, err)
+				Close(pub)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), c.err)
+			}
+		})
+	}
+}
+
+// Writes to and reads from a local broker, e.g. a single-node Kafka started
+// with docker. It only runs if ROUTESIM_KAFKA_BROKERS is set.
+func TestKafkaPublisherBroker(t *testing.T) {
+	brokers := os.Getenv("ROUTESIM_KAFKA_BROKERS")
+	if brokers == "" {
+		t.Skip("ROUTESIM_KAFKA_BROKERS isn't set")
+	}
+	topic := "routesim-test-" + time.Now().Format("20060102150405")
+	pub, err := KafkaPublisher(KafkaConfig{
+		Brokers:     strings.Split(brokers, ","),
+		Topic:       topic,
+		Compression: "gzip",
+	}, idFormatter)
+	require.NoError(t, err)
+	require.NoError(t, pub.PublishPos(testPos("bus-1", map[string]interface{}{"kind": "bus"})))
+	require.NoError(t, Close(pub))
+
+	rdr := kafka.NewReader(kafka.ReaderConfig{Brokers: strings.Split(brokers, ","), Topic: topic})
+	defer rdr.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	msg, err := rdr.ReadMessage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "bus-1", string(msg.Key))
+	assert.Equal(t, "bus-1", string(msg.Value))
+}
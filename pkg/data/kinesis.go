@@ -2,20 +2,263 @@ package data
 
 import (
 	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/gpontesss/routesim/pkg/gps"
+)
+
+const (
+	// Maximum number of records accepted by a single PutRecords call
+	kinesisMaxBatchSize = 500
+	// Default time a record may wait in the buffer before being sent
+	kinesisDefaultLinger = time.Second
+	// Default number of times partially failed records are retried
+	kinesisDefaultMaxRetries = 3
+	// Base wait time between retries. It doubles on each attempt.
+	kinesisRetryBackoff = 100 * time.Millisecond
+	// Default number of batches that may be kept in the buffer while the
+	// stream fails
+	kinesisDefaultMaxBufferedBatches = 10
 )
 
+// KinesisConfig describes how to connect and write to a Kinesis stream
+type KinesisConfig struct {
+	// Name of the stream records are put into
+	StreamName string
+	// AWS region. If empty, it is resolved from the environment.
+	Region string
+	// Custom endpoint URL, e.g. a local Kinesis stand-in such as kinesalite
+	Endpoint string
+	// Static credentials. If AccessKeyID is empty, the default credentials
+	// chain is used.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Maximum number of records sent in a single batch (up to 500)
+	BatchSize int
+	// Maximum time a record waits in the buffer before its batch is sent
+	Linger time.Duration
+	// Number of times partially failed records are retried
+	MaxRetries int
+	// Maximum number of records kept in the buffer, including the ones that
+	// failed to be put and wait for the next flush. The oldest records are
+	// dropped beyond it. Defaults to 10 batches.
+	MaxBuffered int
+}
+
+// Builds the AWS SDK configuration
+func (cfg KinesisConfig) awsConfig() *aws.Config {
+	awscfg := aws.NewConfig()
+	if cfg.Region != "" {
+		awscfg = awscfg.WithRegion(cfg.Region)
+	}
+	if cfg.Endpoint != "" {
+		awscfg = awscfg.WithEndpoint(cfg.Endpoint)
+	}
+	if cfg.AccessKeyID != "" {
+		awscfg = awscfg.WithCredentials(credentials.NewStaticCredentials(
+			cfg.AccessKeyID,
+			cfg.SecretAccessKey,
+			cfg.SessionToken,
+		))
+	}
+	return awscfg
+}
+
 type kinesisPosPub struct {
-	streamName string
-	// TODO: add AWS credentials as optional
+	sync.Mutex
+	// Held while flushing, so batches are sent in order
+	flushMu     sync.Mutex
+	client      kinesisiface.KinesisAPI
+	streamName  string
+	fmtr        PosFormatter
+	batchSize   int
+	maxRetries  int
+	maxBuffered int
+	buffer      []*kinesis.PutRecordsRequestEntry
+	errChan     chan error
+	done        chan struct{}
+	closeOnce   sync.Once
 }
 
-// KinesisPublisher docs here
-func KinesisPublisher(streamName string) Publisher {
-	return &kinesisPosPub{streamName: streamName}
+// KinesisPublisher creates a publisher that puts formatted positions into a
+// Kinesis stream. Records are buffered and sent in batches, either when the
+// batch is full or when the linger time expires. The GPS ID is used as
+// partition key, so positions of a device are kept in order.
+func KinesisPublisher(cfg KinesisConfig, fmtr PosFormatter) (PosPublisher, error) {
+	if cfg.StreamName == "" {
+		return nil, errors.New("Kinesis stream name must be set")
+	}
+	sess, err := session.NewSession(cfg.awsConfig())
+	if err != nil {
+		return nil, fmt.Errorf("Error creating AWS session: %w", err)
+	}
+	return kinesisPublisherWithClient(kinesis.New(sess), cfg, fmtr), nil
 }
 
-// PublishPos docs here
-// TODO: deal with buffering and batch writing
-func (p *kinesisPosPub) Publish(bs []byte) error {
-	return errors.New("Not implemented")
+// Creates a Kinesis publisher that uses the given client
+func kinesisPublisherWithClient(client kinesisiface.KinesisAPI, cfg KinesisConfig, fmtr PosFormatter) *kinesisPosPub {
+	pub := &kinesisPosPub{
+		client:      client,
+		streamName:  cfg.StreamName,
+		fmtr:        fmtr,
+		batchSize:   cfg.BatchSize,
+		maxRetries:  cfg.MaxRetries,
+		maxBuffered: cfg.MaxBuffered,
+		errChan:     make(chan error, 1),
+		done:        make(chan struct{}),
+	}
+	if pub.batchSize <= 0 || pub.batchSize > kinesisMaxBatchSize {
+		pub.batchSize = kinesisMaxBatchSize
+	}
+	if pub.maxRetries <= 0 {
+		pub.maxRetries = kinesisDefaultMaxRetries
+	}
+	if pub.maxBuffered < pub.batchSize {
+		pub.maxBuffered = kinesisDefaultMaxBufferedBatches * pub.batchSize
+	}
+	linger := cfg.Linger
+	if linger <= 0 {
+		linger = kinesisDefaultLinger
+	}
+	pub.init(linger)
+	return pub
+}
+
+// Initializes a goroutine that flushes the buffer every time the linger time
+// expires. Errors are reported on the next PublishPos call.
+func (pub *kinesisPosPub) init(linger time.Duration) {
+	go func() {
+		ticker := time.NewTicker(linger)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-pub.done:
+				return
+			case <-ticker.C:
+			}
+			if err := pub.Flush(); err != nil {
+				select {
+				case pub.errChan <- err:
+				default:
+				}
+			}
+		}
+	}()
+}
+
+// PublishPos formats a position and appends it to the buffer. If the buffer
+// reaches the batch size, it is sent.
+func (pub *kinesisPosPub) PublishPos(pos gps.Position) error {
+	select {
+	case err := <-pub.errChan:
+		return err
+	default:
+	}
+
+	bs, err := pub.fmtr.Format(pos)
+	if err != nil {
+		return err
+	}
+
+	pub.Lock()
+	pub.buffer = append(pub.buffer, &kinesis.PutRecordsRequestEntry{
+		Data:         bs,
+		PartitionKey: aws.String(pos.GPS.ID()),
+	})
+	full := len(pub.buffer) >= pub.batchSize
+	pub.Unlock()
+
+	if full {
+		return pub.Flush()
+	}
+	return nil
+}
+
+// Close stops the linger timer. Buffered records must be flushed beforehand.
+func (pub *kinesisPosPub) Close() error {
+	pub.closeOnce.Do(func() { close(pub.done) })
+	return nil
+}
+
+// Flush sends all buffered records. Records that still fail once the retries
+// are exhausted are kept in the buffer for the next flush.
+func (pub *kinesisPosPub) Flush() error {
+	pub.flushMu.Lock()
+	defer pub.flushMu.Unlock()
+	for {
+		pub.Lock()
+		n := len(pub.buffer)
+		if n > pub.batchSize {
+			n = pub.batchSize
+		}
+		batch := pub.buffer[:n]
+		pub.buffer = pub.buffer[n:]
+		pub.Unlock()
+		if n == 0 {
+			return nil
+		}
+
+		if failed, err := pub.putRecords(batch); err != nil {
+			if dropped := pub.requeue(failed); dropped > 0 {
+				err = fmt.Errorf("%w; dropped %d records of the full buffer", err, dropped)
+			}
+			return err
+		}
+	}
+}
+
+// Puts failed records back at the start of the buffer, dropping the oldest
+// ones beyond its limit. It returns how many were dropped.
+func (pub *kinesisPosPub) requeue(failed []*kinesis.PutRecordsRequestEntry) int {
+	pub.Lock()
+	defer pub.Unlock()
+	buffer := make([]*kinesis.PutRecordsRequestEntry, 0, len(failed)+len(pub.buffer))
+	pub.buffer = append(append(buffer, failed...), pub.buffer...)
+	dropped := len(pub.buffer) - pub.maxBuffered
+	if dropped <= 0 {
+		return 0
+	}
+	pub.buffer = pub.buffer[dropped:]
+	return dropped
+}
+
+// Puts a batch of records into the stream. Records that fail are retried, with
+// an exponential backoff, until the retries are exhausted. The records that
+// weren't put are returned along with the error.
+func (pub *kinesisPosPub) putRecords(entries []*kinesis.PutRecordsRequestEntry) ([]*kinesis.PutRecordsRequestEntry, error) {
+	for attempt := 0; ; attempt++ {
+		out, err := pub.client.PutRecords(&kinesis.PutRecordsInput{
+			StreamName: aws.String(pub.streamName),
+			Records:    entries,
+		})
+		if err != nil {
+			return entries, fmt.Errorf("Error putting records into Kinesis: %w", err)
+		}
+		if aws.Int64Value(out.FailedRecordCount) == 0 {
+			return nil, nil
+		}
+
+		failed := make([]*kinesis.PutRecordsRequestEntry, 0, aws.Int64Value(out.FailedRecordCount))
+		var lastErr string
+		for i, rec := range out.Records {
+			if rec.ErrorCode != nil {
+				failed = append(failed, entries[i])
+				lastErr = fmt.Sprintf("%s: %s", aws.StringValue(rec.ErrorCode), aws.StringValue(rec.ErrorMessage))
+			}
+		}
+		if attempt >= pub.maxRetries {
+			return failed, fmt.Errorf("Failed to put %d records into Kinesis (%s)", len(failed), lastErr)
+		}
+
+		entries = failed
+		time.Sleep(kinesisRetryBackoff << uint(attempt))
+	}
 }
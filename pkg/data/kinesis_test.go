@@ -0,0 +1,135 @@
+package data
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/gps"
+	"github.com/gpontesss/routesim/pkg/gps/gpstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Fake Kinesis client that records put batches. The next failures records,
+// and records whose data is rejected, are reported as failed.
+type fakeKinesis struct {
+	kinesisiface.KinesisAPI
+	sync.Mutex
+	failures int
+	rejected map[string]bool
+	batches  [][]*kinesis.PutRecordsRequestEntry
+}
+
+func (k *fakeKinesis) PutRecords(in *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+	k.Lock()
+	defer k.Unlock()
+	k.batches = append(k.batches, in.Records)
+
+	out := &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}
+	for _, rec := range in.Records {
+		res := &kinesis.PutRecordsResultEntry{}
+		if k.rejected[string(rec.Data)] {
+			*out.FailedRecordCount++
+			res.ErrorCode = aws.String("InternalFailure")
+		} else if k.failures > 0 {
+			k.failures--
+			*out.FailedRecordCount++
+			res.ErrorCode = aws.String("ProvisionedThroughputExceededException")
+		}
+		out.Records = append(out.Records, res)
+	}
+	return out, nil
+}
+
+var idFormatter = PosFormatterFunc(func(pos gps.Position) ([]byte, error) {
+	return []byte(pos.GPS.ID()), nil
+})
+
+func TestKinesisPublisherBatching(t *testing.T) {
+	client := new(fakeKinesis)
+	pub := kinesisPublisherWithClient(client, KinesisConfig{
+		StreamName: "test",
+		BatchSize:  2,
+		Linger:     time.Hour,
+	}, idFormatter)
+
+	gpz := gpstest.TestGPS("TEST1234", s2.LatLng{}, s2.LatLng{}, s2.LatLng{})
+	for i := 0; i < 3; i++ {
+		require.NoError(t, pub.PublishPos(gpz.CurrentPos()))
+	}
+
+	require.Len(t, client.batches, 1)
+	assert.Len(t, client.batches[0], 2)
+	assert.Equal(t, "TEST1234", aws.StringValue(client.batches[0][0].PartitionKey))
+
+	require.NoError(t, Close(pub))
+	require.Len(t, client.batches, 2)
+	assert.Len(t, client.batches[1], 1)
+}
+
+func TestKinesisPublisherRetries(t *testing.T) {
+	client := &fakeKinesis{failures: 1}
+	pub := kinesisPublisherWithClient(client, KinesisConfig{
+		StreamName: "test",
+		BatchSize:  2,
+		Linger:     time.Hour,
+	}, idFormatter)
+
+	gpz := gpstest.TestGPS("TEST1234", s2.LatLng{}, s2.LatLng{}, s2.LatLng{})
+	require.NoError(t, pub.PublishPos(gpz.CurrentPos()))
+	require.NoError(t, pub.PublishPos(gpz.CurrentPos()))
+
+	require.Len(t, client.batches, 2)
+	assert.Len(t, client.batches[1], 1)
+
+	client.failures = 10
+	require.NoError(t, pub.PublishPos(gpz.CurrentPos()))
+	err := pub.Flush()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Failed to put 1 records")
+}
+
+func TestKinesisPublisherKeepsFailedRecords(t *testing.T) {
+	client := &fakeKinesis{rejected: map[string]bool{"A": true}}
+	pub := kinesisPublisherWithClient(client, KinesisConfig{
+		StreamName:  "test",
+		BatchSize:   2,
+		Linger:      time.Hour,
+		MaxBuffered: 3,
+	}, idFormatter)
+
+	require.NoError(t, pub.PublishPos(gpstest.TestGPS("A", s2.LatLng{}).CurrentPos()))
+	// Every attempt of the first record fails, but the second one is put
+	require.Error(t, pub.PublishPos(gpstest.TestGPS("B", s2.LatLng{}).CurrentPos()))
+	require.Len(t, pub.buffer, 1)
+	assert.Equal(t, "A", string(pub.buffer[0].Data))
+
+	client.rejected = nil
+	require.NoError(t, pub.Flush())
+	assert.Len(t, pub.buffer, 0)
+	var put []string
+	for _, batch := range client.batches {
+		for _, rec := range batch {
+			put = append(put, string(rec.Data))
+		}
+	}
+	assert.Equal(t, []string{"A", "B", "A", "A", "A", "A"}, put)
+
+	// Failed records beyond the limit are dropped
+	client.rejected = map[string]bool{"C": true, "D": true, "E": true, "F": true}
+	for _, id := range []string{"C", "D", "E", "F"} {
+		pub.Lock()
+		pub.buffer = append(pub.buffer, &kinesis.PutRecordsRequestEntry{Data: []byte(id), PartitionKey: aws.String(id)})
+		pub.Unlock()
+	}
+	err := pub.Flush()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "dropped 1 records")
+	require.Len(t, pub.buffer, 3)
+	assert.Equal(t, "D", string(pub.buffer[0].Data))
+}
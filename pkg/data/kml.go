@@ -0,0 +1,125 @@
+package data
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gpontesss/routesim/pkg/gps"
+)
+
+const (
+	kmlHeader = xml.Header +
+		`<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">` + "\n" +
+		"<Document><name>routesim</name>\n"
+	kmlFooter = "</Document>\n</kml>\n"
+)
+
+// Track colors, in KML's aabbggrr notation. Devices take them in turns.
+var kmlColors = []string{
+	"ff1f77b4", "ff0e7fff", "ff2ca02c", "ff2827d6",
+	"ffbd6794", "ff4b568c", "ffc277e3", "ff22bdbc",
+}
+
+// Streams of a KML track's pieces. gx:Track lists every time before every
+// coordinate.
+const (
+	kmlWhenStream = iota
+	kmlCoordStream
+	kmlAnglesStream
+	kmlStreams
+)
+
+type kmlPosPub struct {
+	sync.Mutex
+	spool *trackSpool
+}
+
+// KMLPublisher creates a publisher that writes positions to a KML document,
+// as a gx:Track placemark per device, named after its GPS ID, with its own
+// style and its metadata as extended data. Headings follow the course.
+// Positions are spooled to disk while the simulation runs; the document is
+// only written when the publisher is closed.
+func KMLPublisher(path string) (PosPublisher, error) {
+	spool, err := newTrackSpool(path, kmlStreams)
+	if err != nil {
+		return nil, err
+	}
+	return &kmlPosPub{spool: spool}, nil
+}
+
+// PublishPos spools a position as a point of its device's track
+func (pub *kmlPosPub) PublishPos(pos gps.Position) error {
+	when := fmt.Sprintf("<when>%s</when>\n", pos.At.UTC().Format(time.RFC3339Nano))
+	coord := fmt.Sprintf("<gx:coord>%s %s 0</gx:coord>\n",
+		formatFloat(pos.Lng.Degrees()), formatFloat(pos.Lat.Degrees()))
+	angles := fmt.Sprintf("<gx:angles>%s 0 0</gx:angles>\n", formatFloat(pos.Course))
+
+	pub.Lock()
+	defer pub.Unlock()
+	if pub.spool.done {
+		return ErrPublisherDone
+	}
+	id, metadata := pos.GPS.ID(), pos.GPS.Metadata()
+	for stream, piece := range []string{when, coord, angles} {
+		if err := pub.spool.append(id, metadata, stream, []byte(piece)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes spooled positions to disk
+func (pub *kmlPosPub) Flush() error {
+	pub.Lock()
+	defer pub.Unlock()
+	if pub.spool.done {
+		return nil
+	}
+	return pub.spool.flush()
+}
+
+// Close writes the KML document
+func (pub *kmlPosPub) Close() error {
+	pub.Lock()
+	defer pub.Unlock()
+	i := 0
+	return pub.spool.finish(kmlHeader, kmlFooter, func(w *trackWriter) error {
+		color := kmlColors[i%len(kmlColors)]
+		i++
+		name := xmlEscape(w.track.id)
+		fmt.Fprintf(w, "<Placemark><name>%s</name>\n", name)
+		fmt.Fprintf(w, "<Style><IconStyle><color>%s</color></IconStyle>"+
+			"<LineStyle><color>%s</color><width>4</width></LineStyle></Style>\n", color, color)
+		writeKMLExtendedData(w, w.track.metadata)
+		w.WriteString("<gx:Track><altitudeMode>clampToGround</altitudeMode>\n")
+		for stream := 0; stream < kmlStreams; stream++ {
+			if err := w.copyStream(stream); err != nil {
+				return err
+			}
+		}
+		w.WriteString("</gx:Track></Placemark>\n")
+		return nil
+	})
+}
+
+// Writes metadata as extended data, sorted by key
+func writeKMLExtendedData(w *trackWriter, metadata map[string]interface{}) {
+	if len(metadata) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w.WriteString("<ExtendedData>")
+	for _, key := range keys {
+		fmt.Fprintf(w, `<Data name="%s"><value>%s</value></Data>`,
+			xmlEscape(key), xmlEscape(fmt.Sprint(metadata[key])))
+	}
+	w.WriteString("</ExtendedData>\n")
+}
@@ -0,0 +1,73 @@
+package data
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type kmlDoc struct {
+	Placemarks []struct {
+		Name  string `xml:"name"`
+		Style struct {
+			Color string `xml:"LineStyle>color"`
+		} `xml:"Style"`
+		Data []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:"value"`
+		} `xml:"ExtendedData>Data"`
+		Track struct {
+			Elements []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:"Track"`
+	} `xml:"Document>Placemark"`
+}
+
+func TestKMLPublisher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routesim")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tracks.kml")
+	pub, err := KMLPublisher(path)
+	require.NoError(t, err)
+	publishTracks(t, pub)
+	require.NoError(t, Close(pub))
+	assert.NoFileExists(t, path+".spool")
+
+	bs, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	var doc kmlDoc
+	require.NoError(t, xml.Unmarshal(bs, &doc))
+
+	require.Len(t, doc.Placemarks, 2)
+	bus1, bus2 := doc.Placemarks[0], doc.Placemarks[1]
+	assert.Equal(t, "bus-1", bus1.Name)
+	assert.Equal(t, "bus&2", bus2.Name)
+	assert.NotEqual(t, bus1.Style.Color, bus2.Style.Color)
+	require.Len(t, bus2.Data, 1)
+	assert.Equal(t, "line", bus2.Data[0].Name)
+	assert.Equal(t, "8001", bus2.Data[0].Value)
+
+	var names, values []string
+	for _, el := range bus1.Track.Elements {
+		names = append(names, el.XMLName.Local)
+		values = append(values, el.Value)
+	}
+	assert.Equal(t, []string{
+		"altitudeMode",
+		"when", "when", "when",
+		"coord", "coord", "coord",
+		"angles", "angles", "angles",
+	}, names)
+	assert.Equal(t, "2020-10-01T12:01:00Z", values[2])
+	assert.Equal(t, "-46.59 -23.5 0", values[5])
+	assert.Equal(t, "90 0 0", values[7])
+}
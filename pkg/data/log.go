@@ -0,0 +1,179 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gpontesss/routesim/pkg/gps"
+)
+
+// LogLevel is the severity of a log line
+type LogLevel int
+
+const (
+	// DebugLevel logs positions along with their devices' metadata
+	DebugLevel LogLevel = iota
+	// InfoLevel logs positions
+	InfoLevel
+	// WarnLevel logs only warnings and errors
+	WarnLevel
+	// ErrorLevel logs only errors
+	ErrorLevel
+)
+
+// ParseLogLevel parses a log level name
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return DebugLevel, nil
+	case "", "info":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	default:
+		return 0, fmt.Errorf("Unknown log level '%s'", s)
+	}
+}
+
+// String returns the log level name
+func (l LogLevel) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// LogConfig describes how positions are logged
+type LogConfig struct {
+	// Minimum level of the lines to be written. Positions are logged at info
+	// level; in debug level, devices' metadata is logged as well.
+	Level LogLevel
+	// Writes a JSON object per line, instead of a human-readable text
+	Structured bool
+	// Optional formatter. When set, its output is logged along with the
+	// position.
+	Formatter PosFormatter
+}
+
+type logPosPub struct {
+	sync.Mutex
+	wtr io.Writer
+	cfg LogConfig
+}
+
+// LogPublisher creates a publisher that writes a line for each position to a
+// writer, e.g. os.Stdout.
+func LogPublisher(wtr io.Writer, cfg LogConfig) PosPublisher {
+	return &logPosPub{wtr: wtr, cfg: cfg}
+}
+
+// Structured log line
+type logLine struct {
+	Time     time.Time              `json:"time"`
+	Level    string                 `json:"level"`
+	ID       string                 `json:"id"`
+	Lat      float64                `json:"lat"`
+	Lng      float64                `json:"lng"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	Data     json.RawMessage        `json:"data,omitempty"`
+}
+
+// PublishPos writes a log line for a position, if the level allows it
+func (pub *logPosPub) PublishPos(pos gps.Position) error {
+	if pub.cfg.Level > InfoLevel {
+		return nil
+	}
+
+	var (
+		payload []byte
+		err     error
+	)
+	if pub.cfg.Formatter != nil {
+		if payload, err = pub.cfg.Formatter.Format(pos); err != nil {
+			return err
+		}
+	}
+
+	var line []byte
+	if pub.cfg.Structured {
+		line, err = pub.structuredLine(pos, payload)
+		if err != nil {
+			return err
+		}
+	} else {
+		line = pub.textLine(pos, payload)
+	}
+
+	pub.Lock()
+	defer pub.Unlock()
+	_, err = pub.wtr.Write(line)
+	return err
+}
+
+// Formats a position as a human-readable line
+func (pub *logPosPub) textLine(pos gps.Position, payload []byte) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %-5s %s %.6f,%.6f",
+		pos.At.Format(time.RFC3339Nano),
+		strings.ToUpper(InfoLevel.String()),
+		pos.GPS.ID(),
+		pos.Lat.Degrees(),
+		pos.Lng.Degrees(),
+	)
+	if pub.cfg.Level <= DebugLevel && len(pos.GPS.Metadata()) > 0 {
+		fmt.Fprintf(&buf, " %v", pos.GPS.Metadata())
+	}
+	if len(payload) > 0 {
+		buf.WriteByte(' ')
+		buf.Write(bytes.TrimSpace(payload))
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// Formats a position as a JSON object line. Payloads that are not valid JSON
+// are embedded as strings.
+func (pub *logPosPub) structuredLine(pos gps.Position, payload []byte) ([]byte, error) {
+	line := logLine{
+		Time:  pos.At,
+		Level: InfoLevel.String(),
+		ID:    pos.GPS.ID(),
+		Lat:   pos.Lat.Degrees(),
+		Lng:   pos.Lng.Degrees(),
+	}
+	if pub.cfg.Level <= DebugLevel {
+		line.Metadata = pos.GPS.Metadata()
+	}
+	if len(payload) > 0 {
+		if json.Valid(payload) {
+			line.Data = payload
+		} else {
+			bs, err := json.Marshal(string(payload))
+			if err != nil {
+				return nil, err
+			}
+			line.Data = bs
+		}
+	}
+
+	bs, err := json.Marshal(line)
+	if err != nil {
+		return nil, err
+	}
+	return append(bs, '\n'), nil
+}
@@ -0,0 +1,49 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/gps/gpstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogPublisherText(t *testing.T) {
+	var buf bytes.Buffer
+	pub := LogPublisher(&buf, LogConfig{Level: InfoLevel, Formatter: idFormatter})
+
+	gpz := gpstest.TestGPS("TEST1234", s2.LatLngFromDegrees(-23.5, -46.6))
+	require.NoError(t, pub.PublishPos(gpz.CurrentPos()))
+
+	assert.Contains(t, buf.String(), "INFO  TEST1234 -23.500000,-46.600000 TEST1234\n")
+}
+
+func TestLogPublisherStructured(t *testing.T) {
+	var buf bytes.Buffer
+	pub := LogPublisher(&buf, LogConfig{
+		Level:      DebugLevel,
+		Structured: true,
+		Formatter:  GeoJSONFormatter,
+	})
+
+	gpz := gpstest.TestGPS("TEST1234", s2.LatLngFromDegrees(-23.5, -46.6))
+	require.NoError(t, pub.PublishPos(gpz.CurrentPos()))
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "info", line["level"])
+	assert.Equal(t, "TEST1234", line["id"])
+	assert.Equal(t, "Feature", line["data"].(map[string]interface{})["type"])
+}
+
+func TestLogPublisherLevel(t *testing.T) {
+	var buf bytes.Buffer
+	pub := LogPublisher(&buf, LogConfig{Level: WarnLevel})
+
+	gpz := gpstest.TestGPS("TEST1234", s2.LatLngFromDegrees(0, 0))
+	require.NoError(t, pub.PublishPos(gpz.CurrentPos()))
+	assert.Empty(t, buf.String())
+}
@@ -0,0 +1,179 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"text/template"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gpontesss/routesim/pkg/gps"
+)
+
+const (
+	// Default time to wait for the broker to accept a connection
+	mqttDefaultConnectTimeout = 10 * time.Second
+	// Default time to wait for the broker to acknowledge a message
+	mqttDefaultPublishTimeout = 10 * time.Second
+	// Time, in milliseconds, given to in-flight messages before disconnecting
+	mqttQuiesce = 250
+)
+
+// MQTTConfig describes how to connect and publish to an MQTT broker
+type MQTTConfig struct {
+	// Broker URL, e.g. "tcp://localhost:1883" or "ssl://broker:8883"
+	BrokerURL string
+	// Client ID. If empty, the broker assigns one.
+	ClientID string
+	Username string
+	Password string
+	// Topic template, executed on each position. Besides position fields,
+	// it may refer to the GPS's metadata, e.g.
+	// "fleet/{{.Metadata.vehicle}}/{{.GPS.ID}}/position".
+	Topic string
+	// Quality of service: 0 (at most once), 1 (at least once) or 2 (exactly
+	// once)
+	QoS byte
+	// Whether the broker keeps the last message of each topic for new
+	// subscribers
+	Retain bool
+	// TLS options. Required for ssl:// brokers that need custom certificates.
+	TLS *TLSConfig
+	// Maximum time to wait for the broker to accept the connection
+	ConnectTimeout time.Duration
+	// Maximum time to wait for the broker to acknowledge a message. Only
+	// applies to QoS 1 and 2.
+	PublishTimeout time.Duration
+}
+
+// Builds the paho client options
+func (cfg MQTTConfig) clientOptions() (*mqtt.ClientOptions, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.BrokerURL).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetConnectTimeout(cfg.ConnectTimeout).
+		SetAutoReconnect(true)
+	if cfg.TLS != nil {
+		tlscfg, err := cfg.TLS.build()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlscfg)
+	}
+	return opts, nil
+}
+
+type mqttPosPub struct {
+	client  mqtt.Client
+	topic   *template.Template
+	fmtr    PosFormatter
+	qos     byte
+	retain  bool
+	timeout time.Duration
+}
+
+// MQTTPublisher creates a publisher that sends formatted positions to an MQTT
+// broker, each to the topic its template renders. It connects to the broker
+// before returning, and reconnects if the connection is lost.
+func MQTTPublisher(cfg MQTTConfig, fmtr PosFormatter) (PosPublisher, error) {
+	if cfg.BrokerURL == "" {
+		return nil, errors.New("MQTT broker URL must be set")
+	}
+	if cfg.QoS > 2 {
+		return nil, fmt.Errorf("Invalid MQTT QoS %d", cfg.QoS)
+	}
+	topic, err := parsePosTemplate("topic", cfg.Topic)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ConnectTimeout <= 0 {
+		cfg.ConnectTimeout = mqttDefaultConnectTimeout
+	}
+	if cfg.PublishTimeout <= 0 {
+		cfg.PublishTimeout = mqttDefaultPublishTimeout
+	}
+	opts, err := cfg.clientOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(cfg.ConnectTimeout) {
+		client.Disconnect(0)
+		return nil, fmt.Errorf("Timed out connecting to MQTT broker %s", cfg.BrokerURL)
+	}
+	if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("Error connecting to MQTT broker %s: %w", cfg.BrokerURL, err)
+	}
+
+	return &mqttPosPub{
+		client:  client,
+		topic:   topic,
+		fmtr:    fmtr,
+		qos:     cfg.QoS,
+		retain:  cfg.Retain,
+		timeout: cfg.PublishTimeout,
+	}, nil
+}
+
+// PublishPos formats a position and publishes it to its topic. With QoS 1 or
+// 2, it waits for the broker's acknowledgement.
+func (pub *mqttPosPub) PublishPos(pos gps.Position) error {
+	topic, err := executePosTemplate(pub.topic, pos)
+	if err != nil {
+		return err
+	}
+	bs, err := pub.fmtr.Format(pos)
+	if err != nil {
+		return err
+	}
+
+	token := pub.client.Publish(topic, pub.qos, pub.retain, bs)
+	if !token.WaitTimeout(pub.timeout) {
+		return fmt.Errorf("Timed out publishing to MQTT topic %s", topic)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("Error publishing to MQTT topic %s: %w", topic, err)
+	}
+	return nil
+}
+
+// Close disconnects from the broker, after giving in-flight messages some
+// time to complete
+func (pub *mqttPosPub) Close() error {
+	pub.client.Disconnect(mqttQuiesce)
+	return nil
+}
+
+// Data a position template is executed on. Besides the position's fields,
+// it holds its GPS's metadata.
+type posTemplateData struct {
+	gps.Position
+	Metadata map[string]interface{}
+}
+
+// Parses a template that renders text out of positions. Referring to missing
+// metadata is an error.
+func parsePosTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, fmt.Errorf("Template %s must be set", name)
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing %s template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+func executePosTemplate(tmpl *template.Template, pos gps.Position) (string, error) {
+	var buf bytes.Buffer
+	data := posTemplateData{Position: pos, Metadata: pos.GPS.Metadata()}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("Error executing %s template: %w", tmpl.Name(), err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,157 @@
+package data
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Minimal MQTT broker that records the messages published to it
+type testBroker struct {
+	sync.Mutex
+	lst      net.Listener
+	password string
+	messages []*packets.PublishPacket
+}
+
+func startTestBroker(t *testing.T, password string) *testBroker {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	broker := &testBroker{lst: lst, password: password}
+	t.Cleanup(func() { lst.Close() })
+	go func() {
+		for {
+			conn, err := lst.Accept()
+			if err != nil {
+				return
+			}
+			go broker.serve(conn)
+		}
+	}()
+	return broker
+}
+
+func (b *testBroker) url() string { return "tcp://" + b.lst.Addr().String() }
+
+func (b *testBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		cp, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		var reply packets.ControlPacket
+		switch p := cp.(type) {
+		case *packets.ConnectPacket:
+			connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			if string(p.Password) != b.password {
+				connack.ReturnCode = packets.ErrRefusedNotAuthorised
+			}
+			reply = connack
+		case *packets.PublishPacket:
+			b.Lock()
+			b.messages = append(b.messages, p)
+			b.Unlock()
+			switch p.Qos {
+			case 1:
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = p.MessageID
+				reply = puback
+			case 2:
+				pubrec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+				pubrec.MessageID = p.MessageID
+				reply = pubrec
+			}
+		case *packets.PubrelPacket:
+			pubcomp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+			pubcomp.MessageID = p.MessageID
+			reply = pubcomp
+		case *packets.PingreqPacket:
+			reply = packets.NewControlPacket(packets.Pingresp)
+		case *packets.DisconnectPacket:
+			return
+		}
+		if reply != nil {
+			if reply.Write(conn) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (b *testBroker) published() []*packets.PublishPacket {
+	b.Lock()
+	defer b.Unlock()
+	return append([]*packets.PublishPacket(nil), b.messages...)
+}
+
+func TestMQTTPublisher(t *testing.T) {
+	broker := startTestBroker(t, "secret")
+	pub, err := MQTTPublisher(MQTTConfig{
+		BrokerURL: broker.url(),
+		ClientID:  "routesim",
+		Username:  "fleet",
+		Password:  "secret",
+		Topic:     "fleet/{{.Metadata.vehicle}}/{{.GPS.ID}}/position",
+		QoS:       1,
+		Retain:    true,
+	}, GeoJSONFormatter)
+	require.NoError(t, err)
+
+	require.NoError(t, pub.PublishPos(testPos("bus-1", map[string]interface{}{"vehicle": "bus"})))
+	require.NoError(t, pub.PublishPos(testPos("van-1", map[string]interface{}{"vehicle": "van"})))
+	err = pub.PublishPos(testPos("car-1", nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "topic template")
+	require.NoError(t, Close(pub))
+
+	msgs := broker.published()
+	require.Len(t, msgs, 2)
+	assert.Equal(t, "fleet/bus/bus-1/position", msgs[0].TopicName)
+	assert.Equal(t, "fleet/van/van-1/position", msgs[1].TopicName)
+	assert.Equal(t, byte(1), msgs[0].Qos)
+	assert.True(t, msgs[0].Retain)
+	assert.Contains(t, string(msgs[0].Payload), `"Feature"`)
+}
+
+func TestMQTTPublisherExactlyOnce(t *testing.T) {
+	broker := startTestBroker(t, "")
+	pub, err := MQTTPublisher(MQTTConfig{
+		BrokerURL:      broker.url(),
+		Topic:          "fleet/{{.GPS.ID}}",
+		QoS:            2,
+		PublishTimeout: time.Second,
+	}, GeoJSONFormatter)
+	require.NoError(t, err)
+	defer Close(pub)
+
+	require.NoError(t, pub.PublishPos(testPos("bus-1", nil)))
+	require.Len(t, broker.published(), 1)
+	assert.Equal(t, "fleet/bus-1", broker.published()[0].TopicName)
+}
+
+func TestMQTTPublisherErrors(t *testing.T) {
+	broker := startTestBroker(t, "secret")
+	cases := map[string]struct {
+		cfg MQTTConfig
+		err string
+	}{
+		"NoURL":        {MQTTConfig{Topic: "fleet"}, "broker URL"},
+		"NoTopic":      {MQTTConfig{BrokerURL: broker.url()}, "must be set"},
+		"BadTopic":     {MQTTConfig{BrokerURL: broker.url(), Topic: "{{.GPS.ID"}, "parsing topic"},
+		"BadQoS":       {MQTTConfig{BrokerURL: broker.url(), Topic: "fleet", QoS: 3}, "QoS"},
+		"Unauthorized": {MQTTConfig{BrokerURL: broker.url(), Topic: "fleet", Password: "guess"}, "connecting"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := MQTTPublisher(c.cfg, GeoJSONFormatter)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), c.err)
+		})
+	}
+}
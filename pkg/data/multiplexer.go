@@ -0,0 +1,189 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gpontesss/routesim/pkg/gps"
+)
+
+// FailurePolicy tells what a multiplexer does when one of its publishers fails
+type FailurePolicy int
+
+const (
+	// AbortOnFailure returns the publisher's error, stopping the simulation
+	AbortOnFailure FailurePolicy = iota
+	// SkipOnFailure drops the position for the failed publisher only
+	SkipOnFailure
+	// DisableOnFailure stops publishing to the failed publisher
+	DisableOnFailure
+)
+
+// ParseFailurePolicy parses a failure policy name
+func ParseFailurePolicy(s string) (FailurePolicy, error) {
+	switch strings.ToLower(s) {
+	case "", "abort":
+		return AbortOnFailure, nil
+	case "skip":
+		return SkipOnFailure, nil
+	case "disable":
+		return DisableOnFailure, nil
+	default:
+		return 0, fmt.Errorf("Unknown failure policy '%s'", s)
+	}
+}
+
+// String returns the failure policy name
+func (p FailurePolicy) String() string {
+	switch p {
+	case AbortOnFailure:
+		return "abort"
+	case SkipOnFailure:
+		return "skip"
+	case DisableOnFailure:
+		return "disable"
+	default:
+		return fmt.Sprintf("policy(%d)", int(p))
+	}
+}
+
+// PosFilter selects positions by their GPS. Every criterion that is set must
+// match.
+type PosFilter struct {
+	// GPS IDs that match
+	IDs []string
+	// Pattern that GPS IDs must match
+	IDPattern *regexp.Regexp
+	// Metadata values that GPSs must have. Values are compared by their
+	// textual representation, so 1 matches 1.0.
+	Metadata map[string]interface{}
+}
+
+// Match tells if a position is selected by the filter
+func (f PosFilter) Match(pos gps.Position) bool {
+	id := pos.GPS.ID()
+	if len(f.IDs) > 0 {
+		found := false
+		for _, fid := range f.IDs {
+			if fid == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.IDPattern != nil && !f.IDPattern.MatchString(id) {
+		return false
+	}
+	if len(f.Metadata) > 0 {
+		metadata := pos.GPS.Metadata()
+		for key, val := range f.Metadata {
+			mval, ok := metadata[key]
+			if !ok || fmt.Sprint(mval) != fmt.Sprint(val) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Route is a publisher of a multiplexer, along with the positions it receives
+type Route struct {
+	Publisher PosPublisher
+	// Selects the positions published. If nil, every position is.
+	Filter *PosFilter
+	// What to do when the publisher fails
+	OnFailure FailurePolicy
+}
+
+// Writer for failures that don't stop a multiplexer
+var muxErrOutput io.Writer = os.Stderr
+
+type muxPosPub struct {
+	routes   []Route
+	disabled []bool
+	enabled  int
+}
+
+// Multiplexer creates a publisher that publishes every position to each of
+// the routes that select it, in order. A failing publisher is handled by its
+// route's failure policy. A publisher that is done is no longer published to,
+// and once all of them are, ErrPublisherDone is returned. If every publisher
+// gets disabled, the multiplexer fails. Closing it closes every publisher.
+func Multiplexer(routes ...Route) PosPublisher {
+	return &muxPosPub{
+		routes:   routes,
+		disabled: make([]bool, len(routes)),
+		enabled:  len(routes),
+	}
+}
+
+// PublishPos publishes a position to every route that selects it
+func (pub *muxPosPub) PublishPos(pos gps.Position) error {
+	for i, route := range pub.routes {
+		if pub.disabled[i] || (route.Filter != nil && !route.Filter.Match(pos)) {
+			continue
+		}
+		err := route.Publisher.PublishPos(pos)
+		if err == nil {
+			continue
+		}
+
+		switch {
+		case errors.Is(err, ErrPublisherDone):
+			pub.disable(i)
+			if pub.enabled == 0 {
+				return ErrPublisherDone
+			}
+		case route.OnFailure == SkipOnFailure:
+			fmt.Fprintf(muxErrOutput, "Skipped position for publisher %d: %v\n", i, err)
+		case route.OnFailure == DisableOnFailure:
+			fmt.Fprintf(muxErrOutput, "Disabled publisher %d: %v\n", i, err)
+			pub.disable(i)
+			if pub.enabled == 0 {
+				return errors.New("Every publisher was disabled")
+			}
+		default:
+			return fmt.Errorf("Error publishing to publisher %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Stops publishing to a route
+func (pub *muxPosPub) disable(i int) {
+	pub.disabled[i] = true
+	pub.enabled--
+}
+
+// Flush flushes every publisher that buffers data
+func (pub *muxPosPub) Flush() error {
+	var err error
+	for i, route := range pub.routes {
+		if f, ok := route.Publisher.(Flusher); ok && !pub.disabled[i] {
+			if ferr := f.Flush(); ferr != nil && err == nil {
+				err = fmt.Errorf("Error flushing publisher %d: %w", i, ferr)
+			}
+		}
+	}
+	return err
+}
+
+// Close closes every publisher, even if disabled
+func (pub *muxPosPub) Close() error {
+	var err error
+	for i, route := range pub.routes {
+		if c, ok := route.Publisher.(io.Closer); ok {
+			if cerr := c.Close(); cerr != nil && err == nil {
+				err = fmt.Errorf("Error closing publisher %d: %w", i, cerr)
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+package data
+
+import (
+	"errors"
+	"io/ioutil"
+	"regexp"
+	"testing"
+
+	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/gps"
+	"github.com/gpontesss/routesim/pkg/gps/gpstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// GPS with custom metadata
+type metadataGPS struct {
+	gps.GPS
+	metadata map[string]interface{}
+}
+
+func (g metadataGPS) Metadata() map[string]interface{} { return g.metadata }
+
+// Position of a GPS with an ID and metadata
+func testPos(id string, metadata map[string]interface{}) gps.Position {
+	return gps.Position{GPS: metadataGPS{gpstest.TestGPS(id), metadata}, LatLng: s2.LatLng{}}
+}
+
+// Publisher that records published IDs and fails at chosen calls
+type recordingPub struct {
+	ids    []string
+	failAt map[int]bool
+	closed bool
+}
+
+func (pub *recordingPub) PublishPos(pos gps.Position) error {
+	call := len(pub.ids)
+	pub.ids = append(pub.ids, pos.GPS.ID())
+	if pub.failAt[call] {
+		return errors.New("Failed to publish position")
+	}
+	return nil
+}
+
+func (pub *recordingPub) Close() error {
+	pub.closed = true
+	return nil
+}
+
+func TestPosFilter(t *testing.T) {
+	pos := testPos("bus-1", map[string]interface{}{"line": 8000, "kind": "bus"})
+	cases := map[string]struct {
+		filter PosFilter
+		match  bool
+	}{
+		"Empty":            {PosFilter{}, true},
+		"ID":               {PosFilter{IDs: []string{"bus-2", "bus-1"}}, true},
+		"OtherID":          {PosFilter{IDs: []string{"bus-2"}}, false},
+		"IDPattern":        {PosFilter{IDPattern: regexp.MustCompile(`^bus-`)}, true},
+		"OtherIDPattern":   {PosFilter{IDPattern: regexp.MustCompile(`^truck-`)}, false},
+		"Metadata":         {PosFilter{Metadata: map[string]interface{}{"line": 8000.0, "kind": "bus"}}, true},
+		"OtherMetadata":    {PosFilter{Metadata: map[string]interface{}{"line": 8001}}, false},
+		"MissingMetadata":  {PosFilter{Metadata: map[string]interface{}{"color": "red"}}, false},
+		"EveryCriterion":   {PosFilter{IDs: []string{"bus-1"}, Metadata: map[string]interface{}{"kind": "bus"}}, true},
+		"SomeCriterionOff": {PosFilter{IDs: []string{"bus-1"}, Metadata: map[string]interface{}{"kind": "car"}}, false},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, c.match, c.filter.Match(pos))
+		})
+	}
+}
+
+func TestMultiplexerFilters(t *testing.T) {
+	all, buses := new(recordingPub), new(recordingPub)
+	pub := Multiplexer(
+		Route{Publisher: all},
+		Route{Publisher: buses, Filter: &PosFilter{IDPattern: regexp.MustCompile(`^bus-`)}},
+	)
+	for _, id := range []string{"bus-1", "truck-1", "bus-2"} {
+		require.NoError(t, pub.PublishPos(testPos(id, nil)))
+	}
+	assert.Equal(t, []string{"bus-1", "truck-1", "bus-2"}, all.ids)
+	assert.Equal(t, []string{"bus-1", "bus-2"}, buses.ids)
+
+	require.NoError(t, Close(pub))
+	assert.True(t, all.closed)
+	assert.True(t, buses.closed)
+}
+
+func TestMultiplexerFailurePolicies(t *testing.T) {
+	muxErrOutput = ioutil.Discard
+	fails := map[int]bool{1: true}
+
+	t.Run("Abort", func(t *testing.T) {
+		failing, other := &recordingPub{failAt: fails}, new(recordingPub)
+		pub := Multiplexer(Route{Publisher: failing}, Route{Publisher: other})
+		require.NoError(t, pub.PublishPos(testPos("bus-1", nil)))
+		err := pub.PublishPos(testPos("bus-2", nil))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "publisher 0")
+		assert.Equal(t, []string{"bus-1"}, other.ids)
+	})
+
+	t.Run("Skip", func(t *testing.T) {
+		failing, other := &recordingPub{failAt: fails}, new(recordingPub)
+		pub := Multiplexer(Route{Publisher: failing, OnFailure: SkipOnFailure}, Route{Publisher: other})
+		for _, id := range []string{"bus-1", "bus-2", "bus-3"} {
+			require.NoError(t, pub.PublishPos(testPos(id, nil)))
+		}
+		assert.Equal(t, []string{"bus-1", "bus-2", "bus-3"}, failing.ids)
+		assert.Equal(t, []string{"bus-1", "bus-2", "bus-3"}, other.ids)
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		failing, other := &recordingPub{failAt: fails}, new(recordingPub)
+		pub := Multiplexer(Route{Publisher: failing, OnFailure: DisableOnFailure}, Route{Publisher: other})
+		for _, id := range []string{"bus-1", "bus-2", "bus-3"} {
+			require.NoError(t, pub.PublishPos(testPos(id, nil)))
+		}
+		assert.Equal(t, []string{"bus-1", "bus-2"}, failing.ids)
+		assert.Equal(t, []string{"bus-1", "bus-2", "bus-3"}, other.ids)
+	})
+
+	t.Run("DisableAll", func(t *testing.T) {
+		pub := Multiplexer(Route{Publisher: &recordingPub{failAt: fails}, OnFailure: DisableOnFailure})
+		require.NoError(t, pub.PublishPos(testPos("bus-1", nil)))
+		assert.Error(t, pub.PublishPos(testPos("bus-2", nil)))
+	})
+}
+
+func TestMultiplexerDone(t *testing.T) {
+	done := PosPublisherFunc(func(gps.Position) error { return ErrPublisherDone })
+	other := new(recordingPub)
+	pub := Multiplexer(Route{Publisher: done}, Route{Publisher: other})
+	require.NoError(t, pub.PublishPos(testPos("bus-1", nil)))
+	require.NoError(t, pub.PublishPos(testPos("bus-2", nil)))
+	assert.Equal(t, []string{"bus-1", "bus-2"}, other.ids)
+
+	pub = Multiplexer(Route{Publisher: done})
+	assert.True(t, errors.Is(pub.PublishPos(testPos("bus-1", nil)), ErrPublisherDone))
+}
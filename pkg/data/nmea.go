@@ -0,0 +1,104 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/gpontesss/routesim/pkg/gps"
+)
+
+const (
+	// Knots in a meter per second
+	knotsPerMPS = 3600 / 1852.0
+	// Kilometers per hour in a meter per second
+	kmhPerMPS = 3.6
+)
+
+var (
+	// NMEAFormatter formats a position into NMEA 0183 $GPGGA and $GPRMC
+	// sentences, each ended by CRLF
+	NMEAFormatter = WithContentType(nmeaFormatter(false), "text/plain")
+	// NMEAVTGFormatter formats a position like NMEAFormatter, followed by a
+	// $GPVTG sentence
+	NMEAVTGFormatter = WithContentType(nmeaFormatter(true), "text/plain")
+)
+
+func nmeaFormatter(vtg bool) PosFormatter {
+	return PosFormatterFunc(func(pos gps.Position) ([]byte, error) {
+		var buf bytes.Buffer
+		buf.WriteString(nmeaSentence(ggaFields(pos)))
+		buf.WriteString(nmeaSentence(rmcFields(pos)))
+		if vtg {
+			buf.WriteString(nmeaSentence(vtgFields(pos)))
+		}
+		return buf.Bytes(), nil
+	})
+}
+
+// Fix data. The fix is always a GPS fix with 8 satellites in view and an HDOP
+// of 1, at sea level.
+func ggaFields(pos gps.Position) string {
+	lat, ns := nmeaLat(pos.Lat.Degrees())
+	lng, ew := nmeaLng(pos.Lng.Degrees())
+	return fmt.Sprintf("GPGGA,%s,%s,%s,%s,%s,1,08,1.0,0.0,M,0.0,M,,",
+		pos.At.UTC().Format("150405.00"), lat, ns, lng, ew)
+}
+
+// Recommended minimum data
+func rmcFields(pos gps.Position) string {
+	lat, ns := nmeaLat(pos.Lat.Degrees())
+	lng, ew := nmeaLng(pos.Lng.Degrees())
+	at := pos.At.UTC()
+	return fmt.Sprintf("GPRMC,%s,A,%s,%s,%s,%s,%.1f,%.1f,%s,,,A",
+		at.Format("150405.00"), lat, ns, lng, ew,
+		pos.Speed*knotsPerMPS, pos.Course, at.Format("020106"))
+}
+
+// Course and speed over ground
+func vtgFields(pos gps.Position) string {
+	return fmt.Sprintf("GPVTG,%.1f,T,,M,%.1f,N,%.1f,K,A",
+		pos.Course, pos.Speed*knotsPerMPS, pos.Speed*kmhPerMPS)
+}
+
+// Wraps sentence fields with the start delimiter, checksum and line end
+func nmeaSentence(fields string) string {
+	return fmt.Sprintf("$%s*%02X\r\n", fields, nmeaChecksum(fields))
+}
+
+// XOR of every byte between the start delimiter and the checksum delimiter
+func nmeaChecksum(fields string) byte {
+	var sum byte
+	for i := 0; i < len(fields); i++ {
+		sum ^= fields[i]
+	}
+	return sum
+}
+
+// Formats a latitude as ddmm.mmmm and its hemisphere
+func nmeaLat(deg float64) (string, string) {
+	hemi := "N"
+	if deg < 0 {
+		hemi = "S"
+	}
+	d, m := degMinutes(deg)
+	return fmt.Sprintf("%02d%07.4f", d, m), hemi
+}
+
+// Formats a longitude as dddmm.mmmm and its hemisphere
+func nmeaLng(deg float64) (string, string) {
+	hemi := "E"
+	if deg < 0 {
+		hemi = "W"
+	}
+	d, m := degMinutes(deg)
+	return fmt.Sprintf("%03d%07.4f", d, m), hemi
+}
+
+// Splits an absolute angle into degrees and minutes, rounded so that minutes
+// never read 60
+func degMinutes(deg float64) (int, float64) {
+	minutes := math.Round(math.Abs(deg)*60*1e4) / 1e4
+	d := math.Floor(minutes / 60)
+	return int(d), minutes - d*60
+}
@@ -0,0 +1,57 @@
+package data
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/gps"
+	"github.com/gpontesss/routesim/pkg/gps/gpstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNMEAChecksum(t *testing.T) {
+	// Classic example of a GGA sentence
+	assert.Equal(t,
+		"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47\r\n",
+		nmeaSentence("GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,"))
+}
+
+func TestNMEAFormatter(t *testing.T) {
+	pos := gps.Position{
+		LatLng: s2.LatLngFromDegrees(-23.5613, -46.6565),
+		At:     time.Date(2020, 10, 1, 12, 35, 19, 500000000, time.UTC),
+		GPS:    gpstest.TestGPS("TEST1234"),
+		Speed:  10,
+		Course: 84.4,
+	}
+
+	bs, err := NMEAVTGFormatter.Format(pos)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(bs), "\r\n"), "\r\n")
+	require.Len(t, lines, 3)
+
+	for _, line := range lines {
+		star := strings.LastIndex(line, "*")
+		require.True(t, star > 0, line)
+		assert.Equal(t, nmeaSentence(line[1:star]), line+"\r\n")
+	}
+	assert.True(t, strings.HasPrefix(lines[0], "$GPGGA,123519.50,2333.6780,S,04639.3900,W,1,08,"), lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "$GPRMC,123519.50,A,2333.6780,S,04639.3900,W,19.4,84.4,011020,,,A*"), lines[1])
+	assert.True(t, strings.HasPrefix(lines[2], "$GPVTG,84.4,T,,M,19.4,N,36.0,K,A*"), lines[2])
+
+	bs, err = NMEAFormatter.Format(pos)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(bs), "\r\n"))
+}
+
+func TestNMEACoordinates(t *testing.T) {
+	lat, hemi := nmeaLat(0.99999999)
+	assert.Equal(t, "0100.0000", lat)
+	assert.Equal(t, "N", hemi)
+	lng, hemi := nmeaLng(-179.5)
+	assert.Equal(t, "17930.0000", lng)
+	assert.Equal(t, "W", hemi)
+}
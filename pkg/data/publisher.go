@@ -1,6 +1,11 @@
 package data
 
-import "github.com/gpontesss/routesim/pkg/gps"
+import (
+	"errors"
+	"io"
+
+	"github.com/gpontesss/routesim/pkg/gps"
+)
 
 // Publisher publishes data to a resource
 type Publisher interface {
@@ -12,6 +17,33 @@ type PosPublisher interface {
 	PublishPos(gps.Position) error
 }
 
+// ErrPublisherDone is returned, possibly wrapped, by publishers that won't
+// publish anymore because their work is complete, e.g. a file publisher that
+// reached its desired count. It signals a normal end, not a failure.
+var ErrPublisherDone = errors.New("Publisher is done")
+
+// Flusher is implemented by publishers that buffer data. Flush sends all
+// buffered data to the resource.
+type Flusher interface {
+	Flush() error
+}
+
+// Close flushes a publisher and releases its resources, if it implements
+// Flusher and io.Closer, respectively. Publishers must not be used after
+// being closed.
+func Close(pub interface{}) error {
+	var err error
+	if f, ok := pub.(Flusher); ok {
+		err = f.Flush()
+	}
+	if c, ok := pub.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
 // PosPublisherFunc is a helper for turning functions into PosPublishers
 type PosPublisherFunc func(gps.Position) error
 
@@ -20,14 +52,28 @@ func (f PosPublisherFunc) PublishPos(pos gps.Position) error {
 	return f(pos)
 }
 
+type fmtrPosPub struct {
+	pub  Publisher
+	fmtr PosFormatter
+}
+
 // PosFormatterPublisher returns a PosPublisher data applies a Formatter to a
-// position and publishes the data to a Publisher
+// position and publishes the data to a Publisher. Closing it closes the
+// Publisher.
 func PosFormatterPublisher(pub Publisher, fmtr PosFormatter) PosPublisher {
-	return PosPublisherFunc(func(pos gps.Position) error {
-		bs, err := fmtr.Format(pos)
-		if err != nil {
-			return err
-		}
-		return pub.Publish(bs)
-	})
+	return &fmtrPosPub{pub: pub, fmtr: fmtr}
+}
+
+// PublishPos formats a position and publishes it
+func (p *fmtrPosPub) PublishPos(pos gps.Position) error {
+	bs, err := p.fmtr.Format(pos)
+	if err != nil {
+		return err
+	}
+	return p.pub.Publish(bs)
+}
+
+// Close flushes and closes the underlying Publisher
+func (p *fmtrPosPub) Close() error {
+	return Close(p.pub)
 }
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gpontesss/routesim/pkg/gps"
+)
+
+const (
+	// Default wait time before the first retry
+	defaultRetryBackoff = 100 * time.Millisecond
+	// Default maximum wait time between retries
+	defaultRetryMaxBackoff = 10 * time.Second
+)
+
+// RetryConfig describes how publishing a position is retried
+type RetryConfig struct {
+	// Number of times a failed position is retried
+	MaxRetries int
+	// Wait time before the first retry. It doubles on each attempt.
+	Backoff time.Duration
+	// Maximum wait time between retries
+	MaxBackoff time.Duration
+	// Publisher that receives positions whose retries are exhausted. If set,
+	// such positions aren't reported as failures.
+	DeadLetter PosPublisher
+}
+
+type retryPosPub struct {
+	pub   PosPublisher
+	cfg   RetryConfig
+	sleep func(time.Duration)
+}
+
+// RetryPublisher creates a publisher that retries publishing failed positions
+// to pub, with an exponential backoff. Once retries are exhausted, positions
+// are sent to the dead letter publisher, if any, or the last error is
+// returned. ErrPublisherDone isn't retried. Closing it closes both publishers.
+func RetryPublisher(pub PosPublisher, cfg RetryConfig) PosPublisher {
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaultRetryBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultRetryMaxBackoff
+	}
+	return &retryPosPub{pub: pub, cfg: cfg, sleep: time.Sleep}
+}
+
+// PublishPos publishes a position, retrying if it fails
+func (p *retryPosPub) PublishPos(pos gps.Position) error {
+	backoff := p.cfg.Backoff
+	err := p.pub.PublishPos(pos)
+	for attempt := 0; err != nil && attempt < p.cfg.MaxRetries; attempt++ {
+		if errors.Is(err, ErrPublisherDone) {
+			return err
+		}
+		p.sleep(backoff)
+		if backoff *= 2; backoff > p.cfg.MaxBackoff {
+			backoff = p.cfg.MaxBackoff
+		}
+		err = p.pub.PublishPos(pos)
+	}
+	if err == nil || errors.Is(err, ErrPublisherDone) || p.cfg.DeadLetter == nil {
+		return err
+	}
+
+	if dlerr := p.cfg.DeadLetter.PublishPos(pos); dlerr != nil {
+		return fmt.Errorf("Error publishing to dead letter publisher: %w (after: %v)", dlerr, err)
+	}
+	return nil
+}
+
+// Flush flushes both publishers
+func (p *retryPosPub) Flush() error {
+	var err error
+	for _, pub := range []PosPublisher{p.pub, p.cfg.DeadLetter} {
+		if f, ok := pub.(Flusher); ok {
+			if ferr := f.Flush(); err == nil {
+				err = ferr
+			}
+		}
+	}
+	return err
+}
+
+// Close closes both publishers
+func (p *retryPosPub) Close() error {
+	var err error
+	for _, pub := range []PosPublisher{p.pub, p.cfg.DeadLetter} {
+		if c, ok := pub.(io.Closer); ok {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
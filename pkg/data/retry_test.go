@@ -0,0 +1,57 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gpontesss/routesim/pkg/gps"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Creates a retry publisher that records its backoffs instead of sleeping
+func testRetryPublisher(pub PosPublisher, cfg RetryConfig) (*retryPosPub, *[]time.Duration) {
+	var waits []time.Duration
+	rpub := RetryPublisher(pub, cfg).(*retryPosPub)
+	rpub.sleep = func(d time.Duration) { waits = append(waits, d) }
+	return rpub, &waits
+}
+
+func TestRetryPublisher(t *testing.T) {
+	inner := &recordingPub{failAt: map[int]bool{0: true, 1: true}}
+	pub, waits := testRetryPublisher(inner, RetryConfig{
+		MaxRetries: 3,
+		Backoff:    time.Second,
+		MaxBackoff: 1500 * time.Millisecond,
+	})
+
+	require.NoError(t, pub.PublishPos(testPos("bus-1", nil)))
+	assert.Equal(t, []string{"bus-1", "bus-1", "bus-1"}, inner.ids)
+	assert.Equal(t, []time.Duration{time.Second, 1500 * time.Millisecond}, *waits)
+}
+
+func TestRetryPublisherExhausted(t *testing.T) {
+	fails := map[int]bool{0: true, 1: true, 2: true}
+
+	inner := &recordingPub{failAt: fails}
+	pub, _ := testRetryPublisher(inner, RetryConfig{MaxRetries: 2})
+	assert.Error(t, pub.PublishPos(testPos("bus-1", nil)))
+	assert.Len(t, inner.ids, 3)
+
+	inner, deadLetter := &recordingPub{failAt: fails}, new(recordingPub)
+	pub, _ = testRetryPublisher(inner, RetryConfig{MaxRetries: 2, DeadLetter: deadLetter})
+	require.NoError(t, pub.PublishPos(testPos("bus-1", nil)))
+	assert.Equal(t, []string{"bus-1"}, deadLetter.ids)
+
+	require.NoError(t, Close(pub))
+	assert.True(t, inner.closed)
+	assert.True(t, deadLetter.closed)
+}
+
+func TestRetryPublisherDone(t *testing.T) {
+	done := PosPublisherFunc(func(gps.Position) error { return ErrPublisherDone })
+	pub, waits := testRetryPublisher(done, RetryConfig{MaxRetries: 3, DeadLetter: new(recordingPub)})
+	assert.Equal(t, ErrPublisherDone, pub.PublishPos(testPos("bus-1", nil)))
+	assert.Empty(t, *waits)
+}
@@ -1,44 +1,249 @@
 package data
 
 import (
-	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
 
 	"github.com/gpontesss/routesim/pkg/gps"
 	"github.com/jonas-p/go-shp"
 )
 
+const (
+	// WGS84 geographic CRS, in the ESRI WKT dialect GIS tools expect in .prj
+	// files
+	wgs84PRJ = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],` +
+		`PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`
+	// Format of DBF timestamps, which have no native type with a time
+	shpTimeFormat = "2006-01-02T15:04:05.000Z"
+	// Size of DBF character fields
+	shpStringSize = 64
+	// Maximum length of DBF field names
+	shpMaxFieldName = 10
+)
+
+// ShpfileConfig describes the shapefile a publisher writes
+type ShpfileConfig struct {
+	// Path of the .shp file. The .shx, .dbf and .prj files are written next
+	// to it.
+	Path string
+	// Number of positions after which the shapefile is closed. Zero means no
+	// limit.
+	Count int32
+	// GPS metadata keys written as DBF fields. Field names are truncated to
+	// 10 characters, as DBF requires, and must stay distinct, regardless of
+	// case, from each other and from the fixed fields.
+	MetadataFields []string
+	// Writes a POLYLINE track per device, when closed, instead of a point per
+	// position
+	Tracks bool
+}
+
+// A device's track, as its positions are collected
+type shpTrack struct {
+	id       string
+	points   []shp.Point
+	from, to time.Time
+	metadata map[string]interface{}
+}
+
 type shpfilePosPub struct {
-	wtr   *shp.Writer
-	curri int32
-	stopi int32
+	cfg    ShpfileConfig
+	wtr    *shp.Writer
+	fields []shp.Field
+	n      int32
+	tracks []*shpTrack
+	// Index of tracks, by device ID
+	trackIdx map[string]int
+	closed   bool
 }
 
-// ShpfilePublisher docs here
-func ShpfilePublisher(filePath string, count int32) (PosPublisher, error) {
-	wtr, err := shp.Create(filePath, shp.POINT)
+// ShpfilePublisher creates a publisher that writes positions to a WGS84
+// shapefile, with X as longitude and Y as latitude. By default, every position
+// is a point, whose DBF record holds its GPS ID (GPS_ID), timestamp (TIME),
+// speed (SPEED), course (COURSE) and the selected metadata. With tracks,
+// every device is a polyline instead, whose record holds its GPS ID, first
+// and last timestamps (START and END), number of positions (POINTS) and the
+// selected metadata.
+func ShpfilePublisher(cfg ShpfileConfig) (PosPublisher, error) {
+	pub := &shpfilePosPub{cfg: cfg, trackIdx: map[string]int{}}
+	fields, err := pub.dbfFields()
 	if err != nil {
 		return nil, err
 	}
+	pub.fields = fields
 
-	return &shpfilePosPub{
-		wtr:   wtr,
-		curri: 0,
-		stopi: count,
-	}, nil
+	shapeType := shp.POINT
+	if cfg.Tracks {
+		shapeType = shp.POLYLINE
+	}
+	wtr, err := shp.Create(cfg.Path, shapeType)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating shapefile: %w", err)
+	}
+	pub.wtr = wtr
+	if err := wtr.SetFields(pub.fields); err != nil {
+		wtr.Close()
+		return nil, fmt.Errorf("Error creating DBF file: %w", err)
+	}
+	prj := strings.TrimSuffix(cfg.Path, ".shp") + ".prj"
+	if err := ioutil.WriteFile(prj, []byte(wgs84PRJ), 0644); err != nil {
+		wtr.Close()
+		return nil, fmt.Errorf("Error writing .prj file: %w", err)
+	}
+	return pub, nil
 }
 
-// Publish docs here
+// DBF fields of the shapefile's records. Metadata keys whose truncated names
+// collide are rejected, since DBF field names must be unique.
+func (p *shpfilePosPub) dbfFields() ([]shp.Field, error) {
+	fields := []shp.Field{shp.StringField("GPS_ID", shpStringSize)}
+	if p.cfg.Tracks {
+		fields = append(fields,
+			shp.StringField("START", uint8(len(shpTimeFormat))),
+			shp.StringField("END", uint8(len(shpTimeFormat))),
+			shp.NumberField("POINTS", 10),
+		)
+	} else {
+		fields = append(fields,
+			shp.StringField("TIME", uint8(len(shpTimeFormat))),
+			shp.FloatField("SPEED", 12, 3),
+			shp.FloatField("COURSE", 7, 2),
+		)
+	}
+	names := map[string]string{}
+	for _, f := range fields {
+		name := shpFieldName(f)
+		names[name] = name
+	}
+	for _, key := range p.cfg.MetadataFields {
+		name := key
+		if len(name) > shpMaxFieldName {
+			name = name[:shpMaxFieldName]
+		}
+		f := shp.StringField(name, shpStringSize)
+		if other, ok := names[shpFieldName(f)]; ok {
+			return nil, fmt.Errorf("Metadata field '%s' has the same DBF field name as '%s'", key, other)
+		}
+		names[shpFieldName(f)] = key
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// Name of a DBF field, which is case insensitive
+func shpFieldName(f shp.Field) string {
+	return strings.ToUpper(strings.TrimRight(string(f.Name[:]), "\x00"))
+}
+
+// PublishPos writes a position as a point, or adds it to its device's track.
+// Once count positions are published, the shapefile is closed and
+// ErrPublisherDone is returned.
 func (p *shpfilePosPub) PublishPos(pos gps.Position) error {
-	coord := &shp.Point{
-		X: pos.Lat.Degrees(),
-		Y: pos.Lng.Degrees(),
+	if p.closed {
+		return ErrPublisherDone
+	}
+	point := shp.Point{
+		X: pos.Lng.Degrees(),
+		Y: pos.Lat.Degrees(),
 	}
-	p.curri = p.wtr.Write(coord)
 
-	if p.curri+1 == p.stopi {
-		defer p.wtr.Close()
-		return errors.New("Reached desired positions count")
+	if p.cfg.Tracks {
+		p.addToTrack(pos, point)
+	} else if err := p.writePoint(pos, point); err != nil {
+		return err
 	}
 
+	if p.n++; p.n == p.cfg.Count {
+		if err := p.Close(); err != nil {
+			return err
+		}
+		return fmt.Errorf("Reached desired positions count: %w", ErrPublisherDone)
+	}
+	return nil
+}
+
+func (p *shpfilePosPub) writePoint(pos gps.Position, point shp.Point) error {
+	row := int(p.wtr.Write(&point))
+	return p.writeRecord(row, []interface{}{
+		pos.GPS.ID(),
+		pos.At.UTC().Format(shpTimeFormat),
+		pos.Speed,
+		pos.Course,
+	}, pos.GPS.Metadata())
+}
+
+func (p *shpfilePosPub) addToTrack(pos gps.Position, point shp.Point) {
+	id := pos.GPS.ID()
+	i, ok := p.trackIdx[id]
+	if !ok {
+		i = len(p.tracks)
+		p.trackIdx[id] = i
+		p.tracks = append(p.tracks, &shpTrack{id: id, from: pos.At})
+	}
+	trk := p.tracks[i]
+	trk.points = append(trk.points, point)
+	trk.to = pos.At
+	// Metadata may change, so the last one is kept
+	trk.metadata = pos.GPS.Metadata()
+}
+
+// Writes a record's attributes: the given ones, followed by the selected
+// metadata
+func (p *shpfilePosPub) writeRecord(row int, attrs []interface{}, metadata map[string]interface{}) error {
+	for _, key := range p.cfg.MetadataFields {
+		value := ""
+		if v, ok := metadata[key]; ok {
+			value = fmt.Sprint(v)
+		}
+		attrs = append(attrs, value)
+	}
+	for field, attr := range attrs {
+		if err := p.wtr.WriteAttribute(row, field, p.dbfValue(field, attr)); err != nil {
+			return fmt.Errorf("Error writing DBF attribute: %w", err)
+		}
+	}
+	return nil
+}
+
+// Formats an attribute for its DBF field. Values are padded with spaces, as
+// DBF readers expect: strings to the right, truncated if too long, and
+// numbers to the left.
+func (p *shpfilePosPub) dbfValue(field int, attr interface{}) string {
+	f := p.fields[field]
+	switch v := attr.(type) {
+	case string:
+		if len(v) > int(f.Size) {
+			v = v[:f.Size]
+		}
+		return fmt.Sprintf("%-*s", f.Size, v)
+	case float64:
+		return fmt.Sprintf("%*.*f", f.Size, f.Precision, v)
+	default:
+		return fmt.Sprintf("%*v", f.Size, v)
+	}
+}
+
+// Close writes the devices' tracks, if any, and the shapefile's headers, and
+// closes its files. Until then, the files aren't valid.
+func (p *shpfilePosPub) Close() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	defer p.wtr.Close()
+	for _, trk := range p.tracks {
+		row := int(p.wtr.Write(shp.NewPolyLine([][]shp.Point{trk.points})))
+		err := p.writeRecord(row, []interface{}{
+			trk.id,
+			trk.from.UTC().Format(shpTimeFormat),
+			trk.to.UTC().Format(shpTimeFormat),
+			len(trk.points),
+		}, trk.metadata)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -0,0 +1,198 @@
+package data
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/gps/gpstest"
+	"github.com/gpontesss/routesim/pkg/route"
+	"github.com/jonas-p/go-shp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShpfilePublisherClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routesim")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "points.shp")
+	pub, err := ShpfilePublisher(ShpfileConfig{Path: path, Count: 100})
+	require.NoError(t, err)
+
+	gpz := gpstest.TestGPS("TEST1234",
+		s2.LatLngFromDegrees(-23.5, -46.6),
+		s2.LatLngFromDegrees(-23.6, -46.7),
+	)
+	require.NoError(t, pub.PublishPos(gpz.CurrentPos()))
+	require.NoError(t, pub.PublishPos(gpz.CurrentPos()))
+	// Stopped before reaching the count, as when interrupted
+	require.NoError(t, Close(pub))
+
+	rdr, err := shp.Open(path)
+	require.NoError(t, err)
+	defer rdr.Close()
+
+	var points []*shp.Point
+	for rdr.Next() {
+		_, shape := rdr.Shape()
+		points = append(points, shape.(*shp.Point))
+	}
+	require.Len(t, points, 2)
+	assert.InDelta(t, -46.6, points[0].X, 1e-9)
+	assert.InDelta(t, -23.5, points[0].Y, 1e-9)
+}
+
+func TestShpfilePublisherCount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routesim")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pub, err := ShpfilePublisher(ShpfileConfig{Path: filepath.Join(dir, "points.shp"), Count: 2})
+	require.NoError(t, err)
+
+	gpz := gpstest.TestGPS("TEST1234", s2.LatLng{}, s2.LatLng{}, s2.LatLng{})
+	require.NoError(t, pub.PublishPos(gpz.CurrentPos()))
+	assert.True(t, errors.Is(pub.PublishPos(gpz.CurrentPos()), ErrPublisherDone))
+	assert.True(t, errors.Is(pub.PublishPos(gpz.CurrentPos()), ErrPublisherDone))
+}
+
+// Reads every shape of a shapefile and its attributes, by field name
+func readShapefile(t *testing.T, path string) ([]shp.Shape, []map[string]string) {
+	rdr, err := shp.Open(path)
+	require.NoError(t, err)
+	defer rdr.Close()
+
+	var (
+		shapes []shp.Shape
+		attrs  []map[string]string
+	)
+	fields := rdr.Fields()
+	for rdr.Next() {
+		i, shape := rdr.Shape()
+		shapes = append(shapes, shape)
+		rec := map[string]string{}
+		for j, field := range fields {
+			name := strings.TrimRight(string(field.Name[:]), "\x00")
+			rec[name] = strings.TrimSpace(rdr.ReadAttribute(i, j))
+		}
+		attrs = append(attrs, rec)
+	}
+	return shapes, attrs
+}
+
+func TestShpfilePublisherAttributes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routesim")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "points.shp")
+	pub, err := ShpfilePublisher(ShpfileConfig{Path: path, MetadataFields: []string{"line", "depot_name"}})
+	require.NoError(t, err)
+
+	pos := testPos("bus-1", map[string]interface{}{"line": 8000})
+	pos.LatLng = s2.LatLngFromDegrees(-23.5, -46.6)
+	pos.At = time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	pos.Speed = 12.5
+	pos.Course = 270
+	require.NoError(t, pub.PublishPos(pos))
+	require.NoError(t, Close(pub))
+
+	shapes, attrs := readShapefile(t, path)
+	require.Len(t, shapes, 1)
+	assert.InDelta(t, -46.6, shapes[0].(*shp.Point).X, 1e-9)
+	assert.InDelta(t, -23.5, shapes[0].(*shp.Point).Y, 1e-9)
+	assert.Equal(t, map[string]string{
+		"GPS_ID":     "bus-1",
+		"TIME":       "2020-10-01T12:00:00.000Z",
+		"SPEED":      "12.500",
+		"COURSE":     "270.00",
+		"line":       "8000",
+		"depot_name": "",
+	}, attrs[0])
+
+	prj, err := ioutil.ReadFile(filepath.Join(dir, "points.prj"))
+	require.NoError(t, err)
+	_, err = route.ParseWKT(string(prj))
+	assert.NoError(t, err)
+}
+
+func TestShpfilePublisherTracks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routesim")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tracks.shp")
+	pub, err := ShpfilePublisher(ShpfileConfig{Path: path, Count: 5, Tracks: true, MetadataFields: []string{"line"}})
+	require.NoError(t, err)
+
+	at := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		id := "bus-1"
+		if i%2 == 1 {
+			id = "bus-2"
+		}
+		pos := testPos(id, map[string]interface{}{"line": id + "-line"})
+		pos.LatLng = s2.LatLngFromDegrees(-23.5, -46.6+float64(i)*0.01)
+		pos.At = at.Add(time.Duration(i) * time.Minute)
+		err := pub.PublishPos(pos)
+		if i < 4 {
+			require.NoError(t, err)
+		} else {
+			assert.True(t, errors.Is(err, ErrPublisherDone))
+		}
+	}
+
+	shapes, attrs := readShapefile(t, path)
+	require.Len(t, shapes, 2)
+	assert.Len(t, shapes[0].(*shp.PolyLine).Points, 3)
+	assert.Equal(t, map[string]string{
+		"GPS_ID": "bus-1",
+		"START":  "2020-10-01T12:00:00.000Z",
+		"END":    "2020-10-01T12:04:00.000Z",
+		"POINTS": "3",
+		"line":   "bus-1-line",
+	}, attrs[0])
+	assert.Equal(t, "bus-2", attrs[1]["GPS_ID"])
+	assert.Equal(t, "2", attrs[1]["POINTS"])
+
+	// Tracks can be simulated again
+	rdr, err := shp.Open(path)
+	require.NoError(t, err)
+	defer rdr.Close()
+	paths, err := route.FromShapefile(rdr, route.ShapeSelector{Attribute: "GPS_ID", Value: "bus-2"}, route.Coordinates{})
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+	assert.True(t, s2.LatLngFromDegrees(-23.5, -46.59).ApproxEqual(s2.LatLngFromPoint((*paths[0])[0])))
+}
+
+func TestShpfilePublisherFieldCollision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routesim")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for name, cfg := range map[string]ShpfileConfig{
+		"Truncated": {MetadataFields: []string{"vehicle_type", "vehicle_typ2"}},
+		"Case":      {MetadataFields: []string{"line", "LINE"}},
+		"Fixed":     {MetadataFields: []string{"speed"}},
+		"Tracks":    {MetadataFields: []string{"points"}, Tracks: true},
+	} {
+		cfg.Path = filepath.Join(dir, name+".shp")
+		_, err := ShpfilePublisher(cfg)
+		assert.Error(t, err, name)
+		assert.NoFileExists(t, cfg.Path, name)
+	}
+
+	pub, err := ShpfilePublisher(ShpfileConfig{
+		Path:           filepath.Join(dir, "points.shp"),
+		MetadataFields: []string{"vehicle_type", "vehicle_id", "points"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, Close(pub))
+}
@@ -0,0 +1,47 @@
+package data
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSConfig describes how a publisher secures its connections
+type TLSConfig struct {
+	// PEM file of the certificate authorities trusted to verify the server.
+	// If empty, the system's are used.
+	CAFile string
+	// PEM files of the client certificate and its key, for mutual TLS
+	CertFile string
+	KeyFile  string
+	// Skips verifying the server's certificate. Only meant for testing.
+	InsecureSkipVerify bool
+}
+
+// Builds the crypto/tls configuration
+func (cfg TLSConfig) build() (*tls.Config, error) {
+	tlscfg := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading CA file: %w", err)
+		}
+		tlscfg.RootCAs = x509.NewCertPool()
+		if !tlscfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificate found in CA file %s", cfg.CAFile)
+		}
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, errors.New("Both TLS certificate and key files must be set")
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading TLS certificate: %w", err)
+		}
+		tlscfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlscfg, nil
+}
@@ -0,0 +1,193 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Size of the pieces a device's track buffers in memory before they are
+// spooled as a run
+const trackSpoolRunSize = 8 << 10
+
+// A piece of a track's XML, as found in the spool file
+type spoolChunk struct {
+	offset int64
+	size   int
+}
+
+// A device's track, as its XML pieces are spooled
+type spooledTrack struct {
+	id       string
+	metadata map[string]interface{}
+	// Chunks of each of the track's streams, e.g. times and coordinates
+	streams [][]spoolChunk
+	// Pieces of each stream not spooled yet, and their total size
+	pending     []bytes.Buffer
+	pendingSize int
+}
+
+// Track documents, such as GPX or KML ones, group their points by device,
+// while positions of many devices arrive interleaved. A trackSpool buffers
+// every device's points' XML and appends it to a spool file in runs of about
+// 8 KiB, so points reach the disk while the simulation runs and only a chunk
+// per run and stream is remembered, rather than one per point. When finished,
+// the document is assembled from the spool, device by device, and the spool
+// is removed.
+type trackSpool struct {
+	path   string
+	file   *os.File
+	wtr    *bufio.Writer
+	offset int64
+	tracks []*spooledTrack
+	// Index of tracks, by device ID
+	trackIdx map[string]int
+	nstreams int
+	done     bool
+}
+
+// Creates a spool for the document at path, whose tracks have nstreams
+// streams of pieces each
+func newTrackSpool(path string, nstreams int) (*trackSpool, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("Error creating directory %s: %w", dir, err)
+		}
+	}
+	file, err := os.Create(path + ".spool")
+	if err != nil {
+		return nil, fmt.Errorf("Error creating spool file: %w", err)
+	}
+	return &trackSpool{
+		path:     path,
+		file:     file,
+		wtr:      bufio.NewWriter(file),
+		trackIdx: map[string]int{},
+		nstreams: nstreams,
+	}, nil
+}
+
+// Appends a piece to a stream of a device's track. The track's metadata is
+// the last one appended.
+func (sp *trackSpool) append(id string, metadata map[string]interface{}, stream int, piece []byte) error {
+	i, ok := sp.trackIdx[id]
+	if !ok {
+		i = len(sp.tracks)
+		sp.trackIdx[id] = i
+		sp.tracks = append(sp.tracks, &spooledTrack{
+			id:      id,
+			streams: make([][]spoolChunk, sp.nstreams),
+			pending: make([]bytes.Buffer, sp.nstreams),
+		})
+	}
+	trk := sp.tracks[i]
+	trk.metadata = metadata
+
+	trk.pending[stream].Write(piece)
+	if trk.pendingSize += len(piece); trk.pendingSize >= trackSpoolRunSize {
+		return sp.writeRun(trk)
+	}
+	return nil
+}
+
+// Appends a track's pending pieces to the spool file, stream by stream
+func (sp *trackSpool) writeRun(trk *spooledTrack) error {
+	for stream := range trk.pending {
+		pending := &trk.pending[stream]
+		if pending.Len() == 0 {
+			continue
+		}
+		n, err := sp.wtr.Write(pending.Bytes())
+		if err != nil {
+			return fmt.Errorf("Error writing to spool file: %w", err)
+		}
+		pending.Reset()
+		// Consecutive runs of a stream are merged, as when a single device
+		// is simulated
+		chunks := trk.streams[stream]
+		if last := len(chunks) - 1; last >= 0 && chunks[last].offset+int64(chunks[last].size) == sp.offset {
+			chunks[last].size += n
+		} else {
+			trk.streams[stream] = append(chunks, spoolChunk{sp.offset, n})
+		}
+		sp.offset += int64(n)
+	}
+	trk.pendingSize = 0
+	return nil
+}
+
+// Writes every pending piece to the spool file
+func (sp *trackSpool) flush() error {
+	for _, trk := range sp.tracks {
+		if trk.pendingSize == 0 {
+			continue
+		}
+		if err := sp.writeRun(trk); err != nil {
+			return err
+		}
+	}
+	if err := sp.wtr.Flush(); err != nil {
+		return fmt.Errorf("Error writing to spool file: %w", err)
+	}
+	return nil
+}
+
+// Writes the document, calling writeTrack for each track in order of
+// appearance, between the header and the footer. The spool is removed
+// afterwards.
+func (sp *trackSpool) finish(header, footer string, writeTrack func(w *trackWriter) error) (err error) {
+	if sp.done {
+		return nil
+	}
+	sp.done = true
+	defer func() {
+		sp.file.Close()
+		if err == nil {
+			err = os.Remove(sp.file.Name())
+		}
+	}()
+	if err := sp.flush(); err != nil {
+		return err
+	}
+
+	out, err := os.Create(sp.path)
+	if err != nil {
+		return fmt.Errorf("Error creating %s: %w", sp.path, err)
+	}
+	defer out.Close()
+	wtr := bufio.NewWriter(out)
+	tw := &trackWriter{Writer: wtr, spool: sp.file}
+	tw.WriteString(header)
+	for _, trk := range sp.tracks {
+		tw.track = trk
+		if err := writeTrack(tw); err != nil {
+			return err
+		}
+	}
+	tw.WriteString(footer)
+	if err := wtr.Flush(); err != nil {
+		return fmt.Errorf("Error writing %s: %w", sp.path, err)
+	}
+	return out.Close()
+}
+
+// Writes a track of a document being assembled
+type trackWriter struct {
+	*bufio.Writer
+	spool *os.File
+	track *spooledTrack
+}
+
+// Copies the pieces of a stream of the track from the spool
+func (tw *trackWriter) copyStream(stream int) error {
+	for _, chunk := range tw.track.streams[stream] {
+		piece := io.NewSectionReader(tw.spool, chunk.offset, int64(chunk.size))
+		if _, err := io.Copy(tw, piece); err != nil {
+			return fmt.Errorf("Error reading spool file: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package data
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackSpoolRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routesim")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tracks.xml")
+	sp, err := newTrackSpool(path, 2)
+	require.NoError(t, err)
+
+	// Devices are interleaved, yet each run of a stream is a single chunk
+	const points = 1000
+	for i := 0; i < points; i++ {
+		for _, id := range []string{"A", "B"} {
+			require.NoError(t, sp.append(id, nil, 0, []byte(fmt.Sprintf("<t>%s%d</t>\n", id, i))))
+			require.NoError(t, sp.append(id, nil, 1, []byte(fmt.Sprintf("<c>%s%d</c>\n", id, i))))
+		}
+	}
+	require.NoError(t, sp.flush())
+	for _, trk := range sp.tracks {
+		for _, chunks := range trk.streams {
+			assert.True(t, len(chunks) < points/50, "%d chunks", len(chunks))
+		}
+		assert.Equal(t, 0, trk.pendingSize)
+	}
+
+	require.NoError(t, sp.finish("<doc>\n", "</doc>\n", func(w *trackWriter) error {
+		fmt.Fprintf(w, "<trk>%s\n", w.track.id)
+		for stream := range w.track.streams {
+			if err := w.copyStream(stream); err != nil {
+				return err
+			}
+		}
+		w.WriteString("</trk>\n")
+		return nil
+	}))
+	doc, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(string(doc), "\n")
+	require.Len(t, lines, 4*points+7)
+	assert.Equal(t, "<trk>A", lines[1])
+	assert.Equal(t, "<t>A0</t>", lines[2])
+	assert.Equal(t, fmt.Sprintf("<t>A%d</t>", points-1), lines[points+1])
+	assert.Equal(t, "<c>A0</c>", lines[points+2])
+	assert.Equal(t, "<trk>B", lines[2*points+3])
+	assert.Equal(t, fmt.Sprintf("<c>B%d</c>", points-1), lines[4*points+3])
+}
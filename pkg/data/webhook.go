@@ -0,0 +1,350 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Default number of payloads sent in a single batch
+	webhookDefaultBatchSize = 100
+	// Default time a payload may wait in the buffer before being sent
+	webhookDefaultLinger = time.Second
+	// Default maximum time a request may take
+	webhookDefaultTimeout = 10 * time.Second
+	// Default number of times failed requests are retried
+	webhookDefaultMaxRetries = 3
+)
+
+// WebhookBatching describes how payloads are grouped into requests
+type WebhookBatching int
+
+const (
+	// NoBatching sends each payload in its own request
+	NoBatching WebhookBatching = iota
+	// ArrayBatching sends payloads in batches, as a JSON array. Payloads must
+	// be JSON values.
+	ArrayBatching
+	// NDJSONBatching sends payloads in batches, one per line. Payloads must
+	// not contain line breaks.
+	NDJSONBatching
+)
+
+// ParseWebhookBatching parses a batching mode: single, array or ndjson
+func ParseWebhookBatching(s string) (WebhookBatching, error) {
+	switch strings.ToLower(s) {
+	case "", "single":
+		return NoBatching, nil
+	case "array":
+		return ArrayBatching, nil
+	case "ndjson":
+		return NDJSONBatching, nil
+	default:
+		return 0, fmt.Errorf("Unknown webhook batching '%s'", s)
+	}
+}
+
+func (b WebhookBatching) String() string {
+	switch b {
+	case ArrayBatching:
+		return "array"
+	case NDJSONBatching:
+		return "ndjson"
+	default:
+		return "single"
+	}
+}
+
+// WebhookConfig describes how payloads are sent to an HTTP endpoint
+type WebhookConfig struct {
+	URL string
+	// HTTP method. Defaults to POST.
+	Method string
+	// Additional request headers
+	Headers map[string]string
+	// Sent as a bearer token in the Authorization header, if set
+	BearerToken string
+	// Sent as basic auth credentials, if Username is set
+	Username string
+	Password string
+	// Content type of single payloads. Batches are sent as application/json
+	// or application/x-ndjson. Defaults to application/octet-stream.
+	ContentType string
+	Batching    WebhookBatching
+	// Maximum number of payloads sent in a single batch
+	BatchSize int
+	// Maximum time a payload waits in the buffer before its batch is sent
+	Linger time.Duration
+	// Maximum time a request may take, including reading its response
+	Timeout time.Duration
+	// Number of times a request is retried after a 5xx or 429 response, or a
+	// network error. Defaults to 3; a negative number disables retries.
+	MaxRetries int
+	// Wait time before the first retry. It doubles on each attempt, unless
+	// the endpoint asks for a wait with Retry-After.
+	Backoff time.Duration
+	// Maximum wait time between retries
+	MaxBackoff time.Duration
+	// Maximum number of concurrent requests. Publishing blocks while the
+	// limit is reached. Defaults to 1, which keeps requests in order.
+	MaxInFlight int
+	// TLS options for https endpoints
+	TLS *TLSConfig
+}
+
+type webhookPub struct {
+	sync.Mutex
+	// Held from taking a payload or batch until its request starts, so
+	// requests start in order
+	sendMu    sync.Mutex
+	cfg       WebhookConfig
+	client    *http.Client
+	buffer    [][]byte
+	inFlight  chan struct{}
+	pending   sync.WaitGroup
+	errChan   chan error
+	done      chan struct{}
+	closeOnce sync.Once
+	sleep     func(time.Duration)
+}
+
+// WebhookPublisher creates a publisher that sends payloads to an HTTP
+// endpoint, either one per request or in batches. Requests are sent in the
+// background; their errors are reported on the next Publish or Flush call.
+// Failed requests are retried, with an exponential backoff, if the endpoint
+// responds with a 5xx or 429 status, or can't be reached.
+func WebhookPublisher(cfg WebhookConfig) (Publisher, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("Webhook URL must be set")
+	}
+	if cfg.Method == "" {
+		cfg.Method = http.MethodPost
+	}
+	if cfg.ContentType == "" {
+		cfg.ContentType = "application/octet-stream"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = webhookDefaultBatchSize
+	}
+	if cfg.Linger <= 0 {
+		cfg.Linger = webhookDefaultLinger
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = webhookDefaultTimeout
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	} else if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = webhookDefaultMaxRetries
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaultRetryBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if cfg.MaxInFlight <= 0 {
+		cfg.MaxInFlight = 1
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.TLS != nil {
+		tlscfg, err := cfg.TLS.build()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlscfg
+	}
+	pub := &webhookPub{
+		cfg:      cfg,
+		client:   &http.Client{Transport: transport, Timeout: cfg.Timeout},
+		inFlight: make(chan struct{}, cfg.MaxInFlight),
+		errChan:  make(chan error, 1),
+		done:     make(chan struct{}),
+		sleep:    time.Sleep,
+	}
+	if cfg.Batching != NoBatching {
+		pub.init()
+	}
+	return pub, nil
+}
+
+// Initializes a goroutine that sends the buffer every time the linger time
+// expires
+func (pub *webhookPub) init() {
+	go func() {
+		ticker := time.NewTicker(pub.cfg.Linger)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-pub.done:
+				return
+			case <-ticker.C:
+				pub.sendBuffer()
+			}
+		}
+	}()
+}
+
+// Publish sends a payload, or appends it to the buffer if batching. If the
+// buffer reaches the batch size, it is sent.
+func (pub *webhookPub) Publish(bs []byte) error {
+	select {
+	case err := <-pub.errChan:
+		return err
+	default:
+	}
+
+	// Payloads are sent in the background, so the caller's may not be kept
+	bs = append([]byte(nil), bs...)
+	if pub.cfg.Batching == NoBatching {
+		pub.sendMu.Lock()
+		defer pub.sendMu.Unlock()
+		pub.send(bs, pub.cfg.ContentType)
+		return nil
+	}
+	pub.Lock()
+	pub.buffer = append(pub.buffer, bs)
+	full := len(pub.buffer) >= pub.cfg.BatchSize
+	pub.Unlock()
+	if full {
+		pub.sendBuffer()
+	}
+	return nil
+}
+
+// Flush sends buffered payloads and waits for every request to complete
+func (pub *webhookPub) Flush() error {
+	pub.sendBuffer()
+	pub.pending.Wait()
+	select {
+	case err := <-pub.errChan:
+		return err
+	default:
+		return nil
+	}
+}
+
+// Close stops the linger timer and waits for in-flight requests. Buffered
+// payloads must be flushed beforehand.
+func (pub *webhookPub) Close() error {
+	pub.closeOnce.Do(func() { close(pub.done) })
+	pub.pending.Wait()
+	pub.client.CloseIdleConnections()
+	return nil
+}
+
+// Sends the buffered payloads as a batch, if any. Either the linger timer or a
+// full buffer may send it.
+func (pub *webhookPub) sendBuffer() {
+	pub.sendMu.Lock()
+	defer pub.sendMu.Unlock()
+	pub.Lock()
+	batch := pub.buffer
+	pub.buffer = nil
+	pub.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	switch pub.cfg.Batching {
+	case ArrayBatching:
+		body := append([]byte{'['}, bytes.Join(batch, []byte{','})...)
+		pub.send(append(body, ']'), "application/json")
+	case NDJSONBatching:
+		body := append(bytes.Join(batch, []byte{'\n'}), '\n')
+		pub.send(body, "application/x-ndjson")
+	}
+}
+
+// Sends a request in the background, once the in-flight limit allows it.
+// Only the first error is kept until it is reported. It must be called with
+// sendMu held.
+func (pub *webhookPub) send(body []byte, contentType string) {
+	pub.inFlight <- struct{}{}
+	pub.pending.Add(1)
+	go func() {
+		defer pub.pending.Done()
+		defer func() { <-pub.inFlight }()
+		if err := pub.post(body, contentType); err != nil {
+			select {
+			case pub.errChan <- err:
+			default:
+			}
+		}
+	}()
+}
+
+// Sends a request, retrying it with an exponential backoff until retries are
+// exhausted
+func (pub *webhookPub) post(body []byte, contentType string) error {
+	backoff := pub.cfg.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, wait, err := pub.do(body, contentType)
+		if err == nil || !retry || attempt >= pub.cfg.MaxRetries {
+			return err
+		}
+		if wait <= 0 {
+			wait = backoff
+		}
+		if wait > pub.cfg.MaxBackoff {
+			wait = pub.cfg.MaxBackoff
+		}
+		pub.sleep(wait)
+		if backoff *= 2; backoff > pub.cfg.MaxBackoff {
+			backoff = pub.cfg.MaxBackoff
+		}
+	}
+}
+
+// Sends a request once. It tells whether a failure is worth retrying and how
+// long the endpoint asked to wait before doing so.
+func (pub *webhookPub) do(body []byte, contentType string) (bool, time.Duration, error) {
+	req, err := http.NewRequest(pub.cfg.Method, pub.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, 0, fmt.Errorf("Error creating webhook request: %w", err)
+	}
+	for key, value := range pub.cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if pub.cfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+pub.cfg.BearerToken)
+	} else if pub.cfg.Username != "" {
+		req.SetBasicAuth(pub.cfg.Username, pub.cfg.Password)
+	}
+
+	res, err := pub.client.Do(req)
+	if err != nil {
+		return true, 0, fmt.Errorf("Error sending webhook request: %w", err)
+	}
+	// Draining the body lets the connection be reused
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return false, 0, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return true, retryAfter(res), fmt.Errorf("Webhook responded with %s", res.Status)
+	default:
+		return false, 0, fmt.Errorf("Webhook responded with %s", res.Status)
+	}
+}
+
+// Wait time asked by a Retry-After header in seconds, if any
+func retryAfter(res *http.Response) time.Duration {
+	secs, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
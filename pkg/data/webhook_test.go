@@ -0,0 +1,222 @@
+package data
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Endpoint that records request bodies and responds with queued statuses,
+// then 200
+type testEndpoint struct {
+	sync.Mutex
+	statuses []int
+	bodies   []string
+	requests []*http.Request
+}
+
+func (e *testEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	e.Lock()
+	defer e.Unlock()
+	e.bodies = append(e.bodies, string(body))
+	e.requests = append(e.requests, r)
+	status := http.StatusOK
+	if len(e.statuses) > 0 {
+		status, e.statuses = e.statuses[0], e.statuses[1:]
+	}
+	if status == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", "2")
+	}
+	w.WriteHeader(status)
+}
+
+// Creates a webhook publisher of an endpoint, which records its backoffs
+// instead of sleeping
+func testWebhookPublisher(t *testing.T, e http.Handler, cfg WebhookConfig) (*webhookPub, *[]time.Duration) {
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	cfg.URL = srv.URL
+	pub, err := WebhookPublisher(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { Close(pub) })
+
+	var waits []time.Duration
+	wpub := pub.(*webhookPub)
+	wpub.sleep = func(d time.Duration) { waits = append(waits, d) }
+	return wpub, &waits
+}
+
+func TestWebhookPublisher(t *testing.T) {
+	e := new(testEndpoint)
+	pub, _ := testWebhookPublisher(t, e, WebhookConfig{
+		Headers:     map[string]string{"X-Source": "routesim"},
+		BearerToken: "secret",
+		ContentType: "application/geo+json",
+	})
+
+	require.NoError(t, pub.Publish([]byte(`{"id":1}`)))
+	require.NoError(t, pub.Publish([]byte(`{"id":2}`)))
+	require.NoError(t, pub.Flush())
+
+	assert.Equal(t, []string{`{"id":1}`, `{"id":2}`}, e.bodies)
+	req := e.requests[0]
+	assert.Equal(t, http.MethodPost, req.Method)
+	assert.Equal(t, "application/geo+json", req.Header.Get("Content-Type"))
+	assert.Equal(t, "routesim", req.Header.Get("X-Source"))
+	assert.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+}
+
+func TestWebhookPublisherBatching(t *testing.T) {
+	cases := map[string]struct {
+		batching    WebhookBatching
+		bodies      []string
+		contentType string
+	}{
+		"Array":  {ArrayBatching, []string{`[{"id":1},{"id":2}]`, `[{"id":3}]`}, "application/json"},
+		"NDJSON": {NDJSONBatching, []string{"{\"id\":1}\n{\"id\":2}\n", "{\"id\":3}\n"}, "application/x-ndjson"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			e := new(testEndpoint)
+			pub, _ := testWebhookPublisher(t, e, WebhookConfig{
+				Batching:  c.batching,
+				BatchSize: 2,
+				Linger:    time.Hour,
+				Username:  "fleet",
+				Password:  "secret",
+			})
+
+			for _, payload := range []string{`{"id":1}`, `{"id":2}`, `{"id":3}`} {
+				require.NoError(t, pub.Publish([]byte(payload)))
+			}
+			require.NoError(t, pub.Flush())
+
+			assert.Equal(t, c.bodies, e.bodies)
+			assert.Equal(t, c.contentType, e.requests[0].Header.Get("Content-Type"))
+			user, pass, ok := e.requests[0].BasicAuth()
+			assert.True(t, ok)
+			assert.Equal(t, "fleet", user)
+			assert.Equal(t, "secret", pass)
+		})
+	}
+}
+
+func TestWebhookPublisherRetries(t *testing.T) {
+	e := &testEndpoint{statuses: []int{503, 429, 500}}
+	pub, waits := testWebhookPublisher(t, e, WebhookConfig{
+		MaxRetries: 3,
+		Backoff:    time.Second,
+		MaxBackoff: 3 * time.Second,
+	})
+
+	require.NoError(t, pub.Publish([]byte("1")))
+	require.NoError(t, pub.Flush())
+	assert.Len(t, e.bodies, 4)
+	// The 429 response asks for a 2s wait
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, *waits)
+
+	e.statuses = []int{503, 503}
+	pub.cfg.MaxRetries = 1
+	require.NoError(t, pub.Publish([]byte("2")))
+	assert.EqualError(t, pub.Flush(), "Webhook responded with 503 Service Unavailable")
+
+	// Client errors aren't retried
+	e.statuses = []int{400}
+	require.NoError(t, pub.Publish([]byte("3")))
+	assert.EqualError(t, pub.Flush(), "Webhook responded with 400 Bad Request")
+	assert.Len(t, e.bodies, 7)
+}
+
+func TestWebhookPublisherMaxInFlight(t *testing.T) {
+	var (
+		mu                sync.Mutex
+		inFlight, maxSeen int
+	)
+	release := make(chan struct{})
+	e := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxSeen {
+			maxSeen = inFlight
+		}
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	})
+	pub, _ := testWebhookPublisher(t, e, WebhookConfig{MaxInFlight: 2})
+
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			pub.Publish([]byte("payload"))
+		}
+		close(published)
+	}()
+	// Publishing blocks while the limit is reached
+	select {
+	case <-published:
+		t.Fatal("Publishing didn't block on the in-flight limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-published
+	require.NoError(t, pub.Flush())
+	assert.Equal(t, 2, maxSeen)
+}
+
+func TestWebhookPublisherBatchOrder(t *testing.T) {
+	e := new(testEndpoint)
+	release := make(chan struct{})
+	blocked := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		e.ServeHTTP(w, r)
+	})
+	pub, _ := testWebhookPublisher(t, blocked, WebhookConfig{
+		Batching:  NDJSONBatching,
+		BatchSize: 2,
+		Linger:    time.Hour,
+	})
+
+	// The first batch holds the only in-flight slot
+	require.NoError(t, pub.Publish([]byte("0")))
+	require.NoError(t, pub.Publish([]byte("1")))
+	require.NoError(t, pub.Publish([]byte("2")))
+
+	// The linger timer takes a batch, then another one fills up
+	go pub.sendBuffer()
+	require.Eventually(t, func() bool {
+		pub.Lock()
+		defer pub.Unlock()
+		return len(pub.buffer) == 0
+	}, time.Second, time.Millisecond)
+	published := make(chan struct{})
+	go func() {
+		pub.Publish([]byte("3"))
+		pub.Publish([]byte("4"))
+		close(published)
+	}()
+
+	close(release)
+	<-published
+	require.NoError(t, pub.Flush())
+	assert.Equal(t, []string{"0\n1\n", "2\n", "3\n4\n"}, e.bodies)
+}
+
+func TestParseWebhookBatching(t *testing.T) {
+	for _, b := range []WebhookBatching{NoBatching, ArrayBatching, NDJSONBatching} {
+		parsed, err := ParseWebhookBatching(b.String())
+		require.NoError(t, err)
+		assert.Equal(t, b, parsed)
+	}
+	_, err := ParseWebhookBatching("xml")
+	assert.Error(t, err)
+}
@@ -12,6 +12,7 @@ import (
 type wsPosPub struct {
 	sync.Mutex
 	address, path string
+	srv           *http.Server
 	errChan       chan error
 	listeners     map[*websocket.Conn]chan<- []byte
 }
@@ -45,16 +46,36 @@ func (pub *wsPosPub) Publish(bs []byte) error {
 // server. It handles the connection upgrade. For new connections, see
 // handleConn.
 func (pub *wsPosPub) init() {
-	go func() {
-		srv := websocket.Server{Handler: websocket.Handler(pub.handleConn)}
-		mux := http.NewServeMux()
-		mux.Handle(pub.path, srv)
+	srv := websocket.Server{Handler: websocket.Handler(pub.handleConn)}
+	mux := http.NewServeMux()
+	mux.Handle(pub.path, srv)
+	pub.srv = &http.Server{Addr: pub.address, Handler: mux}
 
+	go func() {
 		fmt.Println("Listening on", pub.address)
-		pub.errChan <- http.ListenAndServe(pub.address, mux)
+		if err := pub.srv.ListenAndServe(); err != http.ErrServerClosed {
+			pub.errChan <- err
+		}
 	}()
 }
 
+// Close stops the server and disconnects all clients
+func (pub *wsPosPub) Close() error {
+	err := pub.srv.Shutdown(context.Background())
+	pub.Lock()
+	conns := make([]*websocket.Conn, 0, len(pub.listeners))
+	for conn := range pub.listeners {
+		conns = append(conns, conn)
+	}
+	pub.Unlock()
+	// Hijacked connections aren't closed by the server's shutdown. Closing
+	// them makes their handlers return.
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return err
+}
+
 // Broadcasts a position to all client listeners
 func (pub *wsPosPub) broadcast(bs []byte) {
 	pub.Lock()
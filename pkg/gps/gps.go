@@ -1,10 +1,13 @@
 package gps
 
 import (
+	"math"
+	"sync"
 	"time"
 
 	"github.com/golang/geo/s2"
 	"github.com/google/uuid"
+	"github.com/gpontesss/routesim/pkg/clock"
 )
 
 // GPS describes a GPS behavior
@@ -24,30 +27,91 @@ type Position struct {
 	s2.LatLng
 	At  time.Time
 	GPS GPS
+	// Speed over ground (m/s)
+	Speed float64
+	// Course over ground, in degrees clockwise from true north
+	Course float64
 }
 
-// Gets the current moment of time
-var nowFunc = time.Now
+// Bearing returns the initial bearing of the great circle path between two
+// points, in degrees clockwise from true north, within [0, 360)
+func Bearing(from, to s2.LatLng) float64 {
+	lat1, lat2 := from.Lat.Radians(), to.Lat.Radians()
+	dlng := (to.Lng - from.Lng).Radians()
+	y := math.Sin(dlng) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dlng)
+	deg := math.Atan2(y, x) * 180 / math.Pi
+	return math.Mod(deg+360, 360)
+}
+
+// Controllable is implemented by GPSs whose movement may be changed while
+// they are simulated. Its methods are safe to call concurrently with
+// CurrentPos.
+type Controllable interface {
+	GPS
+	// Velocity returns the GPS's velocity (m/s)
+	Velocity() float64
+	// SetVelocity changes the GPS's velocity (m/s) from now on
+	SetVelocity(vel float64)
+	// Pause stops the GPS where it is
+	Pause()
+	// Resume makes a paused GPS move again
+	Resume()
+	// Paused tells if the GPS is paused
+	Paused() bool
+	// Reset moves the GPS back to the start of its line
+	Reset()
+}
 
 // SimGPS simulates a real GPS that walks a line
 type SimGPS struct {
+	sync.Mutex
 	id         string
 	lw         LineWalker
 	vel        float64
 	lastReport time.Time
 	metadata   map[string]interface{}
+	clk        clock.Clock
+	paused     bool
+	// Speed that follows the velocity model, and distance from the line's
+	// start (m)
+	speed    *velocity
+	progress float64
+	// Last reported position and course
+	lastLL     s2.LatLng
+	lastCourse float64
+	walked     bool
 }
 
 // NewSimGPS creates a GPS simulator that walks a line with a constant velocity.
 // Velocity is given by m/s.
 func NewSimGPS(vel float64, lw LineWalker, metadata map[string]interface{}) GPS {
+	return SimGPSWithClock(uuid.New().String(), vel, lw, metadata, clock.Real())
+}
+
+// SimGPSWithClock creates a GPS simulator with a given ID, whose positions
+// follow the time of a simulation clock.
+func SimGPSWithClock(id string, vel float64, lw LineWalker, metadata map[string]interface{}, clk clock.Clock) GPS {
 	return &SimGPS{
-		id:         uuid.New().String(),
+		id:         id,
 		lw:         lw,
 		vel:        vel,
-		lastReport: nowFunc(),
+		lastReport: clk.Now(),
 		metadata:   metadata,
+		clk:        clk,
+		speed:      newVelocity(VelocityModel{}),
+	}
+}
+
+// SimGPSWithModel creates a GPS simulator like SimGPSWithClock, whose speed
+// follows a velocity model that targets vel.
+func SimGPSWithModel(id string, vel float64, lw LineWalker, model VelocityModel, metadata map[string]interface{}, clk clock.Clock) (GPS, error) {
+	if err := model.Validate(); err != nil {
+		return nil, err
 	}
+	gps := SimGPSWithClock(id, vel, lw, metadata, clk).(*SimGPS)
+	gps.speed = newVelocity(model)
+	return gps, nil
 }
 
 // ID returns the GPS' ID
@@ -62,11 +126,94 @@ func (gps *SimGPS) Metadata() map[string]interface{} {
 
 // CurrentPos returns the GPS' current position
 func (gps *SimGPS) CurrentPos() Position {
-	now := nowFunc()
-	dist := now.Sub(gps.lastReport).Seconds() * gps.vel
+	gps.Lock()
+	defer gps.Unlock()
+	now := gps.clk.Now()
+	ll := gps.walk(now)
+
+	speed := gps.speed.speed
+	if gps.paused {
+		speed = 0
+	}
+	if gps.walked && ll.Distance(gps.lastLL) > 0 {
+		gps.lastCourse = Bearing(gps.lastLL, ll)
+	}
+	gps.lastLL, gps.walked = ll, true
 
+	return Position{LatLng: ll, GPS: gps, At: now, Speed: speed, Course: gps.lastCourse}
+}
+
+// Walks the distance travelled since the last walk. It must be called with
+// the lock held.
+func (gps *SimGPS) walk(now time.Time) s2.LatLng {
+	secs := now.Sub(gps.lastReport).Seconds()
 	gps.lastReport = now
 
-	ll, _ := gps.lw.Walk(DistanceFromMeters(dist))
-	return Position{LatLng: ll, GPS: gps, At: now}
+	var ll s2.LatLng
+	walk := func(dist float64) float64 {
+		ll, _ = gps.lw.Walk(DistanceFromMeters(dist))
+		if p, ok := gps.lw.(Progresser); ok {
+			gps.progress = float64(p.Progress()) * earthRadius
+		} else {
+			gps.progress += dist
+		}
+		return gps.progress
+	}
+	if gps.paused {
+		walk(0)
+	} else {
+		gps.speed.advance(secs, gps.vel, gps.progress, walk)
+	}
+	return ll
+}
+
+// Velocity returns the GPS's velocity (m/s), which is its cruise speed if it
+// follows a velocity model
+func (gps *SimGPS) Velocity() float64 {
+	gps.Lock()
+	defer gps.Unlock()
+	return gps.vel
+}
+
+// SetVelocity changes the GPS's velocity (m/s). The distance travelled until
+// now is walked with the previous velocity.
+func (gps *SimGPS) SetVelocity(vel float64) {
+	gps.Lock()
+	defer gps.Unlock()
+	gps.walk(gps.clk.Now())
+	gps.vel = vel
+}
+
+// Pause stops the GPS where it is
+func (gps *SimGPS) Pause() {
+	gps.Lock()
+	defer gps.Unlock()
+	gps.walk(gps.clk.Now())
+	gps.paused = true
+	gps.speed.stop()
+}
+
+// Resume makes a paused GPS move again
+func (gps *SimGPS) Resume() {
+	gps.Lock()
+	defer gps.Unlock()
+	gps.walk(gps.clk.Now())
+	gps.paused = false
+}
+
+// Paused tells if the GPS is paused
+func (gps *SimGPS) Paused() bool {
+	gps.Lock()
+	defer gps.Unlock()
+	return gps.paused
+}
+
+// Reset moves the GPS back to the start of its line
+func (gps *SimGPS) Reset() {
+	gps.Lock()
+	defer gps.Unlock()
+	gps.lw.Reset()
+	gps.lastReport = gps.clk.Now()
+	gps.progress = 0
+	gps.speed.stop()
 }
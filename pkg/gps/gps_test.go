@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/clock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -18,6 +19,12 @@ func TimeFunc(rets ...time.Time) func() time.Time {
 	}
 }
 
+// Clock whose time is given by a function
+type funcClock func() time.Time
+
+func (f funcClock) Now() time.Time                         { return f() }
+func (f funcClock) NewTicker(_ time.Duration) clock.Ticker { return nil }
+
 type fakeLineWalker struct {
 	mock.Mock
 }
@@ -31,7 +38,7 @@ func (f *fakeLineWalker) Reset() {}
 
 func TestSimGPS(t *testing.T) {
 	now := time.Now()
-	nowFunc = TimeFunc(now, now.Add(5*time.Second), now.Add(8*time.Second))
+	clk := funcClock(TimeFunc(now, now.Add(5*time.Second), now.Add(8*time.Second)))
 
 	flw := new(fakeLineWalker)
 	flw.On("Walk", DistanceFromMeters(50)).
@@ -42,13 +49,59 @@ func TestSimGPS(t *testing.T) {
 	metadata := map[string]interface{}{
 		"vehicle": "car",
 	}
-	gps := NewSimGPS(10, flw, metadata)
+	gps := SimGPSWithClock("TEST1234", 10, flw, metadata, clk)
 
-	assert.NotEqual(t, gps.ID(), "")
+	assert.Equal(t, "TEST1234", gps.ID())
 	assert.Equal(t, s2.LatLngFromDegrees(45, 45), gps.CurrentPos().LatLng)
-	assert.Equal(t, s2.LatLngFromDegrees(90, 0), gps.CurrentPos().LatLng)
+	pos := gps.CurrentPos()
+	assert.Equal(t, s2.LatLngFromDegrees(90, 0), pos.LatLng)
+	assert.Equal(t, now.Add(8*time.Second), pos.At)
 	assert.Equal(t, "car", gps.Metadata()["vehicle"])
 
 	flw.AssertNumberOfCalls(t, "Walk", 2)
 	flw.AssertExpectations(t)
 }
+
+func TestSimGPSControls(t *testing.T) {
+	now := time.Now()
+	clk := funcClock(func() time.Time { return now })
+	// Along the equator, a degree is about 111 km
+	path := s2.PolylineFromLatLngs([]s2.LatLng{s2.LatLngFromDegrees(0, 0), s2.LatLngFromDegrees(0, 1)})
+	gps := SimGPSWithClock("TEST1234", 1000, RestartWalker(path), nil, clk).(Controllable)
+
+	lng := func() float64 { return gps.CurrentPos().Lng.Degrees() }
+	step := func(d time.Duration) { now = now.Add(d) }
+	const km = 1 / 111.195
+
+	step(10 * time.Second)
+	assert.InDelta(t, 10*km, lng(), 1e-3)
+
+	step(10 * time.Second)
+	gps.SetVelocity(2000)
+	assert.Equal(t, 2000.0, gps.Velocity())
+	step(10 * time.Second)
+	pos := gps.CurrentPos()
+	assert.InDelta(t, 40*km, pos.Lng.Degrees(), 1e-3)
+	assert.Equal(t, 2000.0, pos.Speed)
+	assert.InDelta(t, 90, pos.Course, 1e-6)
+
+	gps.Pause()
+	assert.True(t, gps.Paused())
+	step(10 * time.Second)
+	assert.InDelta(t, 40*km, lng(), 1e-3)
+	gps.Resume()
+	step(10 * time.Second)
+	assert.InDelta(t, 60*km, lng(), 1e-3)
+
+	gps.Reset()
+	assert.InDelta(t, 0, lng(), 1e-9)
+}
+
+func TestBearing(t *testing.T) {
+	origin := s2.LatLngFromDegrees(0, 0)
+	assert.InDelta(t, 0, Bearing(origin, s2.LatLngFromDegrees(1, 0)), 1e-9)
+	assert.InDelta(t, 90, Bearing(origin, s2.LatLngFromDegrees(0, 1)), 1e-9)
+	assert.InDelta(t, 180, Bearing(origin, s2.LatLngFromDegrees(-1, 0)), 1e-9)
+	assert.InDelta(t, 270, Bearing(origin, s2.LatLngFromDegrees(0, -1)), 1e-9)
+	assert.InDelta(t, 45, Bearing(origin, s2.LatLngFromDegrees(1, 1)), 0.01)
+}
@@ -0,0 +1,35 @@
+package gps
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// RandomIMEI generates a random 15-digit IMEI, whose last digit is a valid
+// Luhn check digit.
+func RandomIMEI(rng *rand.Rand) string {
+	var sb strings.Builder
+	for i := 0; i < 14; i++ {
+		sb.WriteByte(byte('0' + rng.Intn(10)))
+	}
+	payload := sb.String()
+	return payload + strconv.Itoa(LuhnCheckDigit(payload))
+}
+
+// LuhnCheckDigit computes the Luhn check digit of a string of digits
+func LuhnCheckDigit(digits string) int {
+	sum := 0
+	double := true
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return (10 - sum%10) % 10
+}
@@ -0,0 +1,29 @@
+package gps
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLuhnCheckDigit(t *testing.T) {
+	cases := map[string]int{
+		// Examples from the IMEI and Luhn algorithm articles on Wikipedia
+		"49015420323751": 8,
+		"7992739871":     3,
+	}
+	for digits, check := range cases {
+		assert.Equal(t, check, LuhnCheckDigit(digits), digits)
+	}
+}
+
+func TestRandomIMEI(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		imei := RandomIMEI(rng)
+		assert.Len(t, imei, 15)
+		assert.Equal(t, int(imei[14]-'0'), LuhnCheckDigit(imei[:14]))
+	}
+	assert.Equal(t, RandomIMEI(rand.New(rand.NewSource(2))), RandomIMEI(rand.New(rand.NewSource(2))))
+}
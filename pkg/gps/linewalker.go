@@ -17,6 +17,14 @@ type LineWalker interface {
 	Walk(dist Distance) (s2.LatLng, bool)
 }
 
+// Progresser is implemented by LineWalkers that know how far along the line
+// they are
+type Progresser interface {
+	// Progress returns the distance from the line's start to the current
+	// position
+	Progress() Distance
+}
+
 // Distance is the distance that a LineWalker should walk.
 //
 // It is slightly more comprehensive than an angle (even though being one).
@@ -73,6 +81,15 @@ func (w *backForthWalker) Walk(dist Distance) (s2.LatLng, bool) {
 	return s2.LatLngFromPoint(pt), crossedEdge
 }
 
+// Progress returns the distance from the line's start, whichever the
+// direction
+func (w *backForthWalker) Progress() Distance {
+	if w.currPos >= 1 {
+		return Distance(2-w.currPos) * w.len
+	}
+	return Distance(w.currPos) * w.len
+}
+
 type restartWalker struct {
 	path    *s2.Polyline
 	currPos float64
@@ -109,3 +126,8 @@ func (w *restartWalker) Walk(dist Distance) (s2.LatLng, bool) {
 	pt, _ := w.path.Interpolate(w.currPos)
 	return s2.LatLngFromPoint(pt), crossedEdge
 }
+
+// Progress returns the distance from the line's start
+func (w *restartWalker) Progress() Distance {
+	return Distance(w.currPos) * w.len
+}
@@ -0,0 +1,138 @@
+package gps
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/clock"
+	"github.com/gpontesss/routesim/pkg/route"
+)
+
+// ReplayOptions describes how a recorded track is replayed
+type ReplayOptions struct {
+	// How many times faster than recorded the track is replayed. Defaults to
+	// 1.
+	Speed float64
+	// Restarts the replay when the track ends. Otherwise, the GPS stays at
+	// the track's last sample.
+	Loop bool
+	// Reports positions at the clock's time, instead of at the recorded time
+	ShiftToNow bool
+}
+
+// ReplayGPS replays a recorded track
+type ReplayGPS struct {
+	sync.Mutex
+	id       string
+	trk      route.Track
+	opts     ReplayOptions
+	metadata map[string]interface{}
+	clk      clock.Clock
+	// Clock time the replay started at
+	start time.Time
+	// Course of the last segment the GPS moved along
+	lastCourse float64
+}
+
+// NewReplayGPS creates a GPS that replays a recorded track from the clock's
+// current time. Every sample must have a time, and samples must be in time
+// order. Positions are interpolated between the samples around the replayed
+// time, so the GPS moves as fast as it was recorded.
+func NewReplayGPS(id string, trk route.Track, opts ReplayOptions, metadata map[string]interface{}, clk clock.Clock) (GPS, error) {
+	if len(trk) < 2 {
+		return nil, errors.New("Replayed track must have at least 2 samples")
+	}
+	for i, smp := range trk {
+		if smp.Time.IsZero() {
+			return nil, errors.New("Every sample of a replayed track must have a time")
+		}
+		if i > 0 && smp.Time.Before(trk[i-1].Time) {
+			return nil, errors.New("Samples of a replayed track must be in time order")
+		}
+	}
+	if !trk[len(trk)-1].Time.After(trk[0].Time) {
+		return nil, errors.New("Replayed track must span some time")
+	}
+	if opts.Speed < 0 {
+		return nil, errors.New("Replay speed must be positive")
+	}
+	if opts.Speed == 0 {
+		opts.Speed = 1
+	}
+
+	return &ReplayGPS{
+		id:       id,
+		trk:      trk,
+		opts:     opts,
+		metadata: metadata,
+		clk:      clk,
+		start:    clk.Now(),
+	}, nil
+}
+
+// ID returns the GPS' ID
+func (gps *ReplayGPS) ID() string {
+	return gps.id
+}
+
+// Metadata returns simulated device metadata
+func (gps *ReplayGPS) Metadata() map[string]interface{} {
+	return gps.metadata
+}
+
+// CurrentPos returns the recorded position at the replayed time. Its speed
+// is the recorded one, sped up if positions are shifted to the clock's time.
+func (gps *ReplayGPS) CurrentPos() Position {
+	gps.Lock()
+	defer gps.Unlock()
+	now := gps.clk.Now()
+	first, last := gps.trk[0].Time, gps.trk[len(gps.trk)-1].Time
+	span := last.Sub(first)
+
+	elapsed := time.Duration(float64(now.Sub(gps.start)) * gps.opts.Speed)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	var loops time.Duration
+	ended := false
+	if gps.opts.Loop {
+		loops, elapsed = elapsed/span, elapsed%span
+	} else if elapsed >= span {
+		elapsed, ended = span, true
+	}
+	at := first.Add(elapsed)
+
+	// Segment around the replayed time. Its end is the first sample after
+	// it, or the last one.
+	i := sort.Search(len(gps.trk), func(i int) bool { return gps.trk[i].Time.After(at) })
+	if i == len(gps.trk) {
+		i--
+	}
+	from, to := gps.trk[i-1], gps.trk[i]
+	gap := to.Time.Sub(from.Time)
+	frac := 1.0
+	if gap > 0 {
+		frac = float64(at.Sub(from.Time)) / float64(gap)
+	}
+	ll := s2.LatLngFromPoint(s2.Interpolate(frac, s2.PointFromLatLng(from.LatLng), s2.PointFromLatLng(to.LatLng)))
+
+	var speed float64
+	if dist := from.Distance(to.LatLng); dist > 0 {
+		gps.lastCourse = Bearing(from.LatLng, to.LatLng)
+		if !ended && gap > 0 {
+			speed = float64(dist) * earthRadius / gap.Seconds()
+		}
+	}
+
+	if gps.opts.ShiftToNow {
+		at = now
+		speed *= gps.opts.Speed
+	} else {
+		// Later loops keep the recorded times increasing
+		at = at.Add(loops * span)
+	}
+	return Position{LatLng: ll, GPS: gps, At: at, Speed: speed, Course: gps.lastCourse}
+}
@@ -0,0 +1,102 @@
+package gps
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/route"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayGPS(t *testing.T) {
+	rec := time.Date(2020, 10, 1, 8, 0, 0, 0, time.UTC)
+	// Along the equator, a degree is about 111 km. The GPS drives the first
+	// degree in 100s, stops for 100s and drives the second one in 50s.
+	trk := route.Track{
+		{LatLng: s2.LatLngFromDegrees(0, 0), Time: rec},
+		{LatLng: s2.LatLngFromDegrees(0, 1), Time: rec.Add(100 * time.Second)},
+		{LatLng: s2.LatLngFromDegrees(0, 1), Time: rec.Add(200 * time.Second)},
+		{LatLng: s2.LatLngFromDegrees(0, 2), Time: rec.Add(250 * time.Second)},
+	}
+	const degree = 111195.0
+
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := funcClock(func() time.Time { return now })
+	step := func(d time.Duration) { now = now.Add(d) }
+
+	t.Run("Recorded", func(t *testing.T) {
+		gps, err := NewReplayGPS("TEST1234", trk, ReplayOptions{}, nil, clk)
+		require.NoError(t, err)
+
+		pos := gps.CurrentPos()
+		assert.InDelta(t, 0, pos.Lng.Degrees(), 1e-9)
+		assert.Equal(t, rec, pos.At)
+
+		step(25 * time.Second)
+		pos = gps.CurrentPos()
+		assert.InDelta(t, 0.25, pos.Lng.Degrees(), 1e-9)
+		assert.Equal(t, rec.Add(25*time.Second), pos.At)
+		assert.InDelta(t, degree/100, pos.Speed, 1)
+		assert.InDelta(t, 90, pos.Course, 1e-6)
+
+		step(125 * time.Second)
+		pos = gps.CurrentPos()
+		assert.InDelta(t, 1, pos.Lng.Degrees(), 1e-9)
+		assert.Equal(t, 0.0, pos.Speed)
+		assert.InDelta(t, 90, pos.Course, 1e-6)
+
+		step(75 * time.Second)
+		pos = gps.CurrentPos()
+		assert.InDelta(t, 1.5, pos.Lng.Degrees(), 1e-9)
+		assert.InDelta(t, degree/50, pos.Speed, 1)
+
+		// Without looping, it stays at the end
+		step(time.Hour)
+		pos = gps.CurrentPos()
+		assert.InDelta(t, 2, pos.Lng.Degrees(), 1e-9)
+		assert.Equal(t, rec.Add(250*time.Second), pos.At)
+		assert.Equal(t, 0.0, pos.Speed)
+	})
+
+	t.Run("SpedUpAndShifted", func(t *testing.T) {
+		gps, err := NewReplayGPS("TEST1234", trk, ReplayOptions{Speed: 5, ShiftToNow: true}, nil, clk)
+		require.NoError(t, err)
+
+		step(10 * time.Second)
+		pos := gps.CurrentPos()
+		assert.InDelta(t, 0.5, pos.Lng.Degrees(), 1e-9)
+		assert.Equal(t, now, pos.At)
+		assert.InDelta(t, 5*degree/100, pos.Speed, 5)
+	})
+
+	t.Run("Looped", func(t *testing.T) {
+		gps, err := NewReplayGPS("TEST1234", trk, ReplayOptions{Loop: true}, nil, clk)
+		require.NoError(t, err)
+
+		step(275 * time.Second)
+		pos := gps.CurrentPos()
+		assert.InDelta(t, 0.25, pos.Lng.Degrees(), 1e-9)
+		assert.Equal(t, rec.Add(275*time.Second), pos.At)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		untimed := route.Track{{LatLng: s2.LatLngFromDegrees(0, 0)}, {LatLng: s2.LatLngFromDegrees(0, 1)}}
+		unordered := route.Track{trk[1], trk[0]}
+		instant := route.Track{trk[0], {LatLng: s2.LatLngFromDegrees(0, 1), Time: rec}}
+		for name, c := range map[string]struct {
+			trk  route.Track
+			opts ReplayOptions
+		}{
+			"Short":     {trk[:1], ReplayOptions{}},
+			"Untimed":   {untimed, ReplayOptions{}},
+			"Unordered": {unordered, ReplayOptions{}},
+			"Instant":   {instant, ReplayOptions{}},
+			"Speed":     {trk, ReplayOptions{Speed: -1}},
+		} {
+			_, err := NewReplayGPS("TEST1234", c.trk, c.opts, nil, clk)
+			assert.Error(t, err, name)
+		}
+	})
+}
@@ -0,0 +1,177 @@
+package gps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	// Longest step, in seconds, that a speed following a VelocityModel is
+	// integrated by
+	velocityStep = 1.0
+	// How often a new random variation is drawn if unset
+	defaultVariationPeriod = 10 * time.Second
+)
+
+// VelocityModel describes how the speed of a SimGPS changes as it walks its
+// line. The GPS's velocity is its cruise speed, which the model targets. The
+// zero value keeps the cruise speed.
+type VelocityModel struct {
+	// Bounds of the speed (m/s). A MaxSpeed of 0 means no bound.
+	MinSpeed, MaxSpeed float64
+	// Limits of the speed's rate of change (m/s²). A limit of 0 means the
+	// speed changes at once. If Acceleration is limited, the GPS starts at
+	// MinSpeed.
+	Acceleration, Deceleration float64
+	// Random variation of the target speed, as a fraction of it, e.g. 0.1
+	// for up to 10% faster or slower
+	Variation float64
+	// How often a new variation is drawn. Defaults to 10s.
+	VariationPeriod time.Duration
+	// Source of the random variation. It must be set if Variation is.
+	Rand *rand.Rand
+	// Target speeds of ranges of the line, which replace the cruise speed.
+	// The first range that holds the GPS's position applies.
+	Profiles []SpeedProfile
+}
+
+// SpeedProfile sets the target speed of a range of a line, e.g. slow in its
+// first 500 m
+type SpeedProfile struct {
+	// Start and end of the range, in meters from the line's start. An end of
+	// 0 means the line's end.
+	From, To float64
+	// Target speed in the range (m/s)
+	Speed float64
+}
+
+// Contains tells if a position, in meters from the line's start, is in the
+// profile's range
+func (p SpeedProfile) Contains(pos float64) bool {
+	return pos >= p.From && (p.To == 0 || pos < p.To)
+}
+
+// Validate checks that the model's settings are consistent
+func (m VelocityModel) Validate() error {
+	switch {
+	case m.MinSpeed < 0 || m.MaxSpeed < 0:
+		return errors.New("Speed bounds must be positive")
+	case m.MaxSpeed > 0 && m.MinSpeed > m.MaxSpeed:
+		return errors.New("Minimum speed must not exceed the maximum speed")
+	case m.Acceleration < 0 || m.Deceleration < 0:
+		return errors.New("Acceleration and deceleration must be positive")
+	case m.Variation < 0 || m.Variation > 1:
+		return errors.New("Speed variation must be between 0 and 1")
+	case m.Variation > 0 && m.Rand == nil:
+		return errors.New("Speed variation needs a random source")
+	case m.VariationPeriod < 0:
+		return errors.New("Variation period must be positive")
+	}
+	for i, p := range m.Profiles {
+		if p.From < 0 || (p.To != 0 && p.To <= p.From) || p.Speed < 0 {
+			return fmt.Errorf("Speed profile %d must have a positive speed and range", i)
+		}
+	}
+	return nil
+}
+
+// Tells if the speed may differ from the bounded cruise speed
+func (m VelocityModel) dynamic() bool {
+	return m.Acceleration > 0 || m.Deceleration > 0 || m.Variation > 0 || len(m.Profiles) > 0
+}
+
+// Bounds a speed
+func (m VelocityModel) clamp(speed float64) float64 {
+	if m.MaxSpeed > 0 {
+		speed = math.Min(speed, m.MaxSpeed)
+	}
+	return math.Max(speed, m.MinSpeed)
+}
+
+// Speed of a GPS that follows a VelocityModel
+type velocity struct {
+	model VelocityModel
+	speed float64
+	// Current random variation, and seconds until the next one is drawn
+	variation, untilDraw float64
+}
+
+func newVelocity(model VelocityModel) *velocity {
+	if model.VariationPeriod == 0 {
+		model.VariationPeriod = defaultVariationPeriod
+	}
+	v := &velocity{model: model}
+	v.stop()
+	return v
+}
+
+// Stops the GPS. It starts moving again at MinSpeed if acceleration is
+// limited.
+func (v *velocity) stop() {
+	v.speed = 0
+	if v.model.Acceleration > 0 {
+		v.speed = v.model.MinSpeed
+	}
+}
+
+// Advances the speed for a period, given the cruise speed and the position
+// along the line (m). It walks the distance travelled (m) with walk, which
+// returns the new position.
+func (v *velocity) advance(secs, cruise, pos float64, walk func(dist float64) float64) {
+	if !v.model.dynamic() {
+		v.speed = v.model.clamp(cruise)
+		walk(secs * v.speed)
+		return
+	}
+	secs = math.Max(secs, 0)
+	for {
+		step := math.Min(secs, velocityStep)
+		var dist float64
+		v.speed, dist = v.approach(v.target(cruise, pos, step), step)
+		pos = walk(dist)
+		if secs -= step; secs <= 0 {
+			return
+		}
+	}
+}
+
+// Target speed at a position along the line, drawing a new random variation
+// if it is due
+func (v *velocity) target(cruise, pos, step float64) float64 {
+	target := cruise
+	for _, p := range v.model.Profiles {
+		if p.Contains(pos) {
+			target = p.Speed
+			break
+		}
+	}
+
+	if v.model.Variation > 0 {
+		if v.untilDraw <= 0 {
+			v.variation = (2*v.model.Rand.Float64() - 1) * v.model.Variation
+			v.untilDraw = v.model.VariationPeriod.Seconds()
+		}
+		v.untilDraw -= step
+		target *= 1 + v.variation
+	}
+	return v.model.clamp(target)
+}
+
+// Changes the speed towards a target for a period, within the acceleration
+// or deceleration limit. It returns the final speed and distance travelled.
+func (v *velocity) approach(target, secs float64) (float64, float64) {
+	rate := v.model.Acceleration
+	if target < v.speed {
+		rate = -v.model.Deceleration
+	}
+	if rate == 0 {
+		return target, target * secs
+	}
+	// Time until the target is reached, after which the speed is constant
+	t := math.Min((target-v.speed)/rate, secs)
+	end := v.speed + rate*t
+	return end, v.speed*t + rate*t*t/2 + end*(secs-t)
+}
@@ -0,0 +1,106 @@
+package gps
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimGPSWithModel(t *testing.T) {
+	// Along the equator, a degree is about 111 km
+	path := s2.PolylineFromLatLngs([]s2.LatLng{s2.LatLngFromDegrees(0, 0), s2.LatLngFromDegrees(0, 1)})
+	const meter = 180 / (math.Pi * earthRadius)
+
+	build := func(vel float64, model VelocityModel) (Controllable, func(time.Duration)) {
+		now := time.Now()
+		clk := funcClock(func() time.Time { return now })
+		gps, err := SimGPSWithModel("TEST1234", vel, RestartWalker(path), model, nil, clk)
+		require.NoError(t, err)
+		return gps.(Controllable), func(d time.Duration) { now = now.Add(d) }
+	}
+
+	t.Run("Acceleration", func(t *testing.T) {
+		gps, step := build(20, VelocityModel{MinSpeed: 2, Acceleration: 2, Deceleration: 4})
+
+		// Starts at the minimum speed
+		step(4 * time.Second)
+		pos := gps.CurrentPos()
+		assert.InDelta(t, 10, pos.Speed, 1e-9)
+		assert.InDelta(t, 24*meter, pos.Lng.Degrees(), 1e-9)
+
+		// Reaches the cruise speed after 9s
+		step(11 * time.Second)
+		pos = gps.CurrentPos()
+		assert.InDelta(t, 20, pos.Speed, 1e-9)
+		assert.InDelta(t, (24+75+120)*meter, pos.Lng.Degrees(), 1e-9)
+
+		gps.SetVelocity(4)
+		step(2 * time.Second)
+		pos = gps.CurrentPos()
+		assert.InDelta(t, 12, pos.Speed, 1e-9)
+		assert.InDelta(t, (24+75+120+32)*meter, pos.Lng.Degrees(), 1e-9)
+
+		gps.Pause()
+		assert.Equal(t, 0.0, gps.CurrentPos().Speed)
+		gps.Resume()
+		step(time.Second)
+		assert.InDelta(t, 4, gps.CurrentPos().Speed, 1e-9)
+	})
+
+	t.Run("Profiles", func(t *testing.T) {
+		gps, step := build(10, VelocityModel{Profiles: []SpeedProfile{{To: 101, Speed: 2}}})
+
+		step(51 * time.Second)
+		pos := gps.CurrentPos()
+		assert.InDelta(t, 102*meter, pos.Lng.Degrees(), 1e-9)
+		assert.InDelta(t, 2, pos.Speed, 1e-9)
+
+		step(10 * time.Second)
+		pos = gps.CurrentPos()
+		assert.InDelta(t, 202*meter, pos.Lng.Degrees(), 1e-9)
+		assert.InDelta(t, 10, pos.Speed, 1e-9)
+
+		gps.Reset()
+		step(time.Second)
+		assert.InDelta(t, 2, gps.CurrentPos().Speed, 1e-9)
+	})
+
+	t.Run("Variation", func(t *testing.T) {
+		run := func() []float64 {
+			model := VelocityModel{MaxSpeed: 11, Variation: 0.2, VariationPeriod: 5 * time.Second, Rand: rand.New(rand.NewSource(1))}
+			gps, step := build(10, model)
+			var speeds []float64
+			for i := 0; i < 20; i++ {
+				step(5 * time.Second)
+				speeds = append(speeds, gps.CurrentPos().Speed)
+			}
+			return speeds
+		}
+
+		speeds := run()
+		assert.Equal(t, speeds, run())
+		distinct := map[float64]bool{}
+		for _, speed := range speeds {
+			assert.True(t, speed >= 8 && speed <= 11, "speed %f out of bounds", speed)
+			distinct[speed] = true
+		}
+		assert.True(t, len(distinct) > 1)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for name, model := range map[string]VelocityModel{
+			"Bounds":    {MinSpeed: 10, MaxSpeed: 5},
+			"Negative":  {Acceleration: -1},
+			"Variation": {Variation: 0.1},
+			"Profile":   {Profiles: []SpeedProfile{{From: 500, To: 100, Speed: 5}}},
+		} {
+			_, err := SimGPSWithModel("TEST1234", 10, RestartWalker(path), model, nil, funcClock(time.Now))
+			assert.Error(t, err, name)
+		}
+	})
+}
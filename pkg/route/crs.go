@@ -0,0 +1,366 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+// CRS is a coordinate reference system. It converts its coordinates into lat
+// lngs. Datum shifts aren't applied, as their differences to WGS84 are
+// negligible for simulation purposes.
+type CRS interface {
+	// LatLng converts a x (easting/longitude), y (northing/latitude) pair
+	LatLng(x, y float64) s2.LatLng
+}
+
+// WGS84 is the geographic CRS used when a source has no other
+var WGS84 CRS = geographicCRS{unit: 1}
+
+// AxisOrder tells how a source orders its coordinate pairs
+type AxisOrder int
+
+const (
+	// XYOrder orders pairs as easting/longitude, northing/latitude
+	XYOrder AxisOrder = iota
+	// YXOrder orders pairs as northing/latitude, easting/longitude
+	YXOrder
+)
+
+// Coordinates describes how a source's coordinates are interpreted
+type Coordinates struct {
+	// Coordinate reference system. WGS84 is used if nil.
+	CRS  CRS
+	Axis AxisOrder
+}
+
+// Converts a coordinate pair into a lat lng
+func (c Coordinates) latLng(a, b float64) s2.LatLng {
+	crs := c.CRS
+	if crs == nil {
+		crs = WGS84
+	}
+	if c.Axis == YXOrder {
+		return crs.LatLng(b, a)
+	}
+	return crs.LatLng(a, b)
+}
+
+// Geographic CRS. Coordinates are angles in the given unit, in degrees.
+type geographicCRS struct {
+	unit float64
+}
+
+func (crs geographicCRS) LatLng(x, y float64) s2.LatLng {
+	return s2.LatLngFromDegrees(y*crs.unit, x*crs.unit)
+}
+
+// Spherical ("Web") Mercator, as used by web map tiles
+type webMercatorCRS struct {
+	radius, unit, falseEasting, falseNorthing, centralMeridian float64
+}
+
+func (crs webMercatorCRS) LatLng(x, y float64) s2.LatLng {
+	x = (x - crs.falseEasting) * crs.unit / crs.radius
+	y = (y - crs.falseNorthing) * crs.unit / crs.radius
+	lat := math.Pi/2 - 2*math.Atan(math.Exp(-y))
+	return s2.LatLng{
+		Lat: s1.Angle(lat),
+		Lng: s1.Angle(x + crs.centralMeridian*math.Pi/180),
+	}
+}
+
+// Ellipsoidal Transverse Mercator, of which UTM zones are instances. The
+// inverse projection uses Krüger's series, accurate to well under a meter
+// within a zone.
+type transverseMercatorCRS struct {
+	unit                        float64
+	falseEasting, falseNorthing float64
+	centralMeridian             float64 // radians
+	scale                       float64
+	// Derived from the ellipsoid
+	a     float64 // rectifying radius
+	xi0   float64 // origin latitude's ξ
+	beta  [3]float64
+	delta [3]float64
+}
+
+// Creates a Transverse Mercator CRS. Angles are given in degrees; semiMajor in
+// meters; unit is the length of the coordinates' unit in meters.
+func newTransverseMercator(semiMajor, invFlattening, originLat, centralMeridian, scale, falseEasting, falseNorthing, unit float64) transverseMercatorCRS {
+	f := 1 / invFlattening
+	n := f / (2 - f)
+	n2, n3 := n*n, n*n*n
+
+	crs := transverseMercatorCRS{
+		unit:            unit,
+		falseEasting:    falseEasting,
+		falseNorthing:   falseNorthing,
+		centralMeridian: centralMeridian * math.Pi / 180,
+		scale:           scale,
+		a:               semiMajor / (1 + n) * (1 + n2/4 + n2*n2/64),
+		beta:            [3]float64{n/2 - 2*n2/3 + 37*n3/96, n2/48 + n3/15, 17 * n3 / 480},
+		delta:           [3]float64{2*n - 2*n2/3 - 2*n3, 7*n2/3 - 8*n3/5, 56 * n3 / 15},
+	}
+
+	// Forward projection of the origin latitude at the central meridian
+	alpha := [3]float64{n/2 - 2*n2/3 + 5*n3/16, 13*n2/48 - 3*n3/5, 61 * n3 / 240}
+	phi0 := originLat * math.Pi / 180
+	c := 2 * math.Sqrt(n) / (1 + n)
+	t := math.Sinh(math.Atanh(math.Sin(phi0)) - c*math.Atanh(c*math.Sin(phi0)))
+	xi := math.Atan(t)
+	crs.xi0 = xi
+	for j, aj := range alpha {
+		crs.xi0 += aj * math.Sin(float64(2*(j+1))*xi)
+	}
+
+	return crs
+}
+
+func (crs transverseMercatorCRS) LatLng(x, y float64) s2.LatLng {
+	xi := (y*crs.unit-crs.falseNorthing)/(crs.scale*crs.a) + crs.xi0
+	eta := (x*crs.unit - crs.falseEasting) / (crs.scale * crs.a)
+
+	xip, etap := xi, eta
+	for j, bj := range crs.beta {
+		k := float64(2 * (j + 1))
+		xip -= bj * math.Sin(k*xi) * math.Cosh(k*eta)
+		etap -= bj * math.Cos(k*xi) * math.Sinh(k*eta)
+	}
+
+	chi := math.Asin(math.Sin(xip) / math.Cosh(etap))
+	lat := chi
+	for j, dj := range crs.delta {
+		lat += dj * math.Sin(float64(2*(j+1))*chi)
+	}
+	lng := crs.centralMeridian + math.Atan2(math.Sinh(etap), math.Cos(xip))
+
+	return s2.LatLng{Lat: s1.Angle(lat), Lng: s1.Angle(lng)}
+}
+
+// ParseWKT parses a CRS described by Well-Known Text, as found in shapefiles'
+// .prj files. Geographic systems, Web Mercator and Transverse Mercator (e.g.
+// UTM zones) are supported.
+func ParseWKT(wkt string) (CRS, error) {
+	root, err := parseWKTNode(&wktScanner{s: wkt})
+	if err != nil {
+		return nil, fmt.Errorf("Invalid WKT: %w", err)
+	}
+
+	switch strings.ToUpper(root.name) {
+	case "GEOGCS":
+		return geographicCRS{unit: angularUnit(root)}, nil
+	case "PROJCS":
+		return projectedCRS(root)
+	default:
+		return nil, fmt.Errorf("Unsupported CRS type '%s'", root.name)
+	}
+}
+
+// Builds a projected CRS
+func projectedCRS(root *wktNode) (CRS, error) {
+	proj := ""
+	if node := root.child("PROJECTION"); node != nil {
+		proj = node.str(0)
+	}
+	name := normalizeWKTName(root.str(0) + " " + proj)
+
+	unit := 1.0
+	if node := root.child("UNIT"); node != nil {
+		unit = node.num(1, 1)
+	}
+
+	semiMajor, invFlattening := 6378137.0, 298.257223563
+	if geog := root.child("GEOGCS"); geog != nil {
+		if datum := geog.child("DATUM"); datum != nil {
+			if sph := datum.child("SPHEROID"); sph != nil {
+				semiMajor, invFlattening = sph.num(1, semiMajor), sph.num(2, invFlattening)
+			}
+		}
+	}
+
+	param := func(names ...string) float64 {
+		for _, node := range root.children {
+			if !strings.EqualFold(node.name, "PARAMETER") {
+				continue
+			}
+			pname := normalizeWKTName(node.str(0))
+			for _, name := range names {
+				if pname == name {
+					return node.num(1, 0)
+				}
+			}
+		}
+		return 0
+	}
+
+	switch {
+	case strings.Contains(name, "mercator_auxiliary_sphere"),
+		strings.Contains(name, "pseudo_mercator"),
+		strings.Contains(name, "web_mercator"):
+		return webMercatorCRS{
+			radius:          semiMajor,
+			unit:            unit,
+			falseEasting:    param("false_easting"),
+			falseNorthing:   param("false_northing"),
+			centralMeridian: param("central_meridian", "longitude_of_center"),
+		}, nil
+
+	case strings.Contains(name, "transverse_mercator"), strings.Contains(name, "utm"):
+		scale := param("scale_factor", "scale_factor_at_natural_origin")
+		if scale == 0 {
+			scale = 1
+		}
+		return newTransverseMercator(
+			semiMajor,
+			invFlattening,
+			param("latitude_of_origin", "latitude_of_natural_origin"),
+			param("central_meridian", "longitude_of_natural_origin"),
+			scale,
+			param("false_easting")*unit,
+			param("false_northing")*unit,
+			unit,
+		), nil
+
+	default:
+		return nil, fmt.Errorf("Unsupported projection '%s'", proj)
+	}
+}
+
+// Converts an angular unit node into a degree factor
+func angularUnit(root *wktNode) float64 {
+	node := root.child("UNIT")
+	if node == nil {
+		return 1
+	}
+	return node.num(1, math.Pi/180) * 180 / math.Pi
+}
+
+// Lower cases a name and replaces spaces and dashes with underscores
+func normalizeWKTName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return '_'
+		}
+		return unicode.ToLower(r)
+	}, name)
+}
+
+// WKT node, e.g. UNIT["Meter",1.0]
+type wktNode struct {
+	name     string
+	values   []string
+	children []*wktNode
+}
+
+// Returns the first child node with a name
+func (n *wktNode) child(name string) *wktNode {
+	for _, c := range n.children {
+		if strings.EqualFold(c.name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// Returns the i-th value as a string
+func (n *wktNode) str(i int) string {
+	if i < len(n.values) {
+		return n.values[i]
+	}
+	return ""
+}
+
+// Returns the i-th value as a number, or def if it isn't one
+func (n *wktNode) num(i int, def float64) float64 {
+	f, err := strconv.ParseFloat(n.str(i), 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
+
+type wktScanner struct {
+	s   string
+	pos int
+}
+
+func (sc *wktScanner) skipSpaces() {
+	for sc.pos < len(sc.s) && unicode.IsSpace(rune(sc.s[sc.pos])) {
+		sc.pos++
+	}
+}
+
+func (sc *wktScanner) peek() byte {
+	sc.skipSpaces()
+	if sc.pos >= len(sc.s) {
+		return 0
+	}
+	return sc.s[sc.pos]
+}
+
+// Reads a bare token: a keyword or a number
+func (sc *wktScanner) token() string {
+	sc.skipSpaces()
+	start := sc.pos
+	for sc.pos < len(sc.s) && !strings.ContainsRune(`[](),"`, rune(sc.s[sc.pos])) &&
+		!unicode.IsSpace(rune(sc.s[sc.pos])) {
+		sc.pos++
+	}
+	return sc.s[start:sc.pos]
+}
+
+// Parses a node. Values are quoted strings or bare tokens; anything followed by
+// brackets is a child node.
+func parseWKTNode(sc *wktScanner) (*wktNode, error) {
+	node := &wktNode{name: sc.token()}
+	if node.name == "" {
+		return nil, fmt.Errorf("Expected keyword at position %d", sc.pos)
+	}
+	open := sc.peek()
+	if open != '[' && open != '(' {
+		return nil, fmt.Errorf("Expected '[' after '%s'", node.name)
+	}
+	sc.pos++
+
+	for {
+		switch c := sc.peek(); {
+		case c == 0:
+			return nil, errors.New("Unexpected end of text")
+		case c == ']' || c == ')':
+			sc.pos++
+			return node, nil
+		case c == ',':
+			sc.pos++
+		case c == '"':
+			end := strings.IndexByte(sc.s[sc.pos+1:], '"')
+			if end < 0 {
+				return nil, errors.New("Unterminated string")
+			}
+			node.values = append(node.values, sc.s[sc.pos+1:sc.pos+1+end])
+			sc.pos += end + 2
+		default:
+			start := sc.pos
+			tok := sc.token()
+			if tok == "" {
+				return nil, fmt.Errorf("Unexpected character '%c'", c)
+			}
+			if next := sc.peek(); next == '[' || next == '(' {
+				sc.pos = start
+				child, err := parseWKTNode(sc)
+				if err != nil {
+					return nil, err
+				}
+				node.children = append(node.children, child)
+			} else {
+				node.values = append(node.values, tok)
+			}
+		}
+	}
+}
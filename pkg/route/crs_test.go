@@ -0,0 +1,94 @@
+package route
+
+import (
+	"testing"
+
+	"github.com/golang/geo/s2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	wgs84WKT = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],` +
+		`PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`
+	webMercatorWKT = `PROJCS["WGS_1984_Web_Mercator_Auxiliary_Sphere",GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",` +
+		`SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]],` +
+		`PROJECTION["Mercator_Auxiliary_Sphere"],PARAMETER["False_Easting",0.0],PARAMETER["False_Northing",0.0],` +
+		`PARAMETER["Central_Meridian",0.0],PARAMETER["Standard_Parallel_1",0.0],PARAMETER["Auxiliary_Sphere_Type",0.0],` +
+		`UNIT["Meter",1.0]]`
+	utm17NWKT = `PROJCS["WGS 84 / UTM zone 17N",GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,` +
+		`AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],` +
+		`PROJECTION["Transverse_Mercator"],PARAMETER["latitude_of_origin",0],PARAMETER["central_meridian",-81],` +
+		`PARAMETER["scale_factor",0.9996],PARAMETER["false_easting",500000],PARAMETER["false_northing",0],` +
+		`UNIT["metre",1,AUTHORITY["EPSG","9001"]],AUTHORITY["EPSG","32617"]]`
+	utm23SWKT = `PROJCS["SIRGAS_2000_UTM_Zone_23S",GEOGCS["GCS_SIRGAS_2000",DATUM["D_SIRGAS_2000",` +
+		`SPHEROID["GRS_1980",6378137.0,298.257222101]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]],` +
+		`PROJECTION["Transverse_Mercator"],PARAMETER["False_Easting",500000.0],PARAMETER["False_Northing",10000000.0],` +
+		`PARAMETER["Central_Meridian",-45.0],PARAMETER["Scale_Factor",0.9996],PARAMETER["Latitude_Of_Origin",0.0],` +
+		`UNIT["Meter",1.0]]`
+)
+
+// Tells if two lat lngs are within tolerance meters from each other
+func closeTo(a, b s2.LatLng, tolerance float64) bool {
+	return float64(a.Distance(b))*earthRadius < tolerance
+}
+
+const earthRadius = 6_371_000.0
+
+func TestParseWKT(t *testing.T) {
+	cases := map[string]struct {
+		wkt       string
+		x, y      float64
+		expected  s2.LatLng
+		tolerance float64
+	}{
+		"WGS84":             {wgs84WKT, -46.6, -23.5, s2.LatLngFromDegrees(-23.5, -46.6), 1e-6},
+		"WebMercatorOrigin": {webMercatorWKT, 0, 0, s2.LatLngFromDegrees(0, 0), 1e-6},
+		"WebMercatorEdge": {
+			webMercatorWKT, 20037508.342789244, 20037508.342789244,
+			s2.LatLngFromDegrees(85.0511287798066, 180), 1e-3,
+		},
+		// CN Tower, as given by the UTM article on Wikipedia
+		"UTMNorth": {utm17NWKT, 630084, 4833438, s2.LatLngFromDegrees(43.642567, -79.387139), 1},
+		// A point on the central meridian of zone 23S, at the equator
+		"UTMSouthEquator": {utm23SWKT, 500000, 10000000, s2.LatLngFromDegrees(0, -45), 1e-3},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			crs, err := ParseWKT(c.wkt)
+			require.NoError(t, err)
+			ll := crs.LatLng(c.x, c.y)
+			assert.True(t, closeTo(c.expected, ll, c.tolerance), "Expected: %v/Result: %v", c.expected, ll)
+		})
+	}
+}
+
+func TestUTMSouth(t *testing.T) {
+	crs, err := ParseWKT(utm23SWKT)
+	require.NoError(t, err)
+
+	// São Paulo's Praça da Sé, whose coordinates in zone 23S are roughly known
+	ll := crs.LatLng(333300, 7394500)
+	assert.InDelta(t, -23.55, ll.Lat.Degrees(), 0.01)
+	assert.InDelta(t, -46.63, ll.Lng.Degrees(), 0.01)
+}
+
+func TestParseWKTErrors(t *testing.T) {
+	for name, wkt := range map[string]string{
+		"Empty":       "",
+		"Unclosed":    `GEOGCS["WGS 84"`,
+		"Unsupported": `PROJCS["Lambert",GEOGCS["WGS 84"],PROJECTION["Lambert_Conformal_Conic"]]`,
+		"Unknown":     `VERT_CS["height"]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseWKT(wkt)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCoordinatesAxisOrder(t *testing.T) {
+	assert.True(t, s2.LatLngFromDegrees(-23.5, -46.6).ApproxEqual(Coordinates{}.latLng(-46.6, -23.5)))
+	assert.True(t, s2.LatLngFromDegrees(-23.5, -46.6).ApproxEqual(Coordinates{Axis: YXOrder}.latLng(-23.5, -46.6)))
+}
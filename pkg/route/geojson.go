@@ -0,0 +1,155 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/golang/geo/s2"
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// FeatureSelector selects a single feature of a FeatureCollection. Only one
+// criterion should be set; if none is, the first feature is selected.
+type FeatureSelector struct {
+	// Position of the feature in the collection
+	Index *int
+	// Feature's ID
+	ID interface{}
+	// Name of a property that must have Value
+	Property string
+	Value    interface{}
+}
+
+// Tells if a feature matches the selector. The feature's index in its
+// collection is given by i.
+func (sel FeatureSelector) matches(i int, ft *geojson.Feature) bool {
+	switch {
+	case sel.Index != nil:
+		return *sel.Index == i
+	case sel.ID != nil:
+		return looselyEqual(sel.ID, ft.ID)
+	case sel.Property != "":
+		val, ok := ft.Properties[sel.Property]
+		return ok && looselyEqual(sel.Value, val)
+	default:
+		return i == 0
+	}
+}
+
+// Compares two JSON values. Numbers are compared regardless of their types.
+func looselyEqual(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// FromGeoJSON reads a path from a GeoJSON document. The document may be a
+// FeatureCollection, from which a feature is picked by the selector, a Feature
+// or a bare geometry. The geometry must be a LineString or a MultiLineString,
+// whose lines are joined in order. GeoJSON positions are longitude, latitude
+// pairs, but coords may describe otherwise.
+func FromGeoJSON(rdr io.Reader, sel FeatureSelector, coords Coordinates) (*s2.Polyline, error) {
+	bs, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(bs, &doc); err != nil {
+		return nil, err
+	}
+
+	var geom *geojson.Geometry
+	switch doc.Type {
+	case "FeatureCollection":
+		fc, err := geojson.UnmarshalFeatureCollection(bs)
+		if err != nil {
+			return nil, err
+		}
+		ft, err := selectFeature(fc.Features, sel)
+		if err != nil {
+			return nil, err
+		}
+		geom = ft.Geometry
+	case "Feature":
+		ft, err := geojson.UnmarshalFeature(bs)
+		if err != nil {
+			return nil, err
+		}
+		geom = ft.Geometry
+	default:
+		if geom, err = geojson.UnmarshalGeometry(bs); err != nil {
+			return nil, err
+		}
+	}
+
+	return polylineFromGeometry(geom, coords)
+}
+
+// Picks the first feature that matches the selector
+func selectFeature(fts []*geojson.Feature, sel FeatureSelector) (*geojson.Feature, error) {
+	for i, ft := range fts {
+		if sel.matches(i, ft) {
+			return ft, nil
+		}
+	}
+	return nil, errors.New("No feature matches the selector")
+}
+
+// Converts a LineString or MultiLineString geometry to a polyline
+func polylineFromGeometry(geom *geojson.Geometry, coords Coordinates) (*s2.Polyline, error) {
+	if geom == nil {
+		return nil, errors.New("Feature has no geometry")
+	}
+
+	var lines [][][]float64
+	switch {
+	case geom.IsLineString():
+		lines = [][][]float64{geom.LineString}
+	case geom.IsMultiLineString():
+		lines = geom.MultiLineString
+	default:
+		return nil, fmt.Errorf("Geometry type must be LineString or MultiLineString, got '%s'", geom.Type)
+	}
+
+	var lls []s2.LatLng
+	for _, line := range lines {
+		for _, coord := range line {
+			if len(coord) < 2 {
+				return nil, errors.New("Coordinates must have at least 2 dimensions")
+			}
+			lls = append(lls, coords.latLng(coord[0], coord[1]))
+		}
+	}
+	if len(lls) < 2 {
+		return nil, errors.New("Path must have at least 2 points")
+	}
+
+	return s2.PolylineFromLatLngs(lls), nil
+}
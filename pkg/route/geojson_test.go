@@ -0,0 +1,87 @@
+package route
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/geo/s2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const featureCollection = `{
+	"type": "FeatureCollection",
+	"features": [
+		{
+			"type": "Feature",
+			"id": 1,
+			"properties": {"name": "first"},
+			"geometry": {"type": "LineString", "coordinates": [[0, 0], [10, 0]]}
+		},
+		{
+			"type": "Feature",
+			"id": "second",
+			"properties": {"name": "second"},
+			"geometry": {
+				"type": "MultiLineString",
+				"coordinates": [[[-46.6, -23.5], [-46.7, -23.5]], [[-46.7, -23.6], [-46.8, -23.6]]]
+			}
+		}
+	]
+}`
+
+func TestFromGeoJSON(t *testing.T) {
+	one := 1
+	cases := map[string]struct {
+		doc   string
+		sel   FeatureSelector
+		first s2.LatLng
+		len   int
+	}{
+		"FirstFeature": {featureCollection, FeatureSelector{}, s2.LatLngFromDegrees(0, 0), 2},
+		"ByIndex":      {featureCollection, FeatureSelector{Index: &one}, s2.LatLngFromDegrees(-23.5, -46.6), 4},
+		"ByID":         {featureCollection, FeatureSelector{ID: "second"}, s2.LatLngFromDegrees(-23.5, -46.6), 4},
+		"ByNumericID":  {featureCollection, FeatureSelector{ID: 1}, s2.LatLngFromDegrees(0, 0), 2},
+		"ByProperty": {
+			featureCollection,
+			FeatureSelector{Property: "name", Value: "second"},
+			s2.LatLngFromDegrees(-23.5, -46.6), 4,
+		},
+		"Feature": {
+			`{"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[1, 2], [3, 4]]}}`,
+			FeatureSelector{}, s2.LatLngFromDegrees(2, 1), 2,
+		},
+		"Geometry": {
+			`{"type": "LineString", "coordinates": [[1, 2], [3, 4], [5, 6]]}`,
+			FeatureSelector{}, s2.LatLngFromDegrees(2, 1), 3,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			pl, err := FromGeoJSON(strings.NewReader(c.doc), c.sel, Coordinates{})
+			require.NoError(t, err)
+			require.Len(t, *pl, c.len)
+			assert.True(t, c.first.ApproxEqual(s2.LatLngFromPoint((*pl)[0])))
+		})
+	}
+}
+
+func TestFromGeoJSONErrors(t *testing.T) {
+	cases := map[string]struct {
+		doc string
+		sel FeatureSelector
+	}{
+		"NoMatch":   {featureCollection, FeatureSelector{ID: "third"}},
+		"Point":     {`{"type": "Point", "coordinates": [1, 2]}`, FeatureSelector{}},
+		"OnePoint":  {`{"type": "LineString", "coordinates": [[1, 2]]}`, FeatureSelector{}},
+		"Malformed": {`{"type": `, FeatureSelector{}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := FromGeoJSON(strings.NewReader(c.doc), c.sel, Coordinates{})
+			assert.Error(t, err)
+		})
+	}
+}
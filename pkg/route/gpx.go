@@ -0,0 +1,161 @@
+package route
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+// Sample is a point of a recorded path. Elevation and time are optional, as
+// not every source has them.
+type Sample struct {
+	s2.LatLng
+	// Elevation in meters, if HasElevation
+	Elevation    float64
+	HasElevation bool
+	// Time the point was recorded. It is zero if unknown.
+	Time time.Time
+}
+
+// Track is a sequence of recorded points
+type Track []Sample
+
+// Polyline returns the track's path
+func (trk Track) Polyline() *s2.Polyline {
+	lls := make([]s2.LatLng, len(trk))
+	for i, smp := range trk {
+		lls[i] = smp.LatLng
+	}
+	return s2.PolylineFromLatLngs(lls)
+}
+
+// GPXSelector selects which path of a GPX file is read. If nothing is set, the
+// first track is read or, if there are no tracks, the first route.
+type GPXSelector struct {
+	// Name of a track or route
+	Name string
+	// Position of the track in the file
+	Track *int
+	// Position of the segment in the track. If not set, all segments are
+	// joined in order.
+	Segment *int
+	// Position of the route in the file
+	Route *int
+}
+
+type gpxDoc struct {
+	Tracks []struct {
+		Name     string `xml:"name"`
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Name   string     `xml:"name"`
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+type gpxPoint struct {
+	Lat  float64  `xml:"lat,attr"`
+	Lon  float64  `xml:"lon,attr"`
+	Ele  *float64 `xml:"ele"`
+	Time string   `xml:"time"`
+}
+
+// Converts a GPX point to a sample
+func (pt gpxPoint) sample() (Sample, error) {
+	smp := Sample{LatLng: s2.LatLngFromDegrees(pt.Lat, pt.Lon)}
+	if pt.Ele != nil {
+		smp.Elevation, smp.HasElevation = *pt.Ele, true
+	}
+	if pt.Time != "" {
+		t, err := time.Parse(time.RFC3339Nano, pt.Time)
+		if err != nil {
+			return Sample{}, fmt.Errorf("Invalid point time '%s': %w", pt.Time, err)
+		}
+		smp.Time = t
+	}
+	return smp, nil
+}
+
+// FromGPX reads a track or a route from a GPX document
+func FromGPX(rdr io.Reader, sel GPXSelector) (Track, error) {
+	var doc gpxDoc
+	if err := xml.NewDecoder(rdr).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	pts, err := doc.selectPoints(sel)
+	if err != nil {
+		return nil, err
+	}
+	if len(pts) < 2 {
+		return nil, errors.New("Path must have at least 2 points")
+	}
+
+	trk := make(Track, len(pts))
+	for i, pt := range pts {
+		if trk[i], err = pt.sample(); err != nil {
+			return nil, err
+		}
+	}
+	return trk, nil
+}
+
+// Picks the points of the selected track or route
+func (doc gpxDoc) selectPoints(sel GPXSelector) ([]gpxPoint, error) {
+	if sel.Route != nil || (sel.Track == nil && sel.Name == "" && len(doc.Tracks) == 0) {
+		return doc.routePoints(sel)
+	}
+	pts, err := doc.trackPoints(sel)
+	if err != nil && sel.Track == nil && sel.Name != "" {
+		// The name may refer to a route
+		if pts, rerr := doc.routePoints(sel); rerr == nil {
+			return pts, nil
+		}
+	}
+	return pts, err
+}
+
+func (doc gpxDoc) trackPoints(sel GPXSelector) ([]gpxPoint, error) {
+	ti := -1
+	for i, trk := range doc.Tracks {
+		if (sel.Track != nil && *sel.Track == i) ||
+			(sel.Track == nil && (sel.Name == "" || sel.Name == trk.Name)) {
+			ti = i
+			break
+		}
+	}
+	if ti < 0 {
+		return nil, errors.New("No track matches the selector")
+	}
+
+	segs := doc.Tracks[ti].Segments
+	if sel.Segment != nil {
+		if *sel.Segment < 0 || *sel.Segment >= len(segs) {
+			return nil, fmt.Errorf("Track has no segment %d", *sel.Segment)
+		}
+		return segs[*sel.Segment].Points, nil
+	}
+
+	var pts []gpxPoint
+	for _, seg := range segs {
+		pts = append(pts, seg.Points...)
+	}
+	return pts, nil
+}
+
+func (doc gpxDoc) routePoints(sel GPXSelector) ([]gpxPoint, error) {
+	for i, rte := range doc.Routes {
+		if (sel.Route != nil && *sel.Route == i) ||
+			(sel.Route == nil && (sel.Name == "" || sel.Name == rte.Name)) {
+			return rte.Points, nil
+		}
+	}
+	return nil, errors.New("No route matches the selector")
+}
@@ -0,0 +1,81 @@
+package route
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const gpxDocument = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+	<trk>
+		<name>Morning drive</name>
+		<trkseg>
+			<trkpt lat="-23.5" lon="-46.6"><ele>760.5</ele><time>2020-10-01T08:00:00Z</time></trkpt>
+			<trkpt lat="-23.6" lon="-46.6"><ele>761</ele><time>2020-10-01T08:00:10Z</time></trkpt>
+		</trkseg>
+		<trkseg>
+			<trkpt lat="-23.7" lon="-46.6"><time>2020-10-01T08:01:00Z</time></trkpt>
+			<trkpt lat="-23.8" lon="-46.6"><time>2020-10-01T08:01:10Z</time></trkpt>
+		</trkseg>
+	</trk>
+	<rte>
+		<name>Planned</name>
+		<rtept lat="1" lon="2"></rtept>
+		<rtept lat="3" lon="4"></rtept>
+		<rtept lat="5" lon="6"></rtept>
+	</rte>
+</gpx>`
+
+func TestFromGPX(t *testing.T) {
+	zero, one := 0, 1
+	cases := map[string]struct {
+		sel   GPXSelector
+		first s2.LatLng
+		len   int
+	}{
+		"FirstTrack":  {GPXSelector{}, s2.LatLngFromDegrees(-23.5, -46.6), 4},
+		"Segment":     {GPXSelector{Track: &zero, Segment: &one}, s2.LatLngFromDegrees(-23.7, -46.6), 2},
+		"TrackByName": {GPXSelector{Name: "Morning drive"}, s2.LatLngFromDegrees(-23.5, -46.6), 4},
+		"Route":       {GPXSelector{Route: &zero}, s2.LatLngFromDegrees(1, 2), 3},
+		"RouteByName": {GPXSelector{Name: "Planned"}, s2.LatLngFromDegrees(1, 2), 3},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			trk, err := FromGPX(strings.NewReader(gpxDocument), c.sel)
+			require.NoError(t, err)
+			require.Len(t, trk, c.len)
+			assert.True(t, c.first.ApproxEqual(trk[0].LatLng))
+			assert.Len(t, *trk.Polyline(), c.len)
+		})
+	}
+}
+
+func TestFromGPXSampleData(t *testing.T) {
+	trk, err := FromGPX(strings.NewReader(gpxDocument), GPXSelector{})
+	require.NoError(t, err)
+
+	assert.True(t, trk[0].HasElevation)
+	assert.Equal(t, 760.5, trk[0].Elevation)
+	assert.Equal(t, time.Date(2020, 10, 1, 8, 0, 10, 0, time.UTC), trk[1].Time)
+	assert.False(t, trk[2].HasElevation)
+}
+
+func TestFromGPXErrors(t *testing.T) {
+	two := 2
+	for name, sel := range map[string]GPXSelector{
+		"UnknownName":    {Name: "Evening drive"},
+		"UnknownSegment": {Segment: &two},
+		"UnknownRoute":   {Route: &two},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := FromGPX(strings.NewReader(gpxDocument), sel)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package route
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+// Longest line of an NDJSON log
+const maxNDJSONLine = 1 << 20
+
+// Recording is the track of a device read from a position log
+type Recording struct {
+	// ID of the recorded device. It is empty if the log has no IDs.
+	ID    string
+	Track Track
+}
+
+// FromNDJSON reads the recordings of a log with a JSON object per line, such
+// as the structured output of routesim's log publisher. Objects must have
+// "time" (RFC 3339), "lat" and "lng" (or "lon") fields, and may have an "id"
+// field; other fields and blank lines are ignored. Recordings are ordered by
+// their first line, and their samples by time.
+func FromNDJSON(rdr io.Reader) ([]Recording, error) {
+	var recs recordings
+	scanner := bufio.NewScanner(rdr)
+	scanner.Buffer(nil, maxNDJSONLine)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var obj struct {
+			ID   string    `json:"id"`
+			Time time.Time `json:"time"`
+			Lat  *float64  `json:"lat"`
+			Lng  *float64  `json:"lng"`
+			Lon  *float64  `json:"lon"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &obj); err != nil {
+			return nil, fmt.Errorf("Invalid line %d: %w", line, err)
+		}
+		if obj.Lng == nil {
+			obj.Lng = obj.Lon
+		}
+		if obj.Time.IsZero() || obj.Lat == nil || obj.Lng == nil {
+			return nil, fmt.Errorf("Line %d must have time, lat and lng fields", line)
+		}
+		recs.add(obj.ID, Sample{LatLng: s2.LatLngFromDegrees(*obj.Lat, *obj.Lng), Time: obj.Time})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return recs.list()
+}
+
+// FromCSV reads the recordings of a CSV log whose first record names its
+// columns, such as the output of routesim's CSV formatter. It must have "time"
+// (RFC 3339) or "unix" (Unix milliseconds), "lat" and "lng" (or "lon")
+// columns, and may have an "id" column; other columns are ignored.
+// Recordings are ordered by their first record, and their samples by time.
+func FromCSV(rdr io.Reader) ([]Recording, error) {
+	csvrdr := csv.NewReader(rdr)
+	header, err := csvrdr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading header: %w", err)
+	}
+	cols := map[string]int{}
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := cols["lng"]; !ok {
+		if i, ok := cols["lon"]; ok {
+			cols["lng"] = i
+		}
+	}
+	_, hasTime := cols["time"]
+	_, hasUnix := cols["unix"]
+	_, hasLat := cols["lat"]
+	_, hasLng := cols["lng"]
+	if !(hasTime || hasUnix) || !hasLat || !hasLng {
+		return nil, errors.New("CSV must have time (or unix), lat and lng columns")
+	}
+
+	var recs recordings
+	for n := 1; ; n++ {
+		rec, err := csvrdr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		smp, err := csvSample(rec, cols, hasTime)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid record %d: %w", n, err)
+		}
+		var id string
+		if i, ok := cols["id"]; ok {
+			id = rec[i]
+		}
+		recs.add(id, smp)
+	}
+	return recs.list()
+}
+
+// Reads a sample from a CSV record
+func csvSample(rec []string, cols map[string]int, hasTime bool) (Sample, error) {
+	lat, err := strconv.ParseFloat(rec[cols["lat"]], 64)
+	if err != nil {
+		return Sample{}, err
+	}
+	lng, err := strconv.ParseFloat(rec[cols["lng"]], 64)
+	if err != nil {
+		return Sample{}, err
+	}
+
+	var t time.Time
+	if hasTime {
+		if t, err = time.Parse(time.RFC3339Nano, rec[cols["time"]]); err != nil {
+			return Sample{}, err
+		}
+	} else {
+		ms, err := strconv.ParseInt(rec[cols["unix"]], 10, 64)
+		if err != nil {
+			return Sample{}, err
+		}
+		t = time.Unix(0, ms*int64(time.Millisecond)).UTC()
+	}
+	return Sample{LatLng: s2.LatLngFromDegrees(lat, lng), Time: t}, nil
+}
+
+// Groups samples by device ID, in order of appearance
+type recordings struct {
+	ids    []string
+	tracks map[string]Track
+}
+
+func (recs *recordings) add(id string, smp Sample) {
+	if recs.tracks == nil {
+		recs.tracks = map[string]Track{}
+	}
+	if _, ok := recs.tracks[id]; !ok {
+		recs.ids = append(recs.ids, id)
+	}
+	recs.tracks[id] = append(recs.tracks[id], smp)
+}
+
+func (recs *recordings) list() ([]Recording, error) {
+	if len(recs.ids) == 0 {
+		return nil, errors.New("Log has no samples")
+	}
+	list := make([]Recording, len(recs.ids))
+	for i, id := range recs.ids {
+		trk := recs.tracks[id]
+		sort.SliceStable(trk, func(i, j int) bool { return trk[i].Time.Before(trk[j].Time) })
+		list[i] = Recording{ID: id, Track: trk}
+	}
+	return list, nil
+}
@@ -0,0 +1,68 @@
+package route
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromNDJSON(t *testing.T) {
+	recs, err := FromNDJSON(strings.NewReader(`
+{"time":"2020-10-01T08:00:10Z","level":"info","id":"bus-1","lat":-23.6,"lng":-46.6}
+{"time":"2020-10-01T08:00:00Z","level":"info","id":"bus-1","lat":-23.5,"lng":-46.6,"metadata":{"line":8000}}
+
+{"time":"2020-10-01T08:00:05Z","id":"bus-2","lat":1,"lon":2}
+`))
+	require.NoError(t, err)
+	require.Len(t, recs, 2)
+
+	assert.Equal(t, "bus-1", recs[0].ID)
+	require.Len(t, recs[0].Track, 2)
+	assert.InDelta(t, -23.5, recs[0].Track[0].Lat.Degrees(), 1e-9)
+	assert.Equal(t, time.Date(2020, 10, 1, 8, 0, 0, 0, time.UTC), recs[0].Track[0].Time.UTC())
+	assert.Equal(t, "bus-2", recs[1].ID)
+	assert.InDelta(t, 2, recs[1].Track[0].Lng.Degrees(), 1e-9)
+
+	for name, raw := range map[string]string{
+		"Empty":   "",
+		"Invalid": `{"time":`,
+		"NoTime":  `{"id":"bus-1","lat":1,"lng":2}`,
+		"NoLng":   `{"time":"2020-10-01T08:00:00Z","lat":1}`,
+	} {
+		_, err := FromNDJSON(strings.NewReader(raw))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestFromCSV(t *testing.T) {
+	recs, err := FromCSV(strings.NewReader(`id,time,lat,lng,speed
+bus-1,2020-10-01T08:00:00Z,-23.5,-46.6,10
+bus-1,2020-10-01T08:00:10.5Z,-23.6,-46.6,10
+`))
+	require.NoError(t, err)
+	require.Len(t, recs, 1)
+	assert.Equal(t, "bus-1", recs[0].ID)
+	require.Len(t, recs[0].Track, 2)
+	assert.Equal(t, time.Date(2020, 10, 1, 8, 0, 10, 5e8, time.UTC), recs[0].Track[1].Time)
+
+	recs, err = FromCSV(strings.NewReader("unix,LAT,lon\n1601539200000,1,2\n1601539201000,3,4\n"))
+	require.NoError(t, err)
+	require.Len(t, recs, 1)
+	assert.Equal(t, "", recs[0].ID)
+	assert.Equal(t, time.Date(2020, 10, 1, 8, 0, 0, 0, time.UTC), recs[0].Track[0].Time)
+	assert.InDelta(t, 4, recs[0].Track[1].Lng.Degrees(), 1e-9)
+
+	for name, raw := range map[string]string{
+		"Empty":     "",
+		"NoTime":    "id,lat,lng\nbus-1,1,2\n",
+		"BadLat":    "time,lat,lng\n2020-10-01T08:00:00Z,north,2\n",
+		"BadTime":   "time,lat,lng\nyesterday,1,2\n",
+		"NoRecords": "time,lat,lng\n",
+	} {
+		_, err := FromCSV(strings.NewReader(raw))
+		assert.Error(t, err, name)
+	}
+}
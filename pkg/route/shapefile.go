@@ -0,0 +1,209 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang/geo/s2"
+	"github.com/jonas-p/go-shp"
+)
+
+// ShapeSelector selects features of a shapefile. If no criterion is set, the
+// first feature is selected.
+type ShapeSelector struct {
+	// Selects every feature that matches the other criteria, instead of only
+	// the first one
+	All bool
+	// Position of the feature in the file
+	Index *int
+	// Name of a DBF attribute that must have Value
+	Attribute string
+	Value     string
+	// Position of the part of a multi-part polyline. If not set, parts are
+	// chained into a single line.
+	Part *int
+}
+
+// Tells if a feature matches the selector. The feature's index in the file is
+// given by i; attr returns the value of a DBF attribute.
+func (sel ShapeSelector) matches(i int, attr func(string) (string, bool)) bool {
+	switch {
+	case sel.Index != nil:
+		return *sel.Index == i
+	case sel.Attribute != "":
+		val, ok := attr(sel.Attribute)
+		return ok && val == sel.Value
+	default:
+		return sel.All || i == 0
+	}
+}
+
+// FromShapefile reads the selected polyline features of a shapefile. Each
+// feature becomes a path. The shapefile's coordinates are interpreted as
+// described by coords, which usually comes from its .prj file.
+func FromShapefile(rdr *shp.Reader, sel ShapeSelector, coords Coordinates) ([]*s2.Polyline, error) {
+	switch rdr.GeometryType {
+	case shp.POLYLINE, shp.POLYLINEZ, shp.POLYLINEM:
+	default:
+		return nil, errors.New("Geometry type must be POLYLINE")
+	}
+
+	var fieldIdx map[string]int
+	attr := func(name string) (string, bool) {
+		if fieldIdx == nil {
+			fieldIdx = map[string]int{}
+			for i, f := range rdr.Fields() {
+				fieldIdx[f.String()] = i
+			}
+		}
+		i, ok := fieldIdx[name]
+		if !ok {
+			return "", false
+		}
+		// Some writers pad values with NUL instead of spaces
+		return strings.Trim(rdr.Attribute(i), "\x00 "), true
+	}
+
+	var paths []*s2.Polyline
+	for rdr.Next() {
+		i, shape := rdr.Shape()
+		if !sel.matches(i, attr) {
+			continue
+		}
+
+		parts := shapeParts(shape)
+		if len(parts) == 0 {
+			continue
+		}
+		pl, err := polylineFromParts(parts, sel.Part, coords)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading feature %d: %w", i, err)
+		}
+		paths = append(paths, pl)
+
+		if !sel.All {
+			break
+		}
+	}
+	if err := rdr.Err(); err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("No feature matches the selector")
+	}
+
+	return paths, nil
+}
+
+// Splits a polyline shape into its parts
+func shapeParts(shape shp.Shape) [][]shp.Point {
+	var (
+		partIdxs []int32
+		pts      []shp.Point
+	)
+	switch pl := shape.(type) {
+	case *shp.PolyLine:
+		partIdxs, pts = pl.Parts, pl.Points
+	case *shp.PolyLineZ:
+		partIdxs, pts = pl.Parts, pl.Points
+	case *shp.PolyLineM:
+		partIdxs, pts = pl.Parts, pl.Points
+	default:
+		// Null shapes, for instance, have no parts
+		return nil
+	}
+
+	parts := make([][]shp.Point, len(partIdxs))
+	for i, start := range partIdxs {
+		end := int32(len(pts))
+		if i+1 < len(partIdxs) {
+			end = partIdxs[i+1]
+		}
+		parts[i] = pts[start:end]
+	}
+	return parts
+}
+
+// Builds a polyline from a part of a shape or, if no part is given, from all
+// its parts chained together.
+func polylineFromParts(parts [][]shp.Point, part *int, coords Coordinates) (*s2.Polyline, error) {
+	lparts := make([][]s2.LatLng, len(parts))
+	for i, pts := range parts {
+		lparts[i] = make([]s2.LatLng, len(pts))
+		for j, pt := range pts {
+			lparts[i][j] = coords.latLng(pt.X, pt.Y)
+		}
+	}
+
+	var lls []s2.LatLng
+	if part != nil {
+		if *part < 0 || *part >= len(lparts) {
+			return nil, fmt.Errorf("Shape has no part %d", *part)
+		}
+		lls = lparts[*part]
+	} else {
+		lls = chainParts(lparts)
+	}
+	if len(lls) < 2 {
+		return nil, errors.New("Path must have at least 2 points")
+	}
+
+	return s2.PolylineFromLatLngs(lls), nil
+}
+
+// Chains line parts into a single line. Parts are kept in order, but each one
+// is reversed if its end is closer than its start to the line built so far, so
+// the line doesn't jump back and forth between parts. Points shared by
+// consecutive parts aren't repeated.
+func chainParts(parts [][]s2.LatLng) []s2.LatLng {
+	var (
+		line  []s2.LatLng
+		first = true
+	)
+	for _, part := range parts {
+		if len(part) == 0 {
+			continue
+		}
+		if len(line) == 0 {
+			line = append(line, part...)
+			continue
+		}
+
+		if first {
+			// The first part may be reversed as well, if the second part is
+			// closer to its start
+			if minDistance(line[0], part) < minDistance(line[len(line)-1], part) {
+				line = reversed(line)
+			}
+			first = false
+		}
+
+		end := line[len(line)-1]
+		if end.Distance(part[len(part)-1]) < end.Distance(part[0]) {
+			part = reversed(part)
+		}
+		if end.ApproxEqual(part[0]) {
+			part = part[1:]
+		}
+		line = append(line, part...)
+	}
+	return line
+}
+
+// Smallest distance between a point and a part's ends
+func minDistance(ll s2.LatLng, part []s2.LatLng) float64 {
+	ds, de := ll.Distance(part[0]), ll.Distance(part[len(part)-1])
+	if ds < de {
+		return float64(ds)
+	}
+	return float64(de)
+}
+
+func reversed(lls []s2.LatLng) []s2.LatLng {
+	rev := make([]s2.LatLng, len(lls))
+	for i, ll := range lls {
+		rev[len(lls)-1-i] = ll
+	}
+	return rev
+}
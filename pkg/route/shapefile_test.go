@@ -0,0 +1,105 @@
+package route
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/geo/s2"
+	"github.com/jonas-p/go-shp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Writes a shapefile with three lines, named after their DBF "NAME" attribute,
+// with X as longitude and Y as latitude. The last one has two parts, the second
+// one drawn in reverse.
+func testShapefile(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "routesim")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "lines.shp")
+	wtr, err := shp.Create(path, shp.POLYLINE)
+	require.NoError(t, err)
+	require.NoError(t, wtr.SetFields([]shp.Field{shp.StringField("NAME", 16)}))
+
+	lines := []struct {
+		name  string
+		parts [][]shp.Point
+	}{
+		{"first", [][]shp.Point{{{X: 0, Y: 0}, {X: 0, Y: 1}}}},
+		{"second", [][]shp.Point{{{X: 10, Y: 0}, {X: 10, Y: 1}}}},
+		{"third", [][]shp.Point{
+			{{X: 20, Y: 0}, {X: 20, Y: 1}},
+			{{X: 20, Y: 3}, {X: 20, Y: 2}, {X: 20, Y: 1}},
+		}},
+	}
+	for i, line := range lines {
+		wtr.Write(shp.NewPolyLine(line.parts))
+		require.NoError(t, wtr.WriteAttribute(i, 0, line.name))
+	}
+	wtr.Close()
+
+	return path
+}
+
+func TestFromShapefile(t *testing.T) {
+	path := testShapefile(t)
+	one, zero := 1, 0
+
+	cases := map[string]struct {
+		sel    ShapeSelector
+		firsts []s2.LatLng
+		lens   []int
+	}{
+		"First":      {ShapeSelector{}, []s2.LatLng{s2.LatLngFromDegrees(0, 0)}, []int{2}},
+		"ByIndex":    {ShapeSelector{Index: &one}, []s2.LatLng{s2.LatLngFromDegrees(0, 10)}, []int{2}},
+		"ByAttr":     {ShapeSelector{Attribute: "NAME", Value: "second"}, []s2.LatLng{s2.LatLngFromDegrees(0, 10)}, []int{2}},
+		"Chained":    {ShapeSelector{Attribute: "NAME", Value: "third"}, []s2.LatLng{s2.LatLngFromDegrees(0, 20)}, []int{4}},
+		"Part":       {ShapeSelector{Index: &one, Part: &zero}, []s2.LatLng{s2.LatLngFromDegrees(0, 10)}, []int{2}},
+		"SecondPart": {ShapeSelector{Attribute: "NAME", Value: "third", Part: &one}, []s2.LatLng{s2.LatLngFromDegrees(3, 20)}, []int{3}},
+		"All": {
+			ShapeSelector{All: true},
+			[]s2.LatLng{s2.LatLngFromDegrees(0, 0), s2.LatLngFromDegrees(0, 10), s2.LatLngFromDegrees(0, 20)},
+			[]int{2, 2, 4},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			rdr, err := shp.Open(path)
+			require.NoError(t, err)
+			defer rdr.Close()
+
+			paths, err := FromShapefile(rdr, c.sel, Coordinates{})
+			require.NoError(t, err)
+			require.Len(t, paths, len(c.firsts))
+			for i, pl := range paths {
+				assert.Len(t, *pl, c.lens[i])
+				assert.True(t, c.firsts[i].ApproxEqual(s2.LatLngFromPoint((*pl)[0])))
+			}
+		})
+	}
+}
+
+func TestFromShapefileYXOrder(t *testing.T) {
+	rdr, err := shp.Open(testShapefile(t))
+	require.NoError(t, err)
+	defer rdr.Close()
+
+	paths, err := FromShapefile(rdr, ShapeSelector{Attribute: "NAME", Value: "second"}, Coordinates{Axis: YXOrder})
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+	assert.True(t, s2.LatLngFromDegrees(10, 0).ApproxEqual(s2.LatLngFromPoint((*paths[0])[0])))
+}
+
+func TestFromShapefileNoMatch(t *testing.T) {
+	rdr, err := shp.Open(testShapefile(t))
+	require.NoError(t, err)
+	defer rdr.Close()
+
+	_, err = FromShapefile(rdr, ShapeSelector{Attribute: "NAME", Value: "fourth"}, Coordinates{})
+	assert.Error(t, err)
+}
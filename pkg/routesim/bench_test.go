@@ -0,0 +1,173 @@
+//go:build !windows
+// +build !windows
+
+package routesim
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/clock"
+	"github.com/gpontesss/routesim/pkg/data"
+	"github.com/gpontesss/routesim/pkg/gps"
+	"github.com/gpontesss/routesim/pkg/gps/gpstest"
+)
+
+// Returns a wall clock ticker that ticks n times
+func limitedTicker(period time.Duration, n int) clock.Ticker {
+	return &limitTicker{Ticker: clock.Real().NewTicker(period), left: n}
+}
+
+// Wall clock whose tickers tick n times
+type limitedClock int
+
+func (limitedClock) Now() time.Time { return time.Now() }
+
+func (n limitedClock) NewTicker(period time.Duration) clock.Ticker {
+	return limitedTicker(period, int(n))
+}
+
+type limitTicker struct {
+	clock.Ticker
+	left int
+}
+
+func (t *limitTicker) Next() (time.Time, bool) {
+	if t.left <= 0 {
+		t.Stop()
+		return time.Time{}, false
+	}
+	t.left--
+	return t.Ticker.Next()
+}
+
+// Returns the CPU time, user and system, used by the process so far
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &ru)
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// Emitters that tick every period, ticks times, each with a distinct GPS
+func wallEmitters(n, ticks int, period time.Duration) []Emitter {
+	emts := make([]Emitter, n)
+	for i := range emts {
+		gpz := gpstest.TestGPS(strconv.Itoa(i), make([]s2.LatLng, ticks)...)
+		emts[i] = FreqEmitterWithTicker(gpz, limitedTicker(period, ticks))
+	}
+	return emts
+}
+
+// Measures the CPU used while waiting for ticks. It is reported as a share of
+// the wall time, which must stay near zero; a goroutine polling in a loop
+// would take a whole core.
+func BenchmarkRouteSimIdle(b *testing.B) {
+	pub := data.PosPublisherFunc(func(gps.Position) error { return nil })
+	var cpu, wall time.Duration
+	for i := 0; i < b.N; i++ {
+		sim := NewRouteSim(wallEmitters(10, 5, 20*time.Millisecond), pub)
+		start, startCPU := time.Now(), cpuTime()
+		if err := sim.Run(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+		cpu += cpuTime() - startCPU
+		wall += time.Since(start)
+	}
+	share := float64(cpu) / float64(wall) * 100
+	b.ReportMetric(share, "cpu-%")
+	if share > 5 {
+		b.Errorf("Used %.1f%% of CPU while idle", share)
+	}
+}
+
+// Runs many emitters that tick simultaneously. Every one of them must have
+// all its positions published, and the worst delay between a position being
+// taken and published must stay within a few ticks, so no emitter starves.
+func BenchmarkRouteSimManyEmitters(b *testing.B) {
+	const n, ticks, period = 10000, 3, 50 * time.Millisecond
+	const maxAllowedDelay = 3 * period
+	var maxDelay time.Duration
+	for i := 0; i < b.N; i++ {
+		counts := make(map[string]int, n)
+		pub := data.PosPublisherFunc(func(pos gps.Position) error {
+			counts[pos.GPS.ID()]++
+			if delay := time.Since(pos.At); delay > maxDelay {
+				maxDelay = delay
+			}
+			return nil
+		})
+
+		sim := NewRouteSim(wallEmitters(n, ticks, period), pub)
+		if err := sim.Run(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+		if len(counts) != n {
+			b.Fatalf("Only %d of %d emitters were published", len(counts), n)
+		}
+		for id, count := range counts {
+			if count != ticks {
+				b.Fatalf("Emitter %q was published %d times", id, count)
+			}
+		}
+	}
+	b.ReportMetric(float64(maxDelay)/float64(time.Millisecond), "max-delay-ms")
+	if maxDelay > maxAllowedDelay {
+		b.Errorf("Positions were published up to %v after being taken", maxDelay)
+	}
+}
+
+// Compares a FreqEmitter per GPS with a single scheduler, emitting every GPS's
+// position a few times. CPU time and the number of goroutines while running
+// are reported.
+func BenchmarkEmitters(b *testing.B) {
+	const ticks, period = 3, 100 * time.Millisecond
+	modes := map[string]func(n int) []Emitter{
+		"FreqEmitter": func(n int) []Emitter {
+			return wallEmitters(n, ticks, period)
+		},
+		"Scheduler": func(n int) []Emitter {
+			sched := NewScheduler(limitedClock(ticks), period)
+			for i := 0; i < n; i++ {
+				if err := sched.Add(gpstest.TestGPS(strconv.Itoa(i), make([]s2.LatLng, ticks)...), period); err != nil {
+					b.Fatal(err)
+				}
+			}
+			return []Emitter{sched}
+		},
+	}
+
+	for _, mode := range []string{"FreqEmitter", "Scheduler"} {
+		for _, n := range []int{1000, 10000, 100000} {
+			if mode == "FreqEmitter" && n > 10000 && testing.Short() {
+				continue
+			}
+			b.Run(fmt.Sprintf("%s/%d", mode, n), func(b *testing.B) {
+				var cpu time.Duration
+				goroutines := 0
+				for i := 0; i < b.N; i++ {
+					count := 0
+					pub := data.PosPublisherFunc(func(gps.Position) error {
+						if count++; count == n {
+							goroutines = runtime.NumGoroutine()
+						}
+						return nil
+					})
+					sim := NewRouteSim(modes[mode](n), pub)
+					startCPU := cpuTime()
+					if err := sim.Run(context.Background()); err != nil {
+						b.Fatal(err)
+					}
+					cpu += cpuTime() - startCPU
+				}
+				b.ReportMetric(float64(cpu)/float64(b.N)/float64(time.Millisecond), "cpu-ms/op")
+				b.ReportMetric(float64(goroutines), "goroutines")
+			})
+		}
+	}
+}
@@ -1,26 +1,44 @@
 package routesim
 
 import (
+	"sync"
 	"time"
 
+	"github.com/gpontesss/routesim/pkg/clock"
 	"github.com/gpontesss/routesim/pkg/gps"
 )
 
-// FreqEmitter makes a channel available that receives latlng positions
+// Emitter emits GPS positions into a RouteSim
+type Emitter interface {
+	// Emit sends positions to out until the emitter stops. It blocks, and an
+	// emitter emits only once.
+	Emit(out chan<- gps.Position)
+	// Stop stops the emitter
+	Stop()
+}
+
+// FreqEmitter emits a GPS's positions with a frequency. It starts emitting
+// when its positions are first requested, either through Positions or by a
+// RouteSim.
 type FreqEmitter struct {
 	curPosFunc func() gps.Position
+	ticker     clock.Ticker
 	posChan    chan gps.Position
+	done       chan struct{}
+	startOnce  sync.Once
+	posOnce    sync.Once
+	stopOnce   sync.Once
 }
 
-// FreqEmitterWithTicker docs here
-func FreqEmitterWithTicker(gpz gps.GPS, ticker *time.Ticker) *FreqEmitter {
-	emt := &FreqEmitter{
+// FreqEmitterWithTicker creates an emitter that queries the GPS's position at
+// every tick. The positions channel is closed when the ticker stops.
+func FreqEmitterWithTicker(gpz gps.GPS, ticker clock.Ticker) *FreqEmitter {
+	return &FreqEmitter{
 		curPosFunc: gpz.CurrentPos,
-		// Should it be buffered?
-		posChan: make(chan gps.Position),
+		posChan:    make(chan gps.Position),
+		ticker:     ticker,
+		done:       make(chan struct{}),
 	}
-	emt.init(ticker)
-	return emt
 }
 
 // NewFreqEmitter assembles a GPS position emitter
@@ -28,19 +46,54 @@ func NewFreqEmitter(gps gps.GPS, freq time.Duration) *FreqEmitter {
 	return FreqEmitterWithTicker(gps, tickerFunc(freq))
 }
 
+// FreqEmitterWithClock assembles a GPS position emitter whose frequency is
+// measured in a simulation clock's time
+func FreqEmitterWithClock(gps gps.GPS, freq time.Duration, clk clock.Clock) *FreqEmitter {
+	return FreqEmitterWithTicker(gps, clk.NewTicker(freq))
+}
+
 // Creates a ticker with a duration
-var tickerFunc = time.NewTicker
+var tickerFunc = clock.Real().NewTicker
 
-// Initializes a goroutine for querying GPS's position with desired frequency
-func (emt *FreqEmitter) init(ticker *time.Ticker) {
-	go func() {
-		for range ticker.C {
-			emt.posChan <- emt.curPosFunc()
+// Emit queries the GPS's position at every tick and sends it to out. It blocks
+// until the emitter stops. An emitter runs only once; later calls return
+// immediately.
+func (emt *FreqEmitter) Emit(out chan<- gps.Position) {
+	started := false
+	emt.startOnce.Do(func() { started = true })
+	if !started {
+		return
+	}
+	for {
+		if _, ok := emt.ticker.Next(); !ok {
+			return
 		}
-	}()
+		select {
+		case out <- emt.curPosFunc():
+		case <-emt.done:
+			return
+		}
+	}
 }
 
-// Positions returns a channel that receives positions with desired frequency
+// Positions returns a channel that receives positions with desired frequency.
+// It is closed when the emitter stops. If the emitter already feeds a
+// RouteSim, the channel is closed right away.
 func (emt *FreqEmitter) Positions() <-chan gps.Position {
+	emt.posOnce.Do(func() {
+		go func() {
+			defer close(emt.posChan)
+			emt.Emit(emt.posChan)
+		}()
+	})
 	return emt.posChan
 }
+
+// Stop stops the emitter. Its positions channel is closed once a position
+// that may be pending is dropped.
+func (emt *FreqEmitter) Stop() {
+	emt.stopOnce.Do(func() {
+		close(emt.done)
+		emt.ticker.Stop()
+	})
+}
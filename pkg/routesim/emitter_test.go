@@ -1,35 +1,49 @@
 package routesim
 
 import (
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/clock"
 	"github.com/gpontesss/routesim/pkg/gps/gpstest"
 	"github.com/stretchr/testify/assert"
 )
 
 // Ticker returns a ticker that ticks n times
-func Ticker(n int) *time.Ticker {
-	tickc := make(chan time.Time)
-	go func() {
-		for i := 0; i < n; i++ {
-			tickc <- time.Time{}
-		}
-		close(tickc)
-	}()
-	return &time.Ticker{
-		C: tickc,
-	}
+func Ticker(n int) clock.Ticker {
+	return &countTicker{left: n}
 }
 
 // TickerFunc returns a function that returns a ticker that ticks n times
-func TickerFunc(n int) func(time.Duration) *time.Ticker {
-	return func(_ time.Duration) *time.Ticker {
+func TickerFunc(n int) func(time.Duration) clock.Ticker {
+	return func(_ time.Duration) clock.Ticker {
 		return Ticker(n)
 	}
 }
 
+type countTicker struct {
+	sync.Mutex
+	left int
+}
+
+func (t *countTicker) Next() (time.Time, bool) {
+	t.Lock()
+	defer t.Unlock()
+	if t.left <= 0 {
+		return time.Time{}, false
+	}
+	t.left--
+	return time.Time{}, true
+}
+
+func (t *countTicker) Stop() {
+	t.Lock()
+	defer t.Unlock()
+	t.left = 0
+}
+
 func TestFreqEmitter(t *testing.T) {
 	posl := []s2.LatLng{
 		s2.LatLngFromDegrees(0, 0),
@@ -45,4 +59,6 @@ func TestFreqEmitter(t *testing.T) {
 	for _, pos := range posl {
 		assert.True(t, pos.ApproxEqual((<-emt.Positions()).LatLng))
 	}
+	_, ok := <-emt.Positions()
+	assert.False(t, ok)
 }
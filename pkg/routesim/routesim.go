@@ -1,34 +1,100 @@
 package routesim
 
 import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
 	"github.com/gpontesss/routesim/pkg/data"
+	"github.com/gpontesss/routesim/pkg/gps"
 )
 
 // RouteSim ingests and publishes simulated GPS position emmitions
 type RouteSim struct {
-	emitters  []*FreqEmitter
+	emitters  []Emitter
 	publisher data.PosPublisher
+	closers   []io.Closer
 }
 
 // NewRouteSim builds a RouteSim
-func NewRouteSim(ems []*FreqEmitter, pub data.PosPublisher) *RouteSim {
+func NewRouteSim(ems []Emitter, pub data.PosPublisher) *RouteSim {
 	return &RouteSim{
 		emitters:  ems,
 		publisher: pub,
 	}
 }
 
-// Run starts RouteSim ingestion and publishing. It stops if any error occurs.
-func (sim *RouteSim) Run() error {
+// CloseOnStop registers a resource to be closed once Run returns, after the
+// publisher, e.g. a server that controls the simulation
+func (sim *RouteSim) CloseOnStop(c io.Closer) {
+	sim.closers = append(sim.closers, c)
+}
+
+// Run starts RouteSim ingestion and publishing. It stops if any error occurs,
+// when every emitter has stopped, when the publisher is done, or when ctx is
+// done. Only the first case returns an error. Once it stops, every emitter is
+// stopped and the publisher is flushed and closed.
+func (sim *RouteSim) Run(ctx context.Context) error {
+	posc, wait := sim.fanIn()
+	err := sim.run(ctx, posc)
+	if errors.Is(err, data.ErrPublisherDone) {
+		err = nil
+	}
+	for _, emt := range sim.emitters {
+		emt.Stop()
+	}
+	// Emitters may still be querying their GPSs
+	wait()
+	if cerr := data.Close(sim.publisher); err == nil {
+		err = cerr
+	}
+	for _, c := range sim.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Merges the positions of every emitter into a single channel, which is closed
+// once they all stop. Emitters block on an unbuffered channel until their
+// position is taken, and blocked senders are served in order of arrival, so
+// no emitter starves the others. The returned function waits for every
+// emitter to return.
+func (sim *RouteSim) fanIn() (<-chan gps.Position, func()) {
+	posc := make(chan gps.Position)
+	var wg sync.WaitGroup
+	wg.Add(len(sim.emitters))
+	for _, emt := range sim.emitters {
+		go func(emt Emitter) {
+			defer wg.Done()
+			emt.Emit(posc)
+		}(emt)
+	}
+	go func() {
+		wg.Wait()
+		close(posc)
+	}()
+	return posc, wg.Wait
+}
+
+// Publishes positions as they come, blocking in between
+func (sim *RouteSim) run(ctx context.Context, posc <-chan gps.Position) error {
 	for {
-		for _, emt := range sim.emitters {
-			select {
-			case pos := <-emt.Positions():
-				if err := sim.publisher.PublishPos(pos); err != nil {
-					return err
-				}
-			default:
-				continue
+		select {
+		case <-ctx.Done():
+			return nil
+		case pos, ok := <-posc:
+			if !ok {
+				return nil
+			}
+			// A position may be picked even though ctx is done
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err := sim.publisher.PublishPos(pos); err != nil {
+				return err
 			}
 		}
 	}
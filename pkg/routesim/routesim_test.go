@@ -1,11 +1,14 @@
 package routesim
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"math/rand"
 	"testing"
 
 	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/data"
 	"github.com/gpontesss/routesim/pkg/gps"
 	"github.com/gpontesss/routesim/pkg/gps/gpstest"
 	"github.com/stretchr/testify/assert"
@@ -47,6 +50,12 @@ func testingPublisher(n int) *testPosPub {
 type testPosPub struct {
 	mock.Mock
 	failAt int
+	closed bool
+}
+
+func (pub *testPosPub) Close() error {
+	pub.closed = true
+	return nil
 }
 
 func (pub *testPosPub) PublishPos(pos gps.Position) error {
@@ -64,14 +73,14 @@ func TestRouteSim(t *testing.T) {
 		mock.AnythingOfType("int")).
 		Return(nil)
 
-	emts := []*FreqEmitter{
+	emts := []Emitter{
 		TestingEmitter("TEST0987", RandomLatLngs(5)...),
 		TestingEmitter("TEST1234", RandomLatLngs(5)...),
 	}
 
 	sim := NewRouteSim(emts, pub)
 
-	err := sim.Run()
+	err := sim.Run(context.Background())
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "Failed to publish position")
 
@@ -79,3 +88,59 @@ func TestRouteSim(t *testing.T) {
 	pub.AssertCalled(t, "PublishPos", "TEST0987", mock.AnythingOfType("int"))
 	pub.AssertCalled(t, "PublishPos", "TEST1234", mock.AnythingOfType("int"))
 }
+
+func TestRouteSimStops(t *testing.T) {
+	pub := testingPublisher(-1)
+	pub.On("PublishPos",
+		mock.AnythingOfType("string"),
+		mock.AnythingOfType("int")).
+		Return(nil)
+
+	emts := []Emitter{
+		TestingEmitter("TEST0987", RandomLatLngs(3)...),
+		TestingEmitter("TEST1234", RandomLatLngs(4)...),
+	}
+
+	require.NoError(t, NewRouteSim(emts, pub).Run(context.Background()))
+	pub.AssertNumberOfCalls(t, "PublishPos", 7)
+	assert.True(t, pub.closed)
+}
+
+func TestRouteSimCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pub := testingPublisher(-1)
+	pub.On("PublishPos",
+		mock.AnythingOfType("string"),
+		mock.AnythingOfType("int")).
+		Run(func(args mock.Arguments) {
+			if args.Int(1) == 2 {
+				cancel()
+			}
+		}).
+		Return(nil)
+
+	emts := []Emitter{
+		TestingEmitter("TEST0987", RandomLatLngs(100)...),
+		TestingEmitter("TEST1234", RandomLatLngs(100)...),
+	}
+
+	require.NoError(t, NewRouteSim(emts, pub).Run(ctx))
+	pub.AssertNumberOfCalls(t, "PublishPos", 3)
+	assert.True(t, pub.closed)
+}
+
+func TestRouteSimPublisherDone(t *testing.T) {
+	calls := 0
+	pub := data.PosPublisherFunc(func(gps.Position) error {
+		if calls++; calls == 3 {
+			return fmt.Errorf("Reached count: %w", data.ErrPublisherDone)
+		}
+		return nil
+	})
+
+	emts := []Emitter{TestingEmitter("TEST1234", RandomLatLngs(10)...)}
+	require.NoError(t, NewRouteSim(emts, pub).Run(context.Background()))
+	assert.Equal(t, 3, calls)
+}
@@ -0,0 +1,279 @@
+package routesim
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gpontesss/routesim/pkg/clock"
+	"github.com/gpontesss/routesim/pkg/gps"
+)
+
+// Scheduler emits the positions of many GPSs, each with its own frequency,
+// from a single goroutine and clock ticker. GPSs are kept in a heap keyed on
+// their next emission time. At every tick, the positions of those that are
+// due are queried in a batch and then sent. GPSs may be added, removed,
+// paused and rescheduled while it runs.
+//
+// Emission times are rounded up to the ticker's resolution, so it should
+// divide every frequency. Emissions that fall behind are skipped, as a
+// FreqEmitter's ticker would.
+type Scheduler struct {
+	sync.Mutex
+	clk       clock.Clock
+	ticker    clock.Ticker
+	queue     schedQueue
+	entries   map[string]*schedEntry
+	added     int
+	dueGPSs   []gps.GPS
+	batch     []gps.Position
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+// ScheduledGPS describes a GPS driven by a scheduler
+type ScheduledGPS struct {
+	GPS       gps.GPS
+	Frequency time.Duration
+	// Tells if the GPS's emissions are paused
+	Paused bool
+}
+
+// NewScheduler creates a scheduler whose ticker ticks every resolution of the
+// clock's time
+func NewScheduler(clk clock.Clock, resolution time.Duration) *Scheduler {
+	return &Scheduler{
+		clk:     clk,
+		ticker:  clk.NewTicker(resolution),
+		entries: map[string]*schedEntry{},
+		done:    make(chan struct{}),
+	}
+}
+
+// Add schedules a GPS to emit its position with a frequency, starting a
+// period after the current time. It fails if a GPS with the same ID is
+// already scheduled.
+func (s *Scheduler) Add(gpz gps.GPS, freq time.Duration) error {
+	if freq <= 0 {
+		return fmt.Errorf("Frequency of GPS '%s' must be positive", gpz.ID())
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.entries[gpz.ID()]; ok {
+		return fmt.Errorf("Duplicate GPS ID '%s'", gpz.ID())
+	}
+	ent := &schedEntry{
+		gps:    gpz,
+		period: freq,
+		next:   s.clk.Now().Add(freq),
+		seq:    s.added,
+	}
+	s.entries[gpz.ID()] = ent
+	heap.Push(&s.queue, ent)
+	s.added++
+	return nil
+}
+
+// Remove unschedules a GPS. It returns false if it isn't scheduled.
+func (s *Scheduler) Remove(id string) bool {
+	s.Lock()
+	defer s.Unlock()
+	ent, ok := s.entries[id]
+	if !ok {
+		return false
+	}
+	delete(s.entries, id)
+	if ent.index >= 0 {
+		heap.Remove(&s.queue, ent.index)
+	}
+	return true
+}
+
+// Pause stops a GPS's emissions until it is resumed. It returns false if it
+// isn't scheduled.
+func (s *Scheduler) Pause(id string) bool {
+	s.Lock()
+	defer s.Unlock()
+	ent, ok := s.entries[id]
+	if ok && ent.index >= 0 {
+		heap.Remove(&s.queue, ent.index)
+	}
+	return ok
+}
+
+// Resume restarts a paused GPS's emissions, a period after the current time.
+// It returns false if it isn't scheduled.
+func (s *Scheduler) Resume(id string) bool {
+	s.Lock()
+	defer s.Unlock()
+	ent, ok := s.entries[id]
+	if ok && ent.index < 0 {
+		ent.next = s.clk.Now().Add(ent.period)
+		heap.Push(&s.queue, ent)
+	}
+	return ok
+}
+
+// SetFrequency changes a GPS's frequency. Its next emission happens a period
+// after the current time. It returns false if it isn't scheduled.
+func (s *Scheduler) SetFrequency(id string, freq time.Duration) (bool, error) {
+	if freq <= 0 {
+		return false, fmt.Errorf("Frequency of GPS '%s' must be positive", id)
+	}
+	s.Lock()
+	defer s.Unlock()
+	ent, ok := s.entries[id]
+	if !ok {
+		return false, nil
+	}
+	ent.period = freq
+	ent.next = s.clk.Now().Add(freq)
+	if ent.index >= 0 {
+		heap.Fix(&s.queue, ent.index)
+	}
+	return true, nil
+}
+
+// Get describes a scheduled GPS
+func (s *Scheduler) Get(id string) (ScheduledGPS, bool) {
+	s.Lock()
+	defer s.Unlock()
+	ent, ok := s.entries[id]
+	if !ok {
+		return ScheduledGPS{}, false
+	}
+	return ent.describe(), true
+}
+
+// List describes every scheduled GPS, in the order they were added
+func (s *Scheduler) List() []ScheduledGPS {
+	s.Lock()
+	defer s.Unlock()
+	ents := make([]*schedEntry, 0, len(s.entries))
+	for _, ent := range s.entries {
+		ents = append(ents, ent)
+	}
+	sort.Slice(ents, func(i, j int) bool { return ents[i].seq < ents[j].seq })
+	descs := make([]ScheduledGPS, len(ents))
+	for i, ent := range ents {
+		descs[i] = ent.describe()
+	}
+	return descs
+}
+
+// Len returns the number of scheduled GPSs
+func (s *Scheduler) Len() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.entries)
+}
+
+// Emit sends the positions of the scheduled GPSs to out as they are due. It
+// returns when the scheduler's ticker stops, even if no GPS is scheduled.
+func (s *Scheduler) Emit(out chan<- gps.Position) {
+	started := false
+	s.startOnce.Do(func() { started = true })
+	if !started {
+		return
+	}
+	for {
+		now, ok := s.ticker.Next()
+		if !ok {
+			return
+		}
+		for _, pos := range s.due(now) {
+			select {
+			case out <- pos:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+// Queries the positions of every GPS whose emission is due at a time, and
+// reschedules them. Positions are ordered by emission time, then by the order
+// GPSs were added. They are queried without holding the lock, so slow GPSs
+// don't block changes to the schedule.
+func (s *Scheduler) due(now time.Time) []gps.Position {
+	s.batch = s.batch[:0]
+	for _, gpz := range s.reschedule(now) {
+		s.batch = append(s.batch, gpz.CurrentPos())
+	}
+	return s.batch
+}
+
+// Returns the GPSs whose emission is due at a time, and reschedules them
+func (s *Scheduler) reschedule(now time.Time) []gps.GPS {
+	s.Lock()
+	defer s.Unlock()
+	s.dueGPSs = s.dueGPSs[:0]
+	for len(s.queue) > 0 && !s.queue[0].next.After(now) {
+		ent := s.queue[0]
+		s.dueGPSs = append(s.dueGPSs, ent.gps)
+		ent.next = ent.next.Add(ent.period)
+		if !ent.next.After(now) {
+			// Skips emissions missed while falling behind
+			missed := now.Sub(ent.next)/ent.period + 1
+			ent.next = ent.next.Add(missed * ent.period)
+		}
+		heap.Fix(&s.queue, 0)
+	}
+	return s.dueGPSs
+}
+
+// Stop stops the scheduler and its ticker
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.done)
+		s.ticker.Stop()
+	})
+}
+
+type schedEntry struct {
+	gps    gps.GPS
+	period time.Duration
+	next   time.Time
+	// Order in which the GPS was added, which breaks ties
+	seq int
+	// Position in the queue, or -1 if paused
+	index int
+}
+
+func (ent *schedEntry) describe() ScheduledGPS {
+	return ScheduledGPS{GPS: ent.gps, Frequency: ent.period, Paused: ent.index < 0}
+}
+
+// Min-heap of scheduled GPSs, ordered by next emission time
+type schedQueue []*schedEntry
+
+func (q schedQueue) Len() int { return len(q) }
+
+func (q schedQueue) Less(i, j int) bool {
+	if q[i].next.Equal(q[j].next) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].next.Before(q[j].next)
+}
+
+func (q schedQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+
+func (q *schedQueue) Push(x interface{}) {
+	ent := x.(*schedEntry)
+	ent.index = len(*q)
+	*q = append(*q, ent)
+}
+
+func (q *schedQueue) Pop() interface{} {
+	old := *q
+	ent := old[len(old)-1]
+	ent.index = -1
+	*q = old[:len(old)-1]
+	return ent
+}
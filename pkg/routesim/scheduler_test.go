@@ -0,0 +1,168 @@
+package routesim
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+	"github.com/gpontesss/routesim/pkg/clock"
+	"github.com/gpontesss/routesim/pkg/data"
+	"github.com/gpontesss/routesim/pkg/gps"
+	"github.com/gpontesss/routesim/pkg/gps/gpstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler(t *testing.T) {
+	start := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.Virtual(start, start.Add(4*time.Second))
+
+	sched := NewScheduler(clk, time.Second)
+	require.NoError(t, sched.Add(gpstest.TestGPS("A", RandomLatLngs(4)...), time.Second))
+	require.NoError(t, sched.Add(gpstest.TestGPS("B", RandomLatLngs(2)...), 2*time.Second))
+	require.NoError(t, sched.Add(gpstest.TestGPS("C", RandomLatLngs(4)...), time.Second))
+	assert.Error(t, sched.Add(gpstest.TestGPS("C"), time.Second))
+	assert.Equal(t, 3, sched.Len())
+
+	var (
+		ids   []string
+		times []time.Time
+	)
+	pub := data.PosPublisherFunc(func(pos gps.Position) error {
+		ids = append(ids, pos.GPS.ID())
+		times = append(times, clk.Now())
+		return nil
+	})
+	require.NoError(t, NewRouteSim([]Emitter{sched}, pub).Run(context.Background()))
+
+	assert.Equal(t, []string{"A", "C", "A", "B", "C", "A", "C", "A", "B", "C"}, ids)
+	assert.Equal(t, start.Add(time.Second), times[0])
+	assert.Equal(t, start.Add(4*time.Second), times[len(times)-1])
+}
+
+func TestSchedulerSkipsMissedEmissions(t *testing.T) {
+	start := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.Virtual(start, start.Add(10*time.Second))
+
+	// The resolution is coarser than the frequency, so the GPS emits once
+	// every tick instead of catching up
+	sched := NewScheduler(clk, 5*time.Second)
+	require.NoError(t, sched.Add(gpstest.TestGPS("A", s2.LatLng{}, s2.LatLng{}), time.Second))
+
+	var count int
+	pub := data.PosPublisherFunc(func(gps.Position) error {
+		count++
+		return nil
+	})
+	require.NoError(t, NewRouteSim([]Emitter{sched}, pub).Run(context.Background()))
+	assert.Equal(t, 2, count)
+}
+
+// Clock whose time is set by hand, and whose tickers never tick
+type manualClock struct{ now time.Time }
+
+func (c *manualClock) Now() time.Time                       { return c.now }
+func (c *manualClock) NewTicker(time.Duration) clock.Ticker { return Ticker(0) }
+
+// IDs of the GPSs whose positions are due at a time
+func dueIDs(sched *Scheduler, now time.Time) []string {
+	var ids []string
+	for _, pos := range sched.due(now) {
+		ids = append(ids, pos.GPS.ID())
+	}
+	return ids
+}
+
+func TestSchedulerControls(t *testing.T) {
+	start := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	clk := &manualClock{start}
+	at := func(secs int) time.Time {
+		clk.now = start.Add(time.Duration(secs) * time.Second)
+		return clk.now
+	}
+
+	sched := NewScheduler(clk, time.Second)
+	for _, id := range []string{"A", "B", "C"} {
+		require.NoError(t, sched.Add(gpstest.TestGPS(id, RandomLatLngs(6)...), time.Second))
+	}
+	assert.Equal(t, []string{"A", "B", "C"}, dueIDs(sched, at(1)))
+
+	assert.True(t, sched.Remove("A"))
+	assert.False(t, sched.Remove("A"))
+	assert.True(t, sched.Pause("B"))
+	assert.Equal(t, []string{"C"}, dueIDs(sched, at(2)))
+
+	assert.True(t, sched.Resume("B"))
+	ok, err := sched.SetFrequency("C", 3*time.Second)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"B"}, dueIDs(sched, at(3)))
+	assert.Equal(t, []string{"B"}, dueIDs(sched, at(4)))
+	assert.Equal(t, []string{"B", "C"}, dueIDs(sched, at(5)))
+
+	list := sched.List()
+	require.Len(t, list, 2)
+	assert.Equal(t, "B", list[0].GPS.ID())
+	assert.False(t, list[0].Paused)
+	assert.Equal(t, 3*time.Second, list[1].Frequency)
+	_, ok = sched.Get("A")
+	assert.False(t, ok)
+
+	ok, err = sched.SetFrequency("C", 0)
+	assert.False(t, ok)
+	assert.Error(t, err)
+}
+
+// GPS whose position queries block until released
+type blockingGPS struct {
+	gps.GPS
+	queried, release chan struct{}
+}
+
+func (g *blockingGPS) CurrentPos() gps.Position {
+	g.queried <- struct{}{}
+	<-g.release
+	return g.GPS.CurrentPos()
+}
+
+func TestSchedulerControlsWhileQuerying(t *testing.T) {
+	gpz := &blockingGPS{
+		GPS:     gpstest.TestGPS("A", RandomLatLngs(2)...),
+		queried: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	sched := NewScheduler(clock.Real(), time.Millisecond)
+	require.NoError(t, sched.Add(gpz, time.Millisecond))
+
+	out := make(chan gps.Position, 1)
+	emitted := make(chan struct{})
+	go func() {
+		sched.Emit(out)
+		close(emitted)
+	}()
+	<-gpz.queried
+
+	// A slow GPS doesn't block changes to the schedule
+	controlled := make(chan struct{})
+	go func() {
+		sched.List()
+		sched.SetFrequency("A", time.Second)
+		sched.Pause("A")
+		close(controlled)
+	}()
+	select {
+	case <-controlled:
+	case <-time.After(time.Second):
+		t.Fatal("Scheduler controls blocked while a position was queried")
+	}
+
+	close(gpz.release)
+	<-out
+	sched.Stop()
+	<-emitted
+	list := sched.List()
+	require.Len(t, list, 1)
+	assert.True(t, list[0].Paused)
+	assert.Equal(t, time.Second, list[0].Frequency)
+}